@@ -2,15 +2,24 @@ package app
 
 import (
 	"context"
-	"fmt"
-	"log/slog"
+	"errors"
 	"net/http"
 
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/authenticator"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/encryption"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/federation"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/hasher"
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/jwt"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/lockout"
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/mailer"
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/outbox"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/policy"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/ratelimit"
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/repositories"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 const (
@@ -20,39 +29,186 @@ const (
 
 type ImplApp struct {
 	JWTManager          jwt.JWT
+	IDTokenIssuer       jwt.IDTokenIssuer
 	UserRepo            repositories.UserRepo
+	LoginFailureRepo    repositories.LoginFailureRepo
+	RecoveryCodeRepo    repositories.RecoveryCodeRepo
+	WebAuthnRepo        repositories.WebAuthnRepo
+	EmailLoginRepo      repositories.EmailLoginRepo
+	IdentityRepo        repositories.IdentityRepo
+	OAuthClientRepo     repositories.OAuthClientRepo
+	OAuthTokenRepo      repositories.OAuthTokenRepo
+	ServiceAccountRepo  repositories.ServiceAccountRepo
+	OutboxRepo          repositories.OutboxRepo
+	Transactor          repositories.Transactor
 	Mailer              mailer.Mailer
+	MailTemplates       mailer.Templates
+	OutboxWorker        outbox.Worker
+	SecretEncryptor     encryption.Encryptor
+	WebAuthn            *webauthn.WebAuthn
+	PasswordHasher      hasher.Hasher
+	Pepper              hasher.Pepper
+	Authenticator       authenticator.Authenticator
+	FederationProviders map[string]federation.Provider
+	SAMLProviders       map[string]federation.SAMLProvider
+	PasswordPolicy      policy.PasswordPolicy
+	LockoutPolicy       lockout.LockoutPolicy
+	RateLimiter         ratelimit.Store
+	RateLimits          map[string]ratelimit.RouteLimits
 	Router              *gin.Engine
 	LoginRemoteIPMode   bool
 	RefreshRemoteIPMode bool
 	Domain              string
+	TOTPIssuer          string
+	BaseURL             string
 }
 
 type App interface {
 	Run(addr string) error
 }
 
-func NewApp(
-	jwtManager jwt.JWT,
-	userRepo repositories.UserRepo,
-	mailer mailer.Mailer,
-	loginRemoteIPMode bool,
-	refreshRemoteIPMode bool,
-	domain string,
-) App {
+// Зависимости и параметры приложения (см. NewApp). Поля переносятся в одноименные поля ImplApp
+type Dependencies struct {
+	JWTManager          jwt.JWT
+	IDTokenIssuer       jwt.IDTokenIssuer
+	UserRepo            repositories.UserRepo
+	LoginFailureRepo    repositories.LoginFailureRepo
+	RecoveryCodeRepo    repositories.RecoveryCodeRepo
+	WebAuthnRepo        repositories.WebAuthnRepo
+	EmailLoginRepo      repositories.EmailLoginRepo
+	IdentityRepo        repositories.IdentityRepo
+	OAuthClientRepo     repositories.OAuthClientRepo
+	OAuthTokenRepo      repositories.OAuthTokenRepo
+	ServiceAccountRepo  repositories.ServiceAccountRepo
+	OutboxRepo          repositories.OutboxRepo
+	Transactor          repositories.Transactor
+	Mailer              mailer.Mailer
+	MailTemplates       mailer.Templates
+	OutboxWorker        outbox.Worker
+	SecretEncryptor     encryption.Encryptor
+	WebAuthn            *webauthn.WebAuthn
+	PasswordHasher      hasher.Hasher
+	Pepper              hasher.Pepper
+	Authenticator       authenticator.Authenticator
+	FederationProviders map[string]federation.Provider
+	SAMLProviders       map[string]federation.SAMLProvider
+	PasswordPolicy      policy.PasswordPolicy
+	LockoutPolicy       lockout.LockoutPolicy
+	RateLimiter         ratelimit.Store
+	RateLimits          map[string]ratelimit.RouteLimits
+	Router              *gin.Engine
+	LoginRemoteIPMode   bool
+	RefreshRemoteIPMode bool
+	Domain              string
+	TOTPIssuer          string
+	BaseURL             string
+}
+
+func NewApp(deps Dependencies) App {
 	app := &ImplApp{
-		JWTManager:          jwtManager,
-		UserRepo:            userRepo,
-		Mailer:              mailer,
-		Router:              gin.Default(),
-		LoginRemoteIPMode:   loginRemoteIPMode,
-		RefreshRemoteIPMode: refreshRemoteIPMode,
-		Domain:              domain,
+		JWTManager:          deps.JWTManager,
+		IDTokenIssuer:       deps.IDTokenIssuer,
+		UserRepo:            deps.UserRepo,
+		LoginFailureRepo:    deps.LoginFailureRepo,
+		RecoveryCodeRepo:    deps.RecoveryCodeRepo,
+		WebAuthnRepo:        deps.WebAuthnRepo,
+		EmailLoginRepo:      deps.EmailLoginRepo,
+		IdentityRepo:        deps.IdentityRepo,
+		OAuthClientRepo:     deps.OAuthClientRepo,
+		OAuthTokenRepo:      deps.OAuthTokenRepo,
+		ServiceAccountRepo:  deps.ServiceAccountRepo,
+		OutboxRepo:          deps.OutboxRepo,
+		Transactor:          deps.Transactor,
+		Mailer:              deps.Mailer,
+		MailTemplates:       deps.MailTemplates,
+		OutboxWorker:        deps.OutboxWorker,
+		SecretEncryptor:     deps.SecretEncryptor,
+		WebAuthn:            deps.WebAuthn,
+		PasswordHasher:      deps.PasswordHasher,
+		Pepper:              deps.Pepper,
+		Authenticator:       deps.Authenticator,
+		FederationProviders: deps.FederationProviders,
+		SAMLProviders:       deps.SAMLProviders,
+		PasswordPolicy:      deps.PasswordPolicy,
+		LockoutPolicy:       deps.LockoutPolicy,
+		RateLimiter:         deps.RateLimiter,
+		RateLimits:          deps.RateLimits,
+		Router:              deps.Router,
+		LoginRemoteIPMode:   deps.LoginRemoteIPMode,
+		RefreshRemoteIPMode: deps.RefreshRemoteIPMode,
+		Domain:              deps.Domain,
+		TOTPIssuer:          deps.TOTPIssuer,
+		BaseURL:             deps.BaseURL,
 	}
 	// TODO: сделать нормальную обработку ошибок и нормальные коды возврата
-	app.Router.POST("/register", app.RegisterHandler)
-	app.Router.POST("/login/:guid", app.LoginHandler)
-	app.Router.POST("/refresh", app.RefreshHandler)
+	app.Router.POST("/register", app.RateLimitMiddleware(RateLimitRouteRegister, nil), app.RegisterHandler)
+	app.Router.POST("/login", app.RateLimitMiddleware(RateLimitRouteLogin, PasswordLoginAccountKey), app.PasswordLoginHandler)
+	app.Router.POST("/login/email", app.RateLimitMiddleware(RateLimitRouteLoginEmail, EmailLoginAccountKey), app.EmailLoginHandler)
+	app.Router.GET("/login/email/consume", app.RateLimitMiddleware(RateLimitRouteLoginEmailConsume, nil), app.ConsumeEmailLoginLinkHandler)
+	app.Router.POST("/login/email/consume", app.RateLimitMiddleware(RateLimitRouteLoginEmailConsume, nil), app.ConsumeEmailLoginHandler)
+	app.Router.GET("/login/federated", app.ListFederationProvidersHandler)
+	app.Router.GET("/login/federated/:provider", app.RateLimitMiddleware(RateLimitRouteLoginFederated, nil), app.BeginFederatedLoginHandler)
+	app.Router.GET("/login/federated/:provider/callback", app.RateLimitMiddleware(RateLimitRouteLoginFederated, nil), app.FederatedLoginCallbackHandler)
+	app.Router.GET("/login/saml/:provider", app.RateLimitMiddleware(RateLimitRouteLoginFederated, nil), app.BeginSAMLLoginHandler)
+	app.Router.GET("/login/saml/:provider/metadata", app.SAMLMetadataHandler)
+	app.Router.POST("/login/saml/:provider/acs", app.RateLimitMiddleware(RateLimitRouteLoginFederated, nil), app.SAMLACSHandler)
+	app.Router.POST("/login/:guid", app.RateLimitMiddleware(RateLimitRouteLogin, LoginAccountKey), app.LoginHandler)
+	app.Router.POST("/login/mfa", app.RateLimitMiddleware(RateLimitRouteLoginMFA, nil), app.LoginMFAHandler)
+	app.Router.POST("/login/mfa/webauthn/begin", app.RateLimitMiddleware(RateLimitRouteLoginMFA, nil), app.BeginWebAuthnMFAHandler)
+	app.Router.POST("/login/mfa/webauthn/finish", app.RateLimitMiddleware(RateLimitRouteLoginMFA, nil), app.FinishWebAuthnMFAHandler)
+	app.Router.POST("/login/passkey/begin", app.RateLimitMiddleware(RateLimitRouteLogin, nil), app.BeginPasskeyLoginHandler)
+	app.Router.POST("/login/passkey/finish", app.RateLimitMiddleware(RateLimitRouteLogin, nil), app.FinishPasskeyLoginHandler)
+	app.Router.POST("/refresh", app.RateLimitMiddleware(RateLimitRouteRefresh, app.RefreshAccountKey), app.RefreshHandler)
+	app.Router.POST("/password/change", app.AuthMiddleware, app.ChangePasswordHandler)
+	app.Router.POST("/email/change", app.AuthMiddleware, app.ChangeEmailHandler)
+	app.Router.POST("/locale", app.AuthMiddleware, app.ChangeLocaleHandler)
+	app.Router.GET("/email/confirm", app.ConfirmEmailChangePageHandler)
+	app.Router.POST("/email/confirm", app.ConfirmEmailChangeHandler)
+	app.Router.GET("/email/cancel", app.CancelEmailChangePageHandler)
+	app.Router.POST("/email/cancel", app.CancelEmailChangeHandler)
+	app.Router.GET("/unlock", app.UnlockPageHandler)
+	app.Router.POST("/unlock", app.UnlockHandler)
+	app.Router.POST("/mfa/totp/enroll", app.AuthMiddleware, app.EnrollTOTPHandler)
+	app.Router.POST("/mfa/totp/confirm", app.AuthMiddleware, app.ConfirmTOTPHandler)
+	app.Router.POST("/mfa/totp/disable", app.AuthMiddleware, app.DisableTOTPHandler)
+	app.Router.POST("/mfa/recovery-codes/regenerate", app.AuthMiddleware, app.RegenerateRecoveryCodesHandler)
+	app.Router.POST("/webauthn/register/begin", app.AuthMiddleware, app.RequireRecentAuth, app.BeginWebAuthnRegistrationHandler)
+	app.Router.POST("/webauthn/register/finish", app.AuthMiddleware, app.RequireRecentAuth, app.FinishWebAuthnRegistrationHandler)
+	app.Router.GET("/webauthn/credentials", app.AuthMiddleware, app.ListWebAuthnCredentialsHandler)
+	app.Router.DELETE("/webauthn/credentials/:id", app.AuthMiddleware, app.RequireRecentAuth, app.DeleteWebAuthnCredentialHandler)
+	app.Router.GET("/identities", app.AuthMiddleware, app.ListIdentitiesHandler)
+	app.Router.GET("/identities/link/:provider", app.AuthMiddleware, app.RequireRecentAuth, app.LinkIdentityHandler)
+	app.Router.DELETE("/identities/:id", app.AuthMiddleware, app.RequireRecentAuth, app.UnlinkIdentityHandler)
+	app.Router.POST("/admin/users/:guid/unlock", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.AdminUnlockHandler)
+	app.Router.POST("/admin/oauth/clients", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.CreateOAuthClientHandler)
+	app.Router.GET("/admin/oauth/clients", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.ListOAuthClientsHandler)
+	app.Router.DELETE("/admin/oauth/clients/:id", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.DeleteOAuthClientHandler)
+	app.Router.POST("/admin/service-accounts", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.CreateServiceAccountHandler)
+	app.Router.GET("/admin/service-accounts", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.ListServiceAccountsHandler)
+	app.Router.DELETE("/admin/service-accounts/:id", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.DeleteServiceAccountHandler)
+	app.Router.GET("/admin/outbox", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.ListOutboxHandler)
+	app.Router.GET("/admin/outbox/stats", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.OutboxStatsHandler)
+	app.Router.GET("/admin/outbox/:id", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.GetOutboxMessageHandler)
+	app.Router.POST("/admin/outbox/:id/retry", app.AuthMiddleware, app.RequireRole(models.RoleAdmin), app.RetryOutboxMessageHandler)
+	app.Router.GET("/scim/v2/ServiceProviderConfig", app.SCIMServiceProviderConfigHandler)
+	app.Router.GET("/scim/v2/ResourceTypes", app.SCIMResourceTypesHandler)
+	app.Router.GET("/scim/v2/Schemas", app.SCIMSchemasHandler)
+	app.Router.GET("/scim/v2/Schemas/:id", app.SCIMSchemaHandler)
+	app.Router.GET("/scim/v2/Users", app.SCIMAuthMiddleware, app.SCIMListUsersHandler)
+	app.Router.POST("/scim/v2/Users", app.SCIMAuthMiddleware, app.SCIMCreateUserHandler)
+	app.Router.GET("/scim/v2/Users/:id", app.SCIMAuthMiddleware, app.SCIMGetUserHandler)
+	app.Router.PUT("/scim/v2/Users/:id", app.SCIMAuthMiddleware, app.SCIMReplaceUserHandler)
+	app.Router.PATCH("/scim/v2/Users/:id", app.SCIMAuthMiddleware, app.SCIMPatchUserHandler)
+	app.Router.DELETE("/scim/v2/Users/:id", app.SCIMAuthMiddleware, app.SCIMDeleteUserHandler)
+	app.Router.GET("/authorize", app.AuthorizeHandler)
+	app.Router.POST("/token", app.RateLimitMiddleware(RateLimitRouteOAuthToken, nil), app.TokenHandler)
+	app.Router.POST("/device/code", app.RateLimitMiddleware(RateLimitRouteOAuthDevice, nil), app.DeviceAuthorizationHandler)
+	app.Router.GET("/device", app.AuthMiddleware, app.RateLimitMiddleware(RateLimitRouteDeviceVerify, DeviceVerifyAccountKey), app.DeviceVerificationHandler)
+	app.Router.POST("/device", app.AuthMiddleware, app.RateLimitMiddleware(RateLimitRouteDeviceVerify, DeviceVerifyAccountKey), app.DeviceVerifyHandler)
+	app.Router.GET("/userinfo", app.UserInfoHandler)
+	app.Router.POST("/userinfo", app.UserInfoHandler)
+	app.Router.GET("/.well-known/openid-configuration", app.OpenIDConfigurationHandler)
+	app.Router.GET("/.well-known/jwks.json", app.JWKSHandler)
 
 	return app
 }
@@ -66,6 +222,12 @@ type LoginBody struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// Логином может быть email или имя пользователя внешнего бэкенда
+type PasswordLoginBody struct {
+	Login    string `json:"login" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
 // Обработчик регистрации пользователя, сделан в упрощенном виде, т.к. нужен в основном для проверки работы
 func (a *ImplApp) RegisterHandler(ctx *gin.Context) {
 	body := LoginBody{}
@@ -75,12 +237,20 @@ func (a *ImplApp) RegisterHandler(ctx *gin.Context) {
 		return
 	}
 
-	hashedPassword, err := HashPassword(body.Password)
+	if !a.CheckPasswordPolicy(ctx, body.Password, body.Email) {
+		return
+	}
+
+	hashedPassword, pepperVersion, err := a.HashPassword(body.Password)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	user := models.NewUser(body.Email, hashedPassword)
+	user.PepperVersion = pepperVersion
+	if locale, ok := a.MailTemplates.MatchLocale(ctx.GetHeader("Accept-Language")); ok {
+		user.Locale = locale
+	}
 
 	err = a.UserRepo.Create(ctx, user)
 	if err != nil {
@@ -108,36 +278,90 @@ func (a *ImplApp) LoginHandler(ctx *gin.Context) {
 		return
 	}
 
-	if user.Email != body.Email || !CompareHashAndPassword(user.Password, body.Password) {
+	clientIP := a.GetClientIP(ctx, a.LoginRemoteIPMode)
+	if !a.CheckLoginAllowed(ctx, user, clientIP) {
+		return
+	}
+
+	authUser, err := a.Authenticator.Authenticate(ctx, body.Email, body.Password)
+	if errors.Is(err, authenticator.ErrBackendUnavailable) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": ErrAuthBackendUnavailable.Error()})
+		return
+	}
+	if err != nil || authUser.UserID != user.UserID {
+		a.RegisterLoginFailure(ctx, user, clientIP)
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidCredentials.Error()})
 		return
 	}
 
-	// ctx.ClientIP() вернет не действительный IP, а поле заголовка запроса X-Forwarded-For
-	// Для получения действительного адреса можно вызвать ctx.RemoteIP()
-	clientIP := ctx.ClientIP()
-	if a.LoginRemoteIPMode {
-		clientIP = ctx.RemoteIP()
+	a.completePasswordLogin(ctx, user, body.Password, clientIP)
+}
+
+// Обработчик входа по логину и паролю без guid. Пароль проверяется цепочкой бэкендов (см. authenticator.Authenticator),
+// пользователь внешнего бэкенда создается при первом входе
+func (a *ImplApp) PasswordLoginHandler(ctx *gin.Context) {
+	body := PasswordLoginBody{}
+	// тело читается с сохранением, т.к. его уже мог прочитать PasswordLoginAccountKey
+	err := ctx.ShouldBindBodyWith(&body, binding.JSON)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	clientIP := a.GetClientIP(ctx, a.LoginRemoteIPMode)
+	if !a.CheckIPLoginAllowed(ctx, clientIP) {
+		return
+	}
+
+	user, err := a.Authenticator.Authenticate(ctx, body.Login, body.Password)
+	if errors.Is(err, authenticator.ErrBackendUnavailable) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": ErrAuthBackendUnavailable.Error()})
+		return
+	}
+
+	// блокировка проверяется независимо от результата проверки пароля, чтобы ответ не выдавал его правильность
+	if user != nil && !a.CheckLoginAllowed(ctx, user, clientIP) {
+		return
 	}
-	accessToken, refreshToken, err := a.JWTManager.GenereteTokenPair(ctx.Param("guid"), clientIP) // добавить проверку существования пользователя с полученным guid в бд иначе 404
+
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if user != nil {
+			a.RegisterLoginFailure(ctx, user, clientIP)
+		} else {
+			a.RegisterIPLoginFailure(ctx, clientIP)
+		}
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidCredentials.Error()})
 		return
 	}
 
-	b64token := EncodeTokenToBase64(refreshToken)
-	err = a.SaveRefreshToDB(ctx, b64token, user)
+	a.completePasswordLogin(ctx, user, body.Password, clientIP)
+}
+
+// Завершает вход после успешной проверки пароля: выдает пару токенов или MFA челлендж, если он нужен
+func (a *ImplApp) completePasswordLogin(ctx *gin.Context, user *models.User, password string, clientIP string) {
+	a.RehashPasswordIfNeeded(user, password)
+
+	// при включенном втором факторе счетчик неудачных попыток сбрасывается только после него,
+	// иначе повторный ввод пароля позволял бы перебирать коды без ограничений
+	methods, err := a.MFAMethods(ctx, user)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if len(methods) > 0 {
+		a.StartMFAChallenge(ctx, user, methods)
+		return
+	}
 
-	accessExpiresSec := a.JWTManager.GetAccessExpiresSec()
-	refreshExpiresSec := a.JWTManager.GetRefreshExpiresSec()
+	// сброс счетчиков и новый хеш пароля сохраняются в БД вместе с новым refresh токеном
+	ResetLoginFailures(user)
+	err = a.IssueTokenPair(ctx, user, clientIP)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	// здесь нет ошибки связанной с времени жизни access токена, так как он нужен для /refresh операции
-	ctx.SetCookie(AccessTokenName, accessToken, refreshExpiresSec, "/", a.Domain, false, true)
-	ctx.SetCookie(RefreshTokenName, b64token, refreshExpiresSec, "/", a.Domain, false, true)
+	accessExpiresSec := a.JWTManager.GetAccessExpiresSec()
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message":    MessageSuccessfullyLoggedIn,
@@ -185,41 +409,34 @@ func (a *ImplApp) RefreshHandler(ctx *gin.Context) {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrIncorrectRefreshToken.Error()})
 		return
 	}
-
-	// ctx.ClientIP() вернет не действительный IP, а поле заголовка запроса X-Forwarded-For
-	// Для получения действительного адреса можно вызвать ctx.RemoteIP()
-	clientIP := ctx.ClientIP()
-	if a.RefreshRemoteIPMode {
-		clientIP = ctx.RemoteIP()
-	}
-	if accessClaims.UserIP != clientIP && refreshClims.UserIP != clientIP {
-		go func() {
-			err := a.Mailer.SendMail(
-				user.Email,
-				"Предупреждение о доступе к аккаунту с нового IP адреса",
-				fmt.Sprintf("Доступ к аккаунту был выполнен с неавторизованного IP адреса (%s)\n"+
-					"Если это не вы, то обратитесь к системному администратору", clientIP),
-			)
-			if err != nil {
-				slog.Warn("Failed to send mail", "error", err.Error())
-			}
-		}()
+	// refresh токен сбрасывается при деактивации, но проверка не дает продлить сеанс, сохраненный параллельно с ней
+	if !user.Active {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": ErrAccountDeactivated.Error()})
+		return
 	}
 
+	clientIP := a.GetClientIP(ctx, a.RefreshRemoteIPMode)
+	newIP := accessClaims.UserIP != clientIP && refreshClims.UserIP != clientIP
+
 	accessToken, refreshToken, err = a.JWTManager.RefreshTokenPair(accessClaims, refreshClims, clientIP)
 
 	b64token := EncodeTokenToBase64(refreshToken)
-	err = a.SaveRefreshToDB(ctx, b64token, user)
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if newIP {
+			err := a.EnqueueMail(ctx, user.Email, user.Locale, MailTemplateNewIP, gin.H{"IP": clientIP})
+			if err != nil {
+				return err
+			}
+		}
+		return a.SaveRefreshToDB(ctx, b64token, user)
+	})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	accessExpiresSec := a.JWTManager.GetAccessExpiresSec()
-	refreshExpiresSec := a.JWTManager.GetRefreshExpiresSec()
-
-	ctx.SetCookie(AccessTokenName, accessToken, refreshExpiresSec, "/", a.Domain, false, true)
-	ctx.SetCookie(RefreshTokenName, b64token, refreshExpiresSec, "/", a.Domain, false, true)
+	a.SetTokenCookies(ctx, accessToken, b64token)
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message":    MessafeSuccessfullyRefreshed,
@@ -241,3 +458,41 @@ func (a *ImplApp) SaveRefreshToDB(ctx context.Context, b64refresh string, user *
 
 	return nil
 }
+
+// Создает новую пару токенов для пользователя, сохраняет хеш refresh токена в БД и устанавливает cookie с токенами
+func (a *ImplApp) IssueTokenPair(ctx *gin.Context, user *models.User, clientIP string) error {
+	accessToken, refreshToken, err := a.JWTManager.GenereteTokenPair(user.UserID.String(), clientIP)
+	if err != nil {
+		return err
+	}
+
+	b64token := EncodeTokenToBase64(refreshToken)
+	err = a.SaveRefreshToDB(ctx, b64token, user)
+	if err != nil {
+		return err
+	}
+
+	a.SetTokenCookies(ctx, accessToken, b64token)
+	return nil
+}
+
+// Устанавливает cookie с access и refresh (в base64) токенами
+func (a *ImplApp) SetTokenCookies(ctx *gin.Context, accessToken string, b64refresh string) {
+	refreshExpiresSec := a.JWTManager.GetRefreshExpiresSec()
+
+	// здесь нет ошибки связанной с времени жизни access токена, так как он нужен для /refresh операции
+	ctx.SetCookie(AccessTokenName, accessToken, refreshExpiresSec, "/", a.Domain, false, true)
+	ctx.SetCookie(RefreshTokenName, b64refresh, refreshExpiresSec, "/", a.Domain, false, true)
+}
+
+// Возвращает IP клиента.
+//
+// ctx.ClientIP() берет адрес из заголовков X-Forwarded-For и X-Real-IP, только если запрос пришел от доверенного
+// прокси (см. gin.Engine.SetTrustedProxies), иначе возвращает адрес соединения.
+// Для получения адреса соединения всегда (remoteIPMode) вызывается ctx.RemoteIP()
+func (a *ImplApp) GetClientIP(ctx *gin.Context, remoteIPMode bool) string {
+	if remoteIPMode {
+		return ctx.RemoteIP()
+	}
+	return ctx.ClientIP()
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	htmltemplate "html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Страница подтверждения действия по ссылке из письма. Без action и token показывает только сообщение
+var confirmPageTemplate = htmltemplate.Must(htmltemplate.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Text}}<p>{{.Text}}</p>{{end}}
+{{if .Action}}<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">{{.Button}}</button>
+</form>{{end}}
+</body>
+</html>
+`))
+
+type confirmPage struct {
+	Title  string
+	Text   string
+	Action string
+	Token  string
+	Button string
+}
+
+// Токен действия по ссылке из письма: из формы страницы подтверждения или из JSON
+type ConfirmTokenBody struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// Отдает страницу подтверждения действия по ссылке из письма.
+//
+// Ссылки из писем открываются GET запросом, в том числе сканерами почты и предзагрузкой ссылок,
+// поэтому GET только показывает форму, а само действие выполняется ее отправкой (POST)
+func (a *ImplApp) RenderConfirmPage(ctx *gin.Context, status int, title string, text string, path string, token string, button string) {
+	page := confirmPage{
+		Title: title,
+		Text:  text,
+	}
+	if token != "" {
+		page.Action = a.BaseURL + path
+		page.Token = token
+		page.Button = button
+	}
+
+	renderPage(ctx, status, page)
+}
+
+// Читает токен действия из тела POST запроса. Возвращает пустую строку, если токена нет
+func BindConfirmToken(ctx *gin.Context) string {
+	body := ConfirmTokenBody{}
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		return ""
+	}
+	return body.Token
+}
+
+// Отвечает на POST запрос действия по ссылке из письма: страницей, если он отправлен формой страницы подтверждения,
+// иначе JSON с сообщением message или ошибкой err
+func RespondConfirmResult(ctx *gin.Context, status int, message string, err error) {
+	if !isFormRequest(ctx) {
+		if err != nil {
+			ctx.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(status, gin.H{"message": message})
+		return
+	}
+
+	if err != nil {
+		message = err.Error()
+	}
+	renderPage(ctx, status, confirmPage{Title: message})
+}
+
+func renderPage(ctx *gin.Context, status int, page confirmPage) {
+	// токен из ссылки не должен попасть в кеш, заголовок Referer или на чужую страницу во фрейме
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Referrer-Policy", "no-referrer")
+	ctx.Header("X-Frame-Options", "DENY")
+	ctx.Header("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+
+	builder := strings.Builder{}
+	err := confirmPageTemplate.Execute(&builder, page)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Data(status, "text/html; charset=utf-8", []byte(builder.String()))
+}
+
+func isFormRequest(ctx *gin.Context) bool {
+	return ctx.ContentType() == binding.MIMEPOSTForm
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/jwt"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/oauth"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// Размер в байтах случайного device_code
+	deviceCodeSize = 32
+	// Алфавит user_code: только согласные без похожих друг на друга символов (RFC 8628, раздел 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	// Длина user_code без разделителя. 20^8 вариантов достаточно при ограничении частоты ввода и сроке жизни кода
+	userCodeLength = 8
+	// Количество попыток сгенерировать незанятый user_code
+	userCodeAttempts = 3
+)
+
+var (
+	// Время, в течении которого пользователь может подтвердить запрос авторизации устройства
+	DeviceCodeExpires = 10 * time.Minute
+	// Минимальный интервал опроса /token клиентом
+	DeviceCodeInterval = 5 * time.Second
+	// Увеличение интервала опроса при ответе slow_down
+	DeviceCodeSlowDownIncrement = 5 * time.Second
+)
+
+type DeviceVerifyBody struct {
+	UserCode string `json:"user_code" binding:"required"`
+	Approve  bool   `json:"approve"`
+}
+
+// Обработчик запроса авторизации устройства (RFC 8628, раздел 3.1). Клиент аутентифицируется так же, как в /token.
+//
+// Возвращает device_code для опроса /token и user_code, который пользователь вводит на странице подтверждения
+func (a *ImplApp) DeviceAuthorizationHandler(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	client, oauthErr := a.authenticateOAuthClient(ctx)
+	if oauthErr != nil {
+		respondInvalidClient(ctx, oauthErr)
+		return
+	}
+
+	scopes := oauth.ParseScope(ctx.PostForm("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !oauth.ScopeAllowed(scopes, client.Scopes) {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidScope, ""))
+		return
+	}
+
+	deviceCode, err := GenerateRandomToken(deviceCodeSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, oauth.NewError(oauth.ErrorServerError, ""))
+		return
+	}
+
+	var userCode string
+	for range userCodeAttempts {
+		userCode, err = generateUserCode()
+		if err != nil {
+			break
+		}
+
+		err = a.OAuthTokenRepo.CreateDeviceCode(ctx, &models.OAuthDeviceCode{
+			DeviceCodeHash: HashTokenSHA256(deviceCode),
+			UserCode:       userCode,
+			ClientID:       client.ClientID,
+			Scope:          oauth.JoinScope(scopes),
+			Status:         models.DeviceCodeStatusPending,
+			Interval:       int(DeviceCodeInterval / time.Second),
+			ExpiresAt:      time.Now().Add(DeviceCodeExpires),
+		})
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			break
+		}
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, oauth.NewError(oauth.ErrorServerError, ""))
+		return
+	}
+
+	verificationURI := a.BaseURL + "/device"
+	formattedUserCode := formatUserCode(userCode)
+	ctx.JSON(http.StatusOK, gin.H{
+		"device_code":               deviceCode,
+		"user_code":                 formattedUserCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?" + url.Values{"user_code": {formattedUserCode}}.Encode(),
+		"expires_in":                int(DeviceCodeExpires / time.Second),
+		"interval":                  int(DeviceCodeInterval / time.Second),
+	})
+}
+
+// Обработчик страницы подтверждения устройства. Требует аутентификации по access токену (см. AuthMiddleware).
+//
+// Возвращает клиента и scope запроса с переданным user_code, чтобы пользователь мог проверить, что подтверждает
+func (a *ImplApp) DeviceVerificationHandler(ctx *gin.Context) {
+	code, err := a.OAuthTokenRepo.FindPendingDeviceCode(ctx, normalizeUserCode(ctx.Query("user_code")))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrInvalidUserCode.Error()})
+		return
+	}
+
+	client, err := a.OAuthClientRepo.FindByID(ctx, code.ClientID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrInvalidUserCode.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"user_code":   formatUserCode(code.UserCode),
+		"client_id":   client.ClientID,
+		"client_name": client.Name,
+		"scope":       code.Scope,
+		"expires_at":  code.ExpiresAt,
+	})
+}
+
+// Обработчик подтверждения или отклонения запроса авторизации устройства пользователем.
+// Требует аутентификации по access токену (см. AuthMiddleware). Решение по каждому user_code принимается один раз
+func (a *ImplApp) DeviceVerifyHandler(ctx *gin.Context) {
+	body := DeviceVerifyBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	// в токены устройства передается время аутентификации пользователя в самом сервисе
+	authTime := AccessAuthTime(ctx.MustGet(AccessClaimsContextKey).(*jwt.AccessClaims))
+
+	decided, err := a.OAuthTokenRepo.DecideDeviceCode(ctx, normalizeUserCode(body.UserCode), user.UserID, authTime, body.Approve)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !decided {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrInvalidUserCode.Error()})
+		return
+	}
+
+	message := MessageDeviceDenied
+	if body.Approve {
+		message = MessageDeviceApproved
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// Обрабатывает опрос /token по device_code. Пока пользователь не принял решение, отвечает authorization_pending
+// (код ошибки из RFC 8628, см. oauth.ErrorAuthorizationPending), при слишком частом опросе - slow_down. После подтверждения выдает обычную пару токенов клиента
+func (a *ImplApp) exchangeDeviceCode(ctx *gin.Context, client *models.OAuthClient) {
+	deviceCode := ctx.PostForm("device_code")
+	if deviceCode == "" {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidRequest, "device_code is required"))
+		return
+	}
+
+	code, slowDown, err := a.OAuthTokenRepo.PollDeviceCode(ctx, HashTokenSHA256(deviceCode), client.ClientID, DeviceCodeSlowDownIncrement)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidGrant, ""))
+		return
+	}
+
+	switch {
+	case !time.Now().Before(code.ExpiresAt):
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorExpiredToken, ""))
+	case code.Status == models.DeviceCodeStatusDenied:
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorAccessDenied, ""))
+	case code.Status == models.DeviceCodeStatusPending && slowDown:
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorSlowDown, ""))
+	case code.Status == models.DeviceCodeStatusPending:
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorAuthorizationPending, ""))
+	default:
+		user, err := a.UserRepo.FindByID(ctx, *code.UserID)
+		if err != nil || !user.Active {
+			ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidGrant, ""))
+			return
+		}
+		a.issueOAuthTokens(ctx, client, user, code.Scope, *code.AuthTime, "")
+	}
+}
+
+// Функция получения идентификатора аккаунта для лимита ввода user_code. Используется после AuthMiddleware
+func DeviceVerifyAccountKey(ctx *gin.Context) string {
+	return ctx.GetString(UserIDContextKey)
+}
+
+// Генерирует случайный user_code из userCodeAlphabet
+func generateUserCode() (string, error) {
+	alphabetSize := big.NewInt(int64(len(userCodeAlphabet)))
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Приводит введенный пользователем user_code к виду, в котором он хранится в БД: верхний регистр без разделителей
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
+
+// Форматирует user_code для показа пользователю: две половины через дефис
+func formatUserCode(userCode string) string {
+	half := len(userCode) / 2
+	return userCode[:half] + "-" + userCode[half:]
+}
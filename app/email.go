@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	// Время в течении которого действительны ссылки подтверждения и отмены смены email
+	EmailChangeExpires = 24 * time.Hour
+)
+
+// Размер в байтах случайных токенов подтверждения и отмены смены email
+const emailChangeTokenSize = 32
+
+type ChangeEmailBody struct {
+	NewEmail string `json:"new_email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Обработчик запроса смены email. Требует аутентификации по access токену (см. AuthMiddleware).
+//
+// Новый адрес сохраняется как ожидающий подтверждения, на него отправляется ссылка подтверждения,
+// а на старый адрес - ссылка отмены. Сам email меняется только в ConfirmEmailChangeHandler
+func (a *ImplApp) ChangeEmailHandler(ctx *gin.Context) {
+	body := ChangeEmailBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	if !a.CompareHashAndPassword(user.Password, user.PepperVersion, body.Password) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidCredentials.Error()})
+		return
+	}
+
+	newEmail := models.NormalizeEmail(body.NewEmail)
+	if newEmail == models.NormalizeEmail(user.Email) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrSameEmail.Error()})
+		return
+	}
+
+	// Предварительная проверка, окончательно уникальность гарантируется индексом при подтверждении
+	_, err = a.UserRepo.FindByEmail(ctx, newEmail)
+	if err == nil {
+		ctx.JSON(http.StatusConflict, gin.H{"error": ErrEmailTaken.Error()})
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	confirmToken, err := GenerateRandomToken(emailChangeTokenSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cancelToken, err := GenerateRandomToken(emailChangeTokenSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiresAt := time.Now().Add(EmailChangeExpires)
+	user.PendingEmail = newEmail
+	user.EmailConfirmToken = HashTokenSHA256(confirmToken)
+	user.EmailCancelToken = HashTokenSHA256(cancelToken)
+	user.EmailChangeExpiresAt = &expiresAt
+
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := a.UserRepo.Update(ctx, user)
+		if err != nil {
+			return err
+		}
+		err = a.EnqueueExpiringMail(ctx, user.PendingEmail, user.Locale, MailTemplateEmailChangeConfirm, gin.H{
+			"Link": fmt.Sprintf("%s/email/confirm?token=%s", a.BaseURL, confirmToken),
+		}, expiresAt)
+		if err != nil {
+			return err
+		}
+		return a.EnqueueExpiringMail(ctx, user.Email, user.Locale, MailTemplateEmailChangeRequested, gin.H{
+			"NewEmail": user.PendingEmail,
+			"Link":     fmt.Sprintf("%s/email/cancel?token=%s", a.BaseURL, cancelToken),
+		}, expiresAt)
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{"message": MessageEmailChangeRequested})
+}
+
+// Обработчик ссылки подтверждения смены email из письма, отправленного на новый адрес.
+// Показывает страницу подтверждения, сам email меняется только отправкой ее формы (см. ConfirmEmailChangeHandler)
+func (a *ImplApp) ConfirmEmailChangePageHandler(ctx *gin.Context) {
+	token := ctx.Query("token")
+	_, err := a.findUserByEmailChangeToken(ctx, a.UserRepo.FindByEmailConfirmToken, token)
+	if err != nil {
+		a.RenderConfirmPage(ctx, http.StatusBadRequest, err.Error(), "", "", "", "")
+		return
+	}
+
+	a.RenderConfirmPage(ctx, http.StatusOK, PageTitleConfirmEmailChange, PageTextConfirmEmailChange, "/email/confirm", token, PageButtonConfirmEmail)
+}
+
+// Обработчик подтверждения смены email. Принимает токен из ссылки, отправленной на новый адрес, формой или JSON
+func (a *ImplApp) ConfirmEmailChangeHandler(ctx *gin.Context) {
+	user, err := a.findUserByEmailChangeToken(ctx, a.UserRepo.FindByEmailConfirmToken, BindConfirmToken(ctx))
+	if err != nil {
+		RespondConfirmResult(ctx, http.StatusBadRequest, "", err)
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = user.PendingEmail
+	user.EmailVerified = true
+	resetEmailChange(user)
+
+	// Между запросом и подтверждением адрес мог занять другой аккаунт (или подтвердить его раньше),
+	// в таком случае сработает уникальный индекс и запрос на смену отменяется
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := a.UserRepo.Update(ctx, user)
+		if err != nil {
+			return err
+		}
+		return a.EnqueueMail(ctx, oldEmail, user.Locale, MailTemplateEmailChanged, gin.H{"NewEmail": user.Email})
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		a.cancelEmailChange(ctx, user.UserID.String())
+		RespondConfirmResult(ctx, http.StatusConflict, "", ErrEmailTaken)
+		return
+	}
+	if err != nil {
+		RespondConfirmResult(ctx, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	RespondConfirmResult(ctx, http.StatusOK, MessageEmailChanged, nil)
+}
+
+// Обработчик ссылки отмены смены email из письма, отправленного на старый адрес.
+// Показывает страницу подтверждения, запрос отменяется только отправкой ее формы (см. CancelEmailChangeHandler)
+func (a *ImplApp) CancelEmailChangePageHandler(ctx *gin.Context) {
+	token := ctx.Query("token")
+	_, err := a.findUserByEmailChangeToken(ctx, a.UserRepo.FindByEmailCancelToken, token)
+	if err != nil {
+		a.RenderConfirmPage(ctx, http.StatusBadRequest, err.Error(), "", "", "", "")
+		return
+	}
+
+	a.RenderConfirmPage(ctx, http.StatusOK, PageTitleCancelEmailChange, PageTextCancelEmailChange, "/email/cancel", token, PageButtonCancelEmail)
+}
+
+// Обработчик отмены смены email. Принимает токен из ссылки, отправленной на старый адрес, формой или JSON
+func (a *ImplApp) CancelEmailChangeHandler(ctx *gin.Context) {
+	user, err := a.findUserByEmailChangeToken(ctx, a.UserRepo.FindByEmailCancelToken, BindConfirmToken(ctx))
+	if err != nil {
+		RespondConfirmResult(ctx, http.StatusBadRequest, "", err)
+		return
+	}
+
+	resetEmailChange(user)
+	err = a.UserRepo.Update(ctx, user)
+	if err != nil {
+		RespondConfirmResult(ctx, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	RespondConfirmResult(ctx, http.StatusOK, MessageEmailChangeCanceled, nil)
+}
+
+// Находит пользователя по токену из письма и проверяет, что запрос на смену email еще действителен
+func (a *ImplApp) findUserByEmailChangeToken(
+	ctx *gin.Context,
+	find func(ctx context.Context, tokenHash string) (*models.User, error),
+	token string,
+) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	user, err := find(ctx, HashTokenSHA256(token))
+	if err != nil {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	if user.EmailChangeExpiresAt == nil || time.Now().After(*user.EmailChangeExpiresAt) {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	return user, nil
+}
+
+// Сбрасывает запрос на смену email, выполняется повторным чтением, так как обьект мог быть изменен
+func (a *ImplApp) cancelEmailChange(ctx *gin.Context, userID string) {
+	user, err := a.UserRepo.FindByIDString(ctx, userID)
+	if err != nil {
+		return
+	}
+	resetEmailChange(user)
+	err = a.UserRepo.Update(ctx, user)
+	if err != nil {
+		slog.Warn("Failed to reset email change", "error", err.Error())
+	}
+}
+
+// Очищает поля запроса на смену email
+func resetEmailChange(user *models.User) {
+	user.PendingEmail = ""
+	user.EmailConfirmToken = ""
+	user.EmailCancelToken = ""
+	user.EmailChangeExpiresAt = nil
+}
@@ -0,0 +1,257 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+const (
+	// Название cookie, привязывающей запрос входа по email к браузеру
+	EmailLoginBindingCookieName = "email_login_binding"
+	// Количество цифр в коде входа по email
+	EmailLoginCodeDigits = 6
+	// Количество попыток ввода кода, после которого он становится недействительным
+	EmailLoginMaxAttempts = 5
+	// Размер в байтах случайного токена ссылки и значения cookie привязки
+	emailLoginTokenSize = 32
+)
+
+var (
+	// Время жизни ссылки и кода входа по email
+	EmailLoginExpires = 15 * time.Minute
+)
+
+type EmailLoginBody struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// Для входа нужен либо токен из ссылки, либо код из письма
+type ConsumeEmailLoginBody struct {
+	Token string `json:"token" binding:"required_without=Code"`
+	Code  string `json:"code" binding:"required_without=Token"`
+}
+
+// Обработчик запроса входа по email без пароля. Отправляет на адрес пользователя одноразовую ссылку и код.
+//
+// Запрос привязывается к браузеру через cookie, поэтому воспользоваться ссылкой или кодом можно только из него.
+// Ответ не зависит от того, существует ли пользователь с таким email: поиск пользователя и создание запроса
+// выполняются после ответа, чтобы и время ответа не выдавало существование аккаунта
+func (a *ImplApp) EmailLoginHandler(ctx *gin.Context) {
+	body := EmailLoginBody{}
+	// тело читается с сохранением, т.к. его уже мог прочитать EmailLoginAccountKey
+	err := ctx.ShouldBindBodyWith(&body, binding.JSON)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	bindingToken, err := GenerateRandomToken(emailLoginTokenSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// контекст запроса отменяется после ответа, а письмо должно быть поставлено в очередь и после него
+	go a.sendEmailLogin(context.WithoutCancel(ctx.Request.Context()), body.Email, HashTokenSHA256(bindingToken))
+
+	ctx.SetCookie(EmailLoginBindingCookieName, bindingToken, int(EmailLoginExpires.Seconds()), "/login/email", a.Domain, false, true)
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    MessageEmailLoginSent,
+		"expires_in": int(EmailLoginExpires.Seconds()),
+	})
+}
+
+// Обработчик входа по ссылке из письма
+func (a *ImplApp) ConsumeEmailLoginLinkHandler(ctx *gin.Context) {
+	token := ctx.Query("token")
+	if token == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidEmailLoginToken.Error()})
+		return
+	}
+
+	a.consumeEmailLogin(ctx, token, "")
+}
+
+// Обработчик входа по токену из ссылки или коду из письма
+func (a *ImplApp) ConsumeEmailLoginHandler(ctx *gin.Context) {
+	body := ConsumeEmailLoginBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	a.consumeEmailLogin(ctx, body.Token, body.Code)
+}
+
+// Идентификатор аккаунта для /login/email - email из тела запроса
+func EmailLoginAccountKey(ctx *gin.Context) string {
+	body := EmailLoginBody{}
+	err := ctx.ShouldBindBodyWith(&body, binding.JSON)
+	if err != nil {
+		return ""
+	}
+	return models.NormalizeEmail(body.Email)
+}
+
+// Создает запрос входа пользователя с адресом email и отправляет письмо со ссылкой и кодом.
+// Ранее отправленные ссылки и коды остаются действительными, чтобы чужие запросы на тот же адрес не могли их отменить.
+// Выполняется в фоне после ответа EmailLoginHandler, поэтому ошибки только логируются
+func (a *ImplApp) sendEmailLogin(ctx context.Context, email string, bindingHash string) {
+	user, err := a.UserRepo.FindByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to find user for email login", "error", err.Error())
+		return
+	}
+
+	token, err := GenerateRandomToken(emailLoginTokenSize)
+	if err != nil {
+		slog.Warn("Failed to generate email login token", "error", err.Error())
+		return
+	}
+	code, err := generateNumericCode(EmailLoginCodeDigits)
+	if err != nil {
+		slog.Warn("Failed to generate email login code", "error", err.Error())
+		return
+	}
+	codeHash, err := HashToken(code)
+	if err != nil {
+		slog.Warn("Failed to hash email login code", "error", err.Error())
+		return
+	}
+
+	expiresAt := time.Now().Add(EmailLoginExpires)
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := a.EmailLoginRepo.Create(ctx, models.NewEmailLogin(user.UserID, HashTokenSHA256(token), codeHash, bindingHash, expiresAt))
+		if err != nil {
+			return err
+		}
+		return a.EnqueueExpiringMail(ctx, user.Email, user.Locale, MailTemplateEmailLogin, gin.H{
+			"Link":      fmt.Sprintf("%s/login/email/consume?token=%s", a.BaseURL, url.QueryEscape(token)),
+			"Code":      code,
+			"ExpiresAt": expiresAt,
+		}, expiresAt)
+	})
+	if err != nil {
+		slog.Warn("Failed to send email login", "error", err.Error())
+	}
+}
+
+// Проверяет токен или код вместе с cookie привязки и выдает пару токенов (или MFA челлендж, если он нужен).
+// Запрос входа одноразовый, неверные коды учитываются в его счетчике попыток
+func (a *ImplApp) consumeEmailLogin(ctx *gin.Context, token string, code string) {
+	bindingToken, err := ctx.Cookie(EmailLoginBindingCookieName)
+	if err != nil || bindingToken == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrEmailLoginBrowserMismatch.Error()})
+		return
+	}
+	bindingHash := HashTokenSHA256(bindingToken)
+
+	var login *models.EmailLogin
+	if token != "" {
+		login, err = a.EmailLoginRepo.TakeByToken(ctx, HashTokenSHA256(token), bindingHash)
+	} else {
+		login, err = a.takeEmailLoginByCode(ctx, bindingHash, code)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidEmailLoginToken.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByID(ctx, login.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	clientIP := a.GetClientIP(ctx, a.LoginRemoteIPMode)
+	if !a.CheckLoginAllowed(ctx, user, clientIP) {
+		return
+	}
+
+	ctx.SetCookie(EmailLoginBindingCookieName, "", -1, "/login/email", a.Domain, false, true)
+
+	// вход выполнен, остальные отправленные пользователю ссылки и коды больше не нужны
+	err = a.EmailLoginRepo.DeleteByUserID(ctx, user.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// письмо со ссылкой или кодом получено, значит адрес принадлежит пользователю.
+	// Изменение сохраняется в БД вместе с MFA челленджем или новым refresh токеном
+	user.EmailVerified = true
+
+	methods, err := a.MFAMethods(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(methods) > 0 {
+		a.StartMFAChallenge(ctx, user, methods)
+		return
+	}
+
+	ResetLoginFailures(user)
+	err = a.IssueTokenPair(ctx, user, clientIP)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    MessageSuccessfullyLoggedIn,
+		"expires_in": a.JWTManager.GetAccessExpiresSec(),
+	})
+}
+
+// Находит запрос входа браузера и проверяет код. Попытка учитывается до проверки,
+// а успешно проверенный запрос удаляется, чтобы код нельзя было использовать повторно
+func (a *ImplApp) takeEmailLoginByCode(ctx *gin.Context, bindingHash string, code string) (*models.EmailLogin, error) {
+	login, err := a.EmailLoginRepo.FindByBinding(ctx, bindingHash)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := a.EmailLoginRepo.IncrementAttempts(ctx, login.ID, EmailLoginMaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed || !CompareHashAndToken(login.CodeHash, code) {
+		return nil, ErrInvalidEmailLoginToken
+	}
+
+	deleted, err := a.EmailLoginRepo.Delete(ctx, login.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, ErrInvalidEmailLoginToken
+	}
+	return login, nil
+}
+
+// Генерирует случайный код из digits десятичных цифр
+func generateNumericCode(digits int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+)
+
+func requestEmailLogin(t *testing.T, a *ImplApp, email string) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodPost, "/login/email", bytes.NewReader([]byte(`{"email":"`+email+`"}`)))
+	request.Header.Set("Content-Type", "application/json")
+	ctx, recorder := newTestContext(request, "")
+	a.EmailLoginHandler(ctx)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("email login: status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+	return recorder
+}
+
+func TestEmailLoginKeepsEarlierLinksValid(t *testing.T) {
+	user := models.NewUser("user@example.com", "password hash")
+	a := newTestApp(t, user)
+	logins := a.EmailLoginRepo.(*fakeEmailLoginRepo)
+	outbox := a.OutboxRepo.(*fakeOutboxRepo)
+
+	requestEmailLogin(t, a, "user@example.com")
+	waitFor(t, func() bool { return len(outbox.templates()) == 1 })
+	requestEmailLogin(t, a, "USER@example.com")
+	waitFor(t, func() bool { return len(outbox.templates()) == 2 })
+
+	if logins.count() != 2 {
+		t.Fatalf("expected both email login requests to stay valid, got %d", logins.count())
+	}
+	want := []string{MailTemplateEmailLogin, MailTemplateEmailLogin}
+	if got := outbox.templates(); !slices.Equal(got, want) {
+		t.Fatalf("expected mails %v, got %v", want, got)
+	}
+}
+
+func TestEmailLoginResponseDoesNotRevealAccount(t *testing.T) {
+	user := models.NewUser("user@example.com", "password hash")
+	a := newTestApp(t, user)
+
+	known := requestEmailLogin(t, a, "user@example.com")
+	unknown := requestEmailLogin(t, a, "nobody@example.com")
+	waitFor(t, func() bool { return len(a.OutboxRepo.(*fakeOutboxRepo).templates()) == 1 })
+
+	if known.Body.String() != unknown.Body.String() {
+		t.Fatalf("responses differ: %s and %s", known.Body.String(), unknown.Body.String())
+	}
+	if len(known.Result().Cookies()) != 1 || len(unknown.Result().Cookies()) != 1 {
+		t.Fatalf("expected a binding cookie in both responses")
+	}
+	if a.EmailLoginRepo.(*fakeEmailLoginRepo).count() != 1 {
+		t.Fatalf("expected a request only for the existing account")
+	}
+}
+
+func TestEmailLoginAccountKeyNormalizesEmail(t *testing.T) {
+	keys := map[string]bool{}
+	for _, email := range []string{"user@example.com", "USER@Example.com"} {
+		request := httptest.NewRequest(http.MethodPost, "/login/email", bytes.NewReader([]byte(`{"email":"`+email+`"}`)))
+		request.Header.Set("Content-Type", "application/json")
+		ctx, _ := newTestContext(request, "")
+		keys[EmailLoginAccountKey(ctx)] = true
+	}
+	if len(keys) != 1 || !keys["user@example.com"] {
+		t.Fatalf("expected one normalized key, got %v", keys)
+	}
+}
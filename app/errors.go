@@ -3,10 +3,56 @@ package app
 import "errors"
 
 var (
-	ErrInvalidRequestData = errors.New("invalid request data")
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUserNotFound = errors.New("user not found")
-	ErrRefreshTokenRequired = errors.New("refresh token is required")
-	ErrAccessTokenRequired = errors.New("access token is required")
-	ErrIncorrectRefreshToken = errors.New("incorrect refresh token")
-)
\ No newline at end of file
+	ErrInvalidRequestData          = errors.New("invalid request data")
+	ErrInvalidCredentials          = errors.New("invalid credentials")
+	ErrUserNotFound                = errors.New("user not found")
+	ErrRefreshTokenRequired        = errors.New("refresh token is required")
+	ErrAccessTokenRequired         = errors.New("access token is required")
+	ErrIncorrectRefreshToken       = errors.New("incorrect refresh token")
+	ErrUnauthorized                = errors.New("unauthorized")
+	ErrPasswordPolicy              = errors.New("password does not satisfy policy")
+	ErrSameEmail                   = errors.New("new email is the same as current")
+	ErrEmailTaken                  = errors.New("email is already taken")
+	ErrInvalidEmailChangeToken     = errors.New("invalid or expired email change token")
+	ErrForbidden                   = errors.New("forbidden")
+	ErrAccountLocked               = errors.New("account is temporarily locked")
+	ErrTooManyLoginAttempts        = errors.New("too many login attempts, try again later")
+	ErrInvalidUnlockToken          = errors.New("invalid or expired unlock token")
+	ErrRateLimitExceeded           = errors.New("rate limit exceeded")
+	ErrRateLimitUnavailable        = errors.New("rate limiter is unavailable, try again later")
+	ErrTOTPAlreadyEnabled          = errors.New("totp is already enabled")
+	ErrTOTPNotEnrolled             = errors.New("totp is not enrolled")
+	ErrInvalidMFACode              = errors.New("invalid mfa code")
+	ErrInvalidMFAToken             = errors.New("invalid or expired mfa token")
+	ErrInvalidWebAuthnSession      = errors.New("invalid or expired webauthn session")
+	ErrWebAuthnVerificationFailed  = errors.New("webauthn verification failed")
+	ErrWebAuthnNotEnrolled         = errors.New("no passkeys registered")
+	ErrWebAuthnCredentialNotFound  = errors.New("passkey not found")
+	ErrInvalidEmailLoginToken      = errors.New("invalid or expired login link or code")
+	ErrEmailLoginBrowserMismatch   = errors.New("login must be completed in the browser where it was requested")
+	ErrExternalAccount             = errors.New("password of this account is managed by an external backend")
+	ErrAuthBackendUnavailable      = errors.New("authentication backend is unavailable")
+	ErrInvalidRedirectURI          = errors.New("redirect uri must be absolute, without fragment, and use http only for loopback")
+	ErrOAuthClientNotFound         = errors.New("oauth client not found")
+	ErrServiceAccountNotFound      = errors.New("service account not found")
+	ErrInvalidUserCode             = errors.New("invalid or expired user code")
+	ErrUnknownIdentityProvider     = errors.New("unknown identity provider")
+	ErrIdentityProviderUnavailable = errors.New("identity provider is unavailable")
+	ErrInvalidFederatedLogin       = errors.New("invalid or expired federated login")
+	ErrFederatedLoginDenied        = errors.New("identity provider denied login")
+	ErrFederatedEmailNotVerified   = errors.New("identity provider did not return a verified email")
+	ErrFederatedAccountConflict    = errors.New("account with this email can not be linked automatically")
+	ErrRecentAuthRequired          = errors.New("recent authentication required, log in again")
+	ErrIdentityNotFound            = errors.New("identity not found")
+	ErrIdentityLinkedToAnotherUser = errors.New("identity is linked to another user")
+	ErrLastLoginMethod             = errors.New("can not remove the last login method")
+	ErrAccountDeactivated          = errors.New("account is deactivated")
+	ErrSCIMUserNameRequired        = errors.New("userName is required")
+	ErrSCIMEmailMismatch           = errors.New("emails must match userName")
+	ErrSCIMSchemaNotFound          = errors.New("schema not found")
+	ErrUnsupportedLocale           = errors.New("unsupported locale")
+	ErrInvalidOutboxStatus         = errors.New("invalid outbox status, expected pending, sent or dead")
+	ErrOutboxMessageNotFound       = errors.New("outbox message not found")
+	ErrOutboxMessageNotDead        = errors.New("only dead outbox messages can be retried")
+	ErrOutboxMessageExpired        = errors.New("outbox message has expired and can no longer be retried")
+)
@@ -0,0 +1,372 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/encryption"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/jwt"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/mailer"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Репозитории в памяти для тестов обработчиков. Встроенный интерфейс репозитория оставлен nil:
+// вызов метода, который тест не ожидает, приводит к панике и сразу виден
+
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type fakeUserRepo struct {
+	repositories.UserRepo
+
+	mu    sync.Mutex
+	users map[uuid.UUID]*models.User
+}
+
+func newFakeUserRepo(users ...*models.User) *fakeUserRepo {
+	repo := &fakeUserRepo{users: map[uuid.UUID]*models.User{}}
+	for _, user := range users {
+		repo.users[user.UserID] = user
+	}
+	return repo
+}
+
+func (r *fakeUserRepo) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.users {
+		if models.NormalizeEmail(existing.Email) == models.NormalizeEmail(user.Email) {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	r.users[user.UserID] = user
+	return nil
+}
+
+func (r *fakeUserRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (r *fakeUserRepo) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return r.FindByID(ctx, id)
+}
+
+func (r *fakeUserRepo) FindByIDString(ctx context.Context, idString string) (*models.User, error) {
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		return nil, err
+	}
+	return r.FindByID(ctx, id)
+}
+
+func (r *fakeUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if models.NormalizeEmail(user.Email) == models.NormalizeEmail(email) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUserRepo) Update(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *user
+	r.users[user.UserID] = &copied
+	return nil
+}
+
+type fakeWebAuthnRepo struct {
+	repositories.WebAuthnRepo
+
+	mu          sync.Mutex
+	credentials []models.WebAuthnCredential
+	sessions    map[string]models.WebAuthnSession
+}
+
+func newFakeWebAuthnRepo() *fakeWebAuthnRepo {
+	return &fakeWebAuthnRepo{sessions: map[string]models.WebAuthnSession{}}
+}
+
+func (r *fakeWebAuthnRepo) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.credentials = append(r.credentials, *credential)
+	return nil
+}
+
+func (r *fakeWebAuthnRepo) FindCredentialsByUserID(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	credentials := []models.WebAuthnCredential{}
+	for _, credential := range r.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (r *fakeWebAuthnRepo) UpdateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.credentials {
+		if r.credentials[i].ID == credential.ID {
+			r.credentials[i] = *credential
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (r *fakeWebAuthnRepo) DeleteCredential(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, credential := range r.credentials {
+		if credential.UserID == userID && credential.ID == id {
+			r.credentials = append(r.credentials[:i], r.credentials[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeWebAuthnRepo) CreateSession(ctx context.Context, session *models.WebAuthnSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[session.ID] = *session
+	return nil
+}
+
+func (r *fakeWebAuthnRepo) TakeSession(ctx context.Context, id string, purpose string) (*models.WebAuthnSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok || session.Purpose != purpose || !session.ExpiresAt.After(time.Now()) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(r.sessions, id)
+	return &session, nil
+}
+
+type fakeIdentityRepo struct {
+	repositories.IdentityRepo
+
+	users *fakeUserRepo
+
+	mu         sync.Mutex
+	identities []models.Identity
+	sessions   map[string]models.FederatedLoginSession
+}
+
+func (r *fakeIdentityRepo) Create(ctx context.Context, identity *models.Identity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *fakeIdentityRepo) CreateWithUser(ctx context.Context, user *models.User, identity *models.Identity) error {
+	err := r.users.Create(ctx, user)
+	if err != nil {
+		return err
+	}
+	return r.Create(ctx, identity)
+}
+
+func (r *fakeIdentityRepo) FindByProviderSubject(ctx context.Context, provider string, subject string) (*models.Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeIdentityRepo) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	identities := []models.Identity{}
+	for _, identity := range r.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (r *fakeIdentityRepo) Delete(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, identity := range r.identities {
+		if identity.UserID == userID && identity.ID == id {
+			r.identities = append(r.identities[:i], r.identities[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeIdentityRepo) CreateLoginSession(ctx context.Context, session *models.FederatedLoginSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[session.ID] = *session
+	return nil
+}
+
+func (r *fakeIdentityRepo) TakeLoginSession(ctx context.Context, id string, provider string) (*models.FederatedLoginSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok || session.Provider != provider || !session.ExpiresAt.After(time.Now()) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(r.sessions, id)
+	return &session, nil
+}
+
+type fakeLoginFailureRepo struct {
+	repositories.LoginFailureRepo
+}
+
+func (r *fakeLoginFailureRepo) FindByIP(ctx context.Context, ip string) (*models.LoginFailure, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+type fakeOutboxRepo struct {
+	repositories.OutboxRepo
+
+	mu       sync.Mutex
+	messages []models.OutboxMessage
+}
+
+func (r *fakeOutboxRepo) Create(ctx context.Context, message *models.OutboxMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, *message)
+	return nil
+}
+
+// Возвращает названия шаблонов писем, поставленных в очередь
+func (r *fakeOutboxRepo) templates() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	templates := make([]string, 0, len(r.messages))
+	for _, message := range r.messages {
+		templates = append(templates, message.Template)
+	}
+	return templates
+}
+
+// Собирает приложение с репозиториями в памяти и встроенными шаблонами писем
+func newTestApp(t *testing.T, users ...*models.User) *ImplApp {
+	t.Helper()
+
+	templates, err := mailer.NewTemplates("", "")
+	if err != nil {
+		t.Fatalf("failed to load mail templates: %v", err)
+	}
+
+	encryptor, err := encryption.NewAESGCMEncryptor([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("failed to build encryptor: %v", err)
+	}
+
+	userRepo := newFakeUserRepo(users...)
+	return &ImplApp{
+		JWTManager: jwt.NewJWT(
+			[]byte("test access secret"),
+			[]byte("test refresh secret"),
+			jwt.AccessExpires,
+			jwt.RefreshExpires,
+			jwt.ParseLeewayWindow,
+			jwt.MFAExpires,
+		),
+		UserRepo:         userRepo,
+		LoginFailureRepo: &fakeLoginFailureRepo{},
+		WebAuthnRepo:     newFakeWebAuthnRepo(),
+		IdentityRepo:     &fakeIdentityRepo{users: userRepo, sessions: map[string]models.FederatedLoginSession{}},
+		EmailLoginRepo:   &fakeEmailLoginRepo{},
+		OutboxRepo:       &fakeOutboxRepo{},
+		Transactor:       fakeTransactor{},
+		SecretEncryptor:  encryptor,
+		MailTemplates:    templates,
+		BaseURL:          "http://localhost",
+		Domain:           "localhost",
+	}
+}
+
+// Создает контекст gin для вызова обработчика напрямую, как если бы AuthMiddleware пропустил пользователя userID
+func newTestContext(request *http.Request, userID string) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = request
+	if userID != "" {
+		ctx.Set(UserIDContextKey, userID)
+	}
+	return ctx, recorder
+}
+
+type fakeEmailLoginRepo struct {
+	repositories.EmailLoginRepo
+
+	mu     sync.Mutex
+	logins []models.EmailLogin
+}
+
+func (r *fakeEmailLoginRepo) Create(ctx context.Context, login *models.EmailLogin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logins = append(r.logins, *login)
+	return nil
+}
+
+func (r *fakeEmailLoginRepo) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.logins)
+}
+
+// Ждет выполнения условия, проверяемого в фоновой работе обработчика
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition was not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
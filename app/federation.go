@@ -0,0 +1,262 @@
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/federation"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// Название cookie с идентификатором незавершенного входа через внешний провайдер
+	FederatedLoginCookieName = "federated_login_session"
+	// Путь cookie незавершенного входа: она нужна только обработчикам /login/federated
+	federatedLoginCookiePath = "/login/federated"
+	// Размер в байтах случайных идентификатора сессии, state, nonce и code_verifier
+	federatedLoginTokenSize = 32
+)
+
+var (
+	// Время, в течении которого пользователь должен вернуться от провайдера
+	FederatedLoginExpires = 10 * time.Minute
+)
+
+// Обработчик получения списка внешних провайдеров личности (OpenID Connect и SAML), через которые можно войти
+func (a *ImplApp) ListFederationProvidersHandler(ctx *gin.Context) {
+	result := make([]gin.H, 0, len(a.FederationProviders)+len(a.SAMLProviders))
+	for name, provider := range a.FederationProviders {
+		result = append(result, gin.H{
+			"name":         name,
+			"display_name": provider.DisplayName(),
+			"protocol":     "oidc",
+			"login_url":    a.BaseURL + federatedLoginCookiePath + "/" + name,
+		})
+	}
+	for name, provider := range a.SAMLProviders {
+		result = append(result, gin.H{
+			"name":         name,
+			"display_name": provider.DisplayName(),
+			"protocol":     "saml",
+			"login_url":    a.BaseURL + samlLoginCookiePath + "/" + name,
+		})
+	}
+	slices.SortFunc(result, func(x, y gin.H) int {
+		return strings.Compare(x["name"].(string), y["name"].(string))
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{"providers": result})
+}
+
+// Обработчик начала входа через внешний провайдер OpenID Connect. Перенаправляет пользователя на страницу входа провайдера.
+//
+// state, nonce и code_verifier PKCE сохраняются в БД, браузер получает cookie с идентификатором сессии,
+// поэтому завершить вход можно только в том же браузере и только один раз
+func (a *ImplApp) BeginFederatedLoginHandler(ctx *gin.Context) {
+	provider, ok := a.FederationProviders[ctx.Param("provider")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUnknownIdentityProvider.Error()})
+		return
+	}
+
+	a.redirectToIdentityProvider(ctx, provider, nil)
+}
+
+// Сохраняет параметры запроса авторизации и перенаправляет пользователя к провайдеру.
+// Если передан userID, после возврата личность привязывается к этому пользователю вместо входа
+func (a *ImplApp) redirectToIdentityProvider(ctx *gin.Context, provider federation.Provider, userID *uuid.UUID) {
+	tokens := make([]string, 4)
+	for i := range tokens {
+		token, err := GenerateRandomToken(federatedLoginTokenSize)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		tokens[i] = token
+	}
+	sessionID, state, nonce, codeVerifier := tokens[0], tokens[1], tokens[2], tokens[3]
+
+	authCodeURL, err := provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": ErrIdentityProviderUnavailable.Error()})
+		return
+	}
+
+	err = a.IdentityRepo.CreateLoginSession(ctx, &models.FederatedLoginSession{
+		ID:           HashTokenSHA256(sessionID),
+		Provider:     provider.Name(),
+		UserID:       userID,
+		StateHash:    HashTokenSHA256(state),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(FederatedLoginExpires),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.SetCookie(FederatedLoginCookieName, sessionID, int(FederatedLoginExpires.Seconds()), federatedLoginCookiePath, a.Domain, false, true)
+	ctx.Redirect(http.StatusFound, authCodeURL)
+}
+
+// Обработчик возврата пользователя от внешнего провайдера. Обменивает код на токены провайдера,
+// проверяет ID токен и выдает собственную пару токенов пользователю, с которым связана внешняя личность.
+//
+// Личность, которая еще не связана с пользователем, связывается с пользователем с тем же email, если адрес
+// подтвержден и сервисом, и провайдером, которому доверено подтверждать адреса (см. federation.EmailTrusted).
+// Иначе пользователь должен войти и привязать личность сам (см. LinkIdentityHandler).
+// Если пользователя с таким email нет, он создается без пароля.
+// Если вход начат привязкой личности (см. LinkIdentityHandler), личность привязывается к пользователю, начавшему привязку
+func (a *ImplApp) FederatedLoginCallbackHandler(ctx *gin.Context) {
+	provider, ok := a.FederationProviders[ctx.Param("provider")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUnknownIdentityProvider.Error()})
+		return
+	}
+
+	sessionID, err := ctx.Cookie(FederatedLoginCookieName)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidFederatedLogin.Error()})
+		return
+	}
+	ctx.SetCookie(FederatedLoginCookieName, "", -1, federatedLoginCookiePath, a.Domain, false, true)
+
+	session, err := a.IdentityRepo.TakeLoginSession(ctx, HashTokenSHA256(sessionID), provider.Name())
+	if err != nil || subtle.ConstantTimeCompare([]byte(session.StateHash), []byte(HashTokenSHA256(ctx.Query("state")))) != 1 {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidFederatedLogin.Error()})
+		return
+	}
+
+	if providerErr := ctx.Query("error"); providerErr != "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrFederatedLoginDenied.Error(), "provider_error": providerErr})
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, ctx.Query("code"), session.CodeVerifier, session.Nonce)
+	if errors.Is(err, federation.ErrProviderUnavailable) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": ErrIdentityProviderUnavailable.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidFederatedLogin.Error()})
+		return
+	}
+
+	if session.UserID != nil {
+		a.linkIdentity(ctx, *session.UserID, identity)
+		return
+	}
+
+	a.completeFederatedLogin(ctx, identity)
+}
+
+// Завершает вход по внешней личности, подтвержденной провайдером OpenID Connect или SAML:
+// находит или создает пользователя и выдает пару токенов так же, как вход по паролю
+func (a *ImplApp) completeFederatedLogin(ctx *gin.Context, identity *federation.Identity) {
+	user, err := a.resolveFederatedUser(ctx, identity)
+	if errors.Is(err, ErrFederatedEmailNotVerified) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrFederatedAccountConflict) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	clientIP := a.GetClientIP(ctx, a.LoginRemoteIPMode)
+	if !a.CheckLoginAllowed(ctx, user, clientIP) {
+		return
+	}
+
+	// провайдер заменяет только ввод пароля, второй фактор пользователя по-прежнему запрашивается
+	methods, err := a.MFAMethods(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(methods) > 0 {
+		a.StartMFAChallenge(ctx, user, methods)
+		return
+	}
+
+	ResetLoginFailures(user)
+	err = a.IssueTokenPair(ctx, user, clientIP)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    MessageSuccessfullyLoggedIn,
+		"expires_in": a.JWTManager.GetAccessExpiresSec(),
+	})
+}
+
+// Находит пользователя, с которым связана внешняя личность. Несвязанная личность связывается с пользователем
+// по email, если провайдеру доверено его подтверждать, или для нее создается новый пользователь
+func (a *ImplApp) resolveFederatedUser(ctx *gin.Context, identity *federation.Identity) (*models.User, error) {
+	linked, err := a.IdentityRepo.FindByProviderSubject(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return a.UserRepo.FindByID(ctx, linked.UserID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrFederatedEmailNotVerified
+	}
+
+	user, err := a.UserRepo.FindByEmail(ctx, identity.Email)
+	if err == nil {
+		// email_verified подтверждает адрес только со слов провайдера: любой провайдер может выдать
+		// своему пользователю чужой адрес, поэтому связывание разрешено только доверенным провайдерам
+		if !identity.EmailTrusted {
+			return nil, ErrFederatedAccountConflict
+		}
+		// неподтвержденный адрес мог зарегистрировать кто угодно: после связывания он сохранил бы доступ по паролю
+		if !user.EmailVerified {
+			return nil, ErrFederatedAccountConflict
+		}
+
+		err = a.IdentityRepo.Create(ctx, models.NewIdentity(user.UserID, identity.Provider, identity.Subject, identity.Email))
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrFederatedAccountConflict
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	user = models.NewUser(identity.Email, "")
+	user.AuthSource = models.AuthSourceFederated
+	// адрес от недоверенного провайдера не считается подтвержденным сервисом, иначе к этому пользователю
+	// затем смог бы автоматически привязаться доверенный провайдер настоящего владельца адреса
+	user.EmailVerified = identity.EmailTrusted
+
+	err = a.IdentityRepo.CreateWithUser(ctx, user, models.NewIdentity(user.UserID, identity.Provider, identity.Subject, identity.Email))
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// пользователь или личность могли быть созданы параллельным входом
+		return nil, ErrFederatedAccountConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,360 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/federation"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	jwtlib "github.com/golang-jwt/jwt/v5"
+)
+
+const testOIDCClientID = "auth-service"
+
+// Пользователь, который войдет у тестового провайдера при следующем запросе авторизации
+type testOIDCUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Выданный тестовым провайдером код авторизации
+type testOIDCAuthorization struct {
+	user          testOIDCUser
+	nonce         string
+	codeChallenge string
+}
+
+// Провайдер OpenID Connect в памяти: документ обнаружения, JWKS, страница авторизации, которая сразу
+// возвращает пользователя с кодом, и /token с проверкой PKCE. ID токены подписываются ключом RS256
+type testOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	user  testOIDCUser
+	nonce string
+	codes map[string]testOIDCAuthorization
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	idp := &testOIDCProvider{key: key, codes: map[string]testOIDCAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("GET /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// Задает пользователя, который войдет у провайдера
+func (p *testOIDCProvider) signIn(user testOIDCUser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = user
+}
+
+// Заставляет провайдер выдавать ID токены с другим nonce
+func (p *testOIDCProvider) overrideNonce(nonce string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nonce = nonce
+}
+
+func (p *testOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeTestJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *testOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeTestJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *testOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != testOIDCClientID || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	nonce := query.Get("nonce")
+	if p.nonce != "" {
+		nonce = p.nonce
+	}
+	code := rand.Text()
+	p.codes[code] = testOIDCAuthorization{
+		user:          p.user,
+		nonce:         nonce,
+		codeChallenge: query.Get("code_challenge"),
+	}
+	p.mu.Unlock()
+
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+		return
+	}
+	redirectURL.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func (p *testOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	authorization, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	verifierHash := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifierHash[:]) != authorization.codeChallenge {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken := jwtlib.NewWithClaims(jwtlib.SigningMethodRS256, jwtlib.MapClaims{
+		"iss":            p.server.URL,
+		"sub":            authorization.user.Subject,
+		"aud":            testOIDCClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          authorization.nonce,
+		"email":          authorization.user.Email,
+		"email_verified": authorization.user.EmailVerified,
+	})
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeTestJSON(w, http.StatusOK, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// Собирает приложение с провайдером "test", который указывает на тестовый провайдер
+func newFederationTestApp(t *testing.T, idp *testOIDCProvider, opts federation.OIDCProviderOptions, users ...*models.User) *ImplApp {
+	t.Helper()
+	a := newTestApp(t, users...)
+
+	opts.Issuer = idp.server.URL
+	opts.ClientID = testOIDCClientID
+	opts.RedirectURL = a.BaseURL + federatedLoginCookiePath + "/test/callback"
+	provider, err := federation.NewOIDCProvider("test", opts)
+	if err != nil {
+		t.Fatalf("failed to build provider: %v", err)
+	}
+	a.FederationProviders = map[string]federation.Provider{"test": provider}
+	return a
+}
+
+// Проходит вход через тестовый провайдер: начало входа, авторизация у провайдера и возврат пользователя.
+// tamper позволяет изменить параметры обратного вызова до его обработки
+func federatedLogin(t *testing.T, a *ImplApp, tamper func(query url.Values)) *httptest.ResponseRecorder {
+	t.Helper()
+
+	ctx, recorder := newTestContext(httptest.NewRequest(http.MethodGet, federatedLoginCookiePath+"/test", nil), "")
+	ctx.AddParam("provider", "test")
+	a.BeginFederatedLoginHandler(ctx)
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("expected redirect to the provider, got %d: %s", recorder.Code, recorder.Body)
+	}
+	cookies := recorder.Result().Cookies()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to authorize at the provider: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect back from the provider, got %d", response.StatusCode)
+	}
+
+	callbackURL, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback url: %v", err)
+	}
+	query := callbackURL.Query()
+	if tamper != nil {
+		tamper(query)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, callbackURL.Path+"?"+query.Encode(), nil)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	ctx, recorder = newTestContext(request, "")
+	ctx.AddParam("provider", "test")
+	a.FederatedLoginCallbackHandler(ctx)
+	return recorder
+}
+
+func newVerifiedUser(email string) *models.User {
+	user := models.NewUser(email, "password hash")
+	user.EmailVerified = true
+	return user
+}
+
+func TestFederatedLoginCodeFlowCreatesUser(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	a := newFederationTestApp(t, idp, federation.OIDCProviderOptions{TrustEmail: true})
+	idp.signIn(testOIDCUser{Subject: "alice-subject", Email: "alice@example.com", EmailVerified: true})
+
+	recorder := federatedLogin(t, a, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected successful login, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	user, err := a.UserRepo.FindByEmail(t.Context(), "alice@example.com")
+	if err != nil {
+		t.Fatalf("expected a provisioned user, got %v", err)
+	}
+	if user.AuthSource != models.AuthSourceFederated || !user.EmailVerified {
+		t.Fatalf("unexpected provisioned user %+v", user)
+	}
+
+	// повторный вход находит пользователя по связанной личности
+	recorder = federatedLogin(t, a, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected successful second login, got %d: %s", recorder.Code, recorder.Body)
+	}
+	identities, _ := a.IdentityRepo.FindByUserID(t.Context(), user.UserID)
+	if len(identities) != 1 || identities[0].Subject != "alice-subject" {
+		t.Fatalf("expected a single linked identity, got %+v", identities)
+	}
+}
+
+func TestFederatedLoginStateMismatch(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	a := newFederationTestApp(t, idp, federation.OIDCProviderOptions{TrustEmail: true})
+	idp.signIn(testOIDCUser{Subject: "alice-subject", Email: "alice@example.com", EmailVerified: true})
+
+	recorder := federatedLogin(t, a, func(query url.Values) {
+		query.Set("state", "forged state")
+	})
+	if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Body.String(), ErrInvalidFederatedLogin.Error()) {
+		t.Fatalf("expected 401, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if _, err := a.UserRepo.FindByEmail(t.Context(), "alice@example.com"); err == nil {
+		t.Fatalf("user was provisioned despite the state mismatch")
+	}
+}
+
+func TestFederatedLoginNonceMismatch(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	a := newFederationTestApp(t, idp, federation.OIDCProviderOptions{TrustEmail: true})
+	idp.signIn(testOIDCUser{Subject: "alice-subject", Email: "alice@example.com", EmailVerified: true})
+	idp.overrideNonce("replayed nonce")
+
+	recorder := federatedLogin(t, a, nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if _, err := a.UserRepo.FindByEmail(t.Context(), "alice@example.com"); err == nil {
+		t.Fatalf("user was provisioned despite the nonce mismatch")
+	}
+}
+
+func TestFederatedLoginLinksTrustedVerifiedEmail(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	alice := newVerifiedUser("alice@example.com")
+	a := newFederationTestApp(t, idp, federation.OIDCProviderOptions{
+		TrustEmail:     true,
+		TrustedDomains: []string{"example.com"},
+	}, alice)
+	idp.signIn(testOIDCUser{Subject: "alice-subject", Email: "Alice@Example.com", EmailVerified: true})
+
+	recorder := federatedLogin(t, a, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected successful login, got %d: %s", recorder.Code, recorder.Body)
+	}
+	identities, _ := a.IdentityRepo.FindByUserID(t.Context(), alice.UserID)
+	if len(identities) != 1 {
+		t.Fatalf("expected the identity to be linked to the existing user, got %+v", identities)
+	}
+}
+
+func TestFederatedLoginRefusesUnverifiedEmail(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	alice := newVerifiedUser("alice@example.com")
+	a := newFederationTestApp(t, idp, federation.OIDCProviderOptions{TrustEmail: true}, alice)
+	idp.signIn(testOIDCUser{Subject: "mallory-subject", Email: "alice@example.com", EmailVerified: false})
+
+	recorder := federatedLogin(t, a, nil)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if identities, _ := a.IdentityRepo.FindByUserID(t.Context(), alice.UserID); len(identities) != 0 {
+		t.Fatalf("unverified identity was linked: %+v", identities)
+	}
+}
+
+func TestFederatedLoginRefusesUntrustedProvider(t *testing.T) {
+	tests := map[string]federation.OIDCProviderOptions{
+		"trust disabled":   {},
+		"untrusted domain": {TrustEmail: true, TrustedDomains: []string{"corp.example.com"}},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			idp := newTestOIDCProvider(t)
+			alice := newVerifiedUser("alice@example.com")
+			a := newFederationTestApp(t, idp, opts, alice)
+			idp.signIn(testOIDCUser{Subject: "mallory-subject", Email: "alice@example.com", EmailVerified: true})
+
+			recorder := federatedLogin(t, a, nil)
+			if recorder.Code != http.StatusConflict {
+				t.Fatalf("expected 409, got %d: %s", recorder.Code, recorder.Body)
+			}
+			if identities, _ := a.IdentityRepo.FindByUserID(t.Context(), alice.UserID); len(identities) != 0 {
+				t.Fatalf("identity of an untrusted provider was linked: %+v", identities)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/federation"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Способы входа пользователя
+const (
+	LoginMethodPassword = "password"
+	LoginMethodPasskey  = "passkey"
+	LoginMethodIdentity = "identity"
+)
+
+// Обработчик получения способов входа пользователя: пароля, passkey и привязанных внешних личностей.
+// Требует аутентификации по access токену (см. AuthMiddleware)
+func (a *ImplApp) ListIdentitiesHandler(ctx *gin.Context) {
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	identities, err := a.IdentityRepo.FindByUserID(ctx, user.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	credentials, err := a.WebAuthnRepo.FindCredentialsByUserID(ctx, user.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(identities))
+	for _, identity := range identities {
+		result = append(result, gin.H{
+			"id":        identity.ID,
+			"provider":  identity.Provider,
+			"subject":   identity.Subject,
+			"email":     identity.Email,
+			"linked_at": identity.LinkedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"password":   hasPassword(user),
+		"passkeys":   len(credentials),
+		"identities": result,
+	})
+}
+
+// Обработчик начала привязки внешней личности. Требует аутентификации по access токену (см. AuthMiddleware)
+// и недавнего входа (см. RequireRecentAuth). Перенаправляет пользователя к провайдеру,
+// привязка завершается в FederatedLoginCallbackHandler или SAMLACSHandler
+func (a *ImplApp) LinkIdentityHandler(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	if provider, ok := a.FederationProviders[ctx.Param("provider")]; ok {
+		a.redirectToIdentityProvider(ctx, provider, &userID)
+		return
+	}
+	if provider, ok := a.SAMLProviders[ctx.Param("provider")]; ok {
+		a.redirectToSAMLProvider(ctx, provider, &userID)
+		return
+	}
+
+	ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUnknownIdentityProvider.Error()})
+}
+
+// Обработчик отвязки внешней личности. Требует аутентификации по access токену (см. AuthMiddleware)
+// и недавнего входа (см. RequireRecentAuth). Последний оставшийся способ входа отвязать нельзя
+func (a *ImplApp) UnlinkIdentityHandler(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrIdentityNotFound.Error()})
+		return
+	}
+
+	deleted := false
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// блокировка пользователя не дает параллельным удалениям удалить два последних способа входа
+		user, err := a.UserRepo.FindByIDForUpdate(ctx, userID)
+		if err != nil {
+			return err
+		}
+		err = a.CheckNotLastLoginMethod(ctx, user)
+		if err != nil {
+			return err
+		}
+		deleted, err = a.IdentityRepo.Delete(ctx, user.UserID, id)
+		return err
+	})
+	if !a.handleLoginMethodRemovalError(ctx, err) {
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrIdentityNotFound.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": MessageIdentityUnlinked})
+}
+
+// Привязывает личность, подтвержденную провайдером, к пользователю, начавшему привязку.
+// Личность, уже привязанная к другому пользователю, не перепривязывается
+func (a *ImplApp) linkIdentity(ctx *gin.Context, userID uuid.UUID, identity *federation.Identity) {
+	linked, err := a.IdentityRepo.FindByProviderSubject(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if linked.UserID != userID {
+			ctx.JSON(http.StatusConflict, gin.H{"error": ErrIdentityLinkedToAnotherUser.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"message": MessageIdentityLinked, "id": linked.ID})
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	record := models.NewIdentity(userID, identity.Provider, identity.Subject, identity.Email)
+	err = a.IdentityRepo.Create(ctx, record)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": ErrIdentityLinkedToAnotherUser.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": MessageIdentityLinked, "id": record.ID})
+}
+
+// Возвращает способы входа пользователя (см. константы LoginMethod*) с количеством каждого
+func (a *ImplApp) LoginMethods(ctx context.Context, user *models.User) (map[string]int, error) {
+	methods := map[string]int{}
+	if hasPassword(user) {
+		methods[LoginMethodPassword] = 1
+	}
+
+	credentials, err := a.WebAuthnRepo.FindCredentialsByUserID(ctx, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) > 0 {
+		methods[LoginMethodPasskey] = len(credentials)
+	}
+
+	identities, err := a.IdentityRepo.FindByUserID(ctx, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) > 0 {
+		methods[LoginMethodIdentity] = len(identities)
+	}
+	return methods, nil
+}
+
+// Проверяет, что после удаления одного способа входа у пользователя останется хотя бы один.
+// Если способ последний, возвращает ErrLastLoginMethod. Вызывается в транзакции вместе с удалением,
+// после блокировки пользователя (см. UserRepo.FindByIDForUpdate)
+func (a *ImplApp) CheckNotLastLoginMethod(ctx context.Context, user *models.User) error {
+	methods, err := a.LoginMethods(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, count := range methods {
+		total += count
+	}
+	if total <= 1 {
+		return ErrLastLoginMethod
+	}
+	return nil
+}
+
+// Отправляет ответ с ошибкой транзакции удаления способа входа. Возвращает false, если ошибка была
+func (a *ImplApp) handleLoginMethodRemovalError(ctx *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+	case errors.Is(err, ErrLastLoginMethod):
+		ctx.JSON(http.StatusConflict, gin.H{"error": ErrLastLoginMethod.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return false
+}
+
+// Возвращает true, если пользователь может войти по паролю: локальному или проверяемому внешним бэкендом
+func hasPassword(user *models.User) bool {
+	switch user.AuthSource {
+	case models.AuthSourceLocal:
+		return user.Password != ""
+	case models.AuthSourceFederated:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+)
+
+func unlinkIdentity(t *testing.T, a *ImplApp, user *models.User, identity *models.Identity) *httptest.ResponseRecorder {
+	t.Helper()
+	ctx, recorder := newTestContext(httptest.NewRequest(http.MethodDelete, "/identities/"+identity.ID.String(), nil), user.UserID.String())
+	ctx.AddParam("id", identity.ID.String())
+	a.UnlinkIdentityHandler(ctx)
+	return recorder
+}
+
+func TestUnlinkIdentityKeepsLastLoginMethod(t *testing.T) {
+	user := models.NewUser("alice@example.com", "")
+	user.AuthSource = models.AuthSourceFederated
+	a := newTestApp(t, user)
+	first := models.NewIdentity(user.UserID, "first", "alice", user.Email)
+	second := models.NewIdentity(user.UserID, "second", "alice", user.Email)
+	for _, identity := range []*models.Identity{first, second} {
+		if err := a.IdentityRepo.Create(t.Context(), identity); err != nil {
+			t.Fatalf("failed to create identity: %v", err)
+		}
+	}
+
+	if recorder := unlinkIdentity(t, a, user, first); recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if recorder := unlinkIdentity(t, a, user, second); recorder.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for the last login method, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if identities, _ := a.IdentityRepo.FindByUserID(t.Context(), user.UserID); len(identities) != 1 {
+		t.Fatalf("expected the last identity to stay linked, got %+v", identities)
+	}
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Размер в байтах случайного токена разблокировки аккаунта
+const unlockTokenSize = 32
+
+// Проверяет, разрешена ли сейчас попытка входа в аккаунт пользователя с IP адреса clientIP.
+// Если попытка запрещена задержкой или блокировкой, отправляет ответ с заголовком Retry-After и возвращает false
+func (a *ImplApp) CheckLoginAllowed(ctx *gin.Context, user *models.User, clientIP string) bool {
+	now := time.Now()
+
+	if !user.Active {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": ErrAccountDeactivated.Error()})
+		return false
+	}
+
+	if user.IsLocked(now) {
+		SetRetryAfter(ctx, user.LockedUntil.Sub(now))
+		ctx.JSON(http.StatusLocked, gin.H{"error": ErrAccountLocked.Error()})
+		return false
+	}
+
+	if user.LastFailedLoginAt != nil {
+		nextAttemptAt := a.LockoutPolicy.AccountNextAttemptAt(user.FailedLoginAttempts, *user.LastFailedLoginAt)
+		if now.Before(nextAttemptAt) {
+			SetRetryAfter(ctx, nextAttemptAt.Sub(now))
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": ErrTooManyLoginAttempts.Error()})
+			return false
+		}
+	}
+
+	return a.CheckIPLoginAllowed(ctx, clientIP)
+}
+
+// Проверяет, разрешена ли сейчас попытка входа с IP адреса clientIP. Используется, когда аккаунт еще неизвестен.
+// Если попытка запрещена, отправляет ответ с заголовком Retry-After и возвращает false
+func (a *ImplApp) CheckIPLoginAllowed(ctx *gin.Context, clientIP string) bool {
+	now := time.Now()
+
+	failure, err := a.LoginFailureRepo.FindByIP(ctx, clientIP)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if err == nil && failure.LastFailedAt.After(now.Add(-a.LockoutPolicy.GetIPWindow())) {
+		nextAttemptAt := a.LockoutPolicy.IPNextAttemptAt(failure.Attempts, failure.LastFailedAt)
+		if now.Before(nextAttemptAt) {
+			SetRetryAfter(ctx, nextAttemptAt.Sub(now))
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": ErrTooManyLoginAttempts.Error()})
+			return false
+		}
+	}
+
+	return true
+}
+
+// Учитывает неудачную попытку входа для аккаунта и IP адреса.
+// При превышении порога блокирует аккаунт и отправляет письмо со ссылкой разблокировки
+func (a *ImplApp) RegisterLoginFailure(ctx *gin.Context, user *models.User, clientIP string) {
+	now := time.Now()
+
+	a.RegisterIPLoginFailure(ctx, clientIP)
+
+	failures, err := a.UserRepo.IncrementFailedLogins(ctx, user.UserID, now)
+	if err != nil {
+		slog.Warn("Failed to register login failure for account", "error", err.Error())
+		return
+	}
+	user.FailedLoginAttempts = failures
+	user.LastFailedLoginAt = &now
+
+	if !a.LockoutPolicy.ShouldLockAccount(failures) {
+		return
+	}
+
+	unlockToken, err := GenerateRandomToken(unlockTokenSize)
+	if err != nil {
+		slog.Warn("Failed to generate unlock token", "error", err.Error())
+		return
+	}
+
+	// после блокировки счетчик начинается заново, чтобы по ее истечении не действовали накопленные задержки.
+	// Блокировка записывается отдельным запросом, а не сохранением всей записи: она прочитана до проверки пароля
+	// и могла устареть
+	lockedUntil := now.Add(a.LockoutPolicy.GetLockDuration())
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := a.UserRepo.Lock(ctx, user.UserID, lockedUntil, HashTokenSHA256(unlockToken))
+		if err != nil {
+			return err
+		}
+		return a.EnqueueExpiringMail(ctx, user.Email, user.Locale, MailTemplateAccountLocked, gin.H{
+			"LockedUntil": lockedUntil,
+			"Link":        fmt.Sprintf("%s/unlock?token=%s", a.BaseURL, unlockToken),
+		}, lockedUntil)
+	})
+	if err != nil {
+		slog.Warn("Failed to lock account", "error", err.Error())
+		return
+	}
+
+	user.FailedLoginAttempts = 0
+	user.LastFailedLoginAt = nil
+	user.LockedUntil = &lockedUntil
+	user.UnlockToken = HashTokenSHA256(unlockToken)
+	user.UnlockTokenExpiresAt = &lockedUntil
+}
+
+// Учитывает неудачную попытку входа только для IP адреса. Используется, когда аккаунт неизвестен
+func (a *ImplApp) RegisterIPLoginFailure(ctx *gin.Context, clientIP string) {
+	now := time.Now()
+
+	_, err := a.LoginFailureRepo.RegisterFailure(ctx, clientIP, now, now.Add(-a.LockoutPolicy.GetIPWindow()))
+	if err != nil {
+		slog.Warn("Failed to register login failure for IP", "error", err.Error())
+	}
+}
+
+// Сбрасывает счетчик неудачных попыток и блокировку аккаунта. Изменения не сохраняются в БД
+func ResetLoginFailures(user *models.User) {
+	user.FailedLoginAttempts = 0
+	user.LastFailedLoginAt = nil
+	user.LockedUntil = nil
+	user.UnlockToken = ""
+	user.UnlockTokenExpiresAt = nil
+}
+
+// Обработчик ссылки разблокировки аккаунта из письма о блокировке. Показывает страницу подтверждения,
+// аккаунт разблокируется только отправкой ее формы (см. UnlockHandler)
+func (a *ImplApp) UnlockPageHandler(ctx *gin.Context) {
+	token := ctx.Query("token")
+	_, err := a.findUserByUnlockToken(ctx, token)
+	if err != nil {
+		a.RenderConfirmPage(ctx, http.StatusBadRequest, err.Error(), "", "", "", "")
+		return
+	}
+
+	a.RenderConfirmPage(ctx, http.StatusOK, PageTitleUnlockAccount, PageTextUnlockAccount, "/unlock", token, PageButtonUnlockAccount)
+}
+
+// Обработчик самостоятельной разблокировки аккаунта. Принимает токен из письма о блокировке формой или JSON
+func (a *ImplApp) UnlockHandler(ctx *gin.Context) {
+	user, err := a.findUserByUnlockToken(ctx, BindConfirmToken(ctx))
+	if err != nil {
+		RespondConfirmResult(ctx, http.StatusBadRequest, "", err)
+		return
+	}
+
+	ResetLoginFailures(user)
+	err = a.UserRepo.Update(ctx, user)
+	if err != nil {
+		RespondConfirmResult(ctx, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	RespondConfirmResult(ctx, http.StatusOK, MessageAccountUnlocked, nil)
+}
+
+// Находит пользователя по токену разблокировки и проверяет, что токен еще действителен
+func (a *ImplApp) findUserByUnlockToken(ctx *gin.Context, token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidUnlockToken
+	}
+
+	user, err := a.UserRepo.FindByUnlockToken(ctx, HashTokenSHA256(token))
+	if err != nil {
+		return nil, ErrInvalidUnlockToken
+	}
+
+	if user.UnlockTokenExpiresAt == nil || time.Now().After(*user.UnlockTokenExpiresAt) {
+		return nil, ErrInvalidUnlockToken
+	}
+
+	return user, nil
+}
+
+// Обработчик разблокировки аккаунта администратором. Требует RequireRole(models.RoleAdmin)
+func (a *ImplApp) AdminUnlockHandler(ctx *gin.Context) {
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.Param("guid"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	ResetLoginFailures(user)
+	err = a.UserRepo.Update(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": MessageAccountUnlocked})
+}
+
+// Устанавливает заголовок Retry-After в секундах (с округлением вверх)
+func SetRetryAfter(ctx *gin.Context, after time.Duration) {
+	seconds := int(math.Ceil(after.Seconds()))
+	ctx.Header("Retry-After", strconv.Itoa(max(seconds, 1)))
+}
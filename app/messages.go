@@ -1,7 +1,42 @@
 package app
 
 const (
-	MessageSuccessfullyRegistered = "successfully registered"
-	MessageSuccessfullyLoggedIn   = "successfully logged in"
-	MessafeSuccessfullyRefreshed  = "successfully refreshed"
+	MessageSuccessfullyRegistered       = "successfully registered"
+	MessageSuccessfullyLoggedIn         = "successfully logged in"
+	MessafeSuccessfullyRefreshed        = "successfully refreshed"
+	MessageSuccessfullyPasswordChanged  = "password successfully changed"
+	MessageEmailChangeRequested         = "email change requested, check your new mailbox"
+	MessageEmailChanged                 = "email successfully changed"
+	MessageEmailChangeCanceled          = "email change canceled"
+	MessageAccountUnlocked              = "account successfully unlocked"
+	MessageTOTPEnabled                  = "totp successfully enabled"
+	MessageTOTPDisabled                 = "totp successfully disabled"
+	MessageMFARequired                  = "second factor required"
+	MessageRecoveryCodesRegenerated     = "recovery codes successfully regenerated"
+	MessageWebAuthnCredentialRegistered = "passkey successfully registered"
+	MessageWebAuthnCredentialDeleted    = "passkey successfully deleted"
+	MessageEmailLoginSent               = "if the account exists, a login link and code have been sent to the email"
+	MessageOAuthClientCreated           = "oauth client successfully created"
+	MessageOAuthClientDeleted           = "oauth client successfully deleted"
+	MessageServiceAccountCreated        = "service account successfully created"
+	MessageServiceAccountDeleted        = "service account successfully deleted"
+	MessageDeviceApproved               = "device successfully authorized"
+	MessageDeviceDenied                 = "device authorization denied"
+	MessageIdentityLinked               = "identity successfully linked"
+	MessageIdentityUnlinked             = "identity successfully unlinked"
+	MessageLocaleChanged                = "locale successfully changed"
+	MessageOutboxMessageRetried         = "outbox message queued for retry"
+)
+
+// Тексты страниц подтверждения действий по ссылкам из писем (см. RenderConfirmPage)
+const (
+	PageTitleConfirmEmailChange = "Confirm email change"
+	PageTextConfirmEmailChange  = "The email of your account will be changed to this address."
+	PageButtonConfirmEmail      = "Confirm new email"
+	PageTitleCancelEmailChange  = "Cancel email change"
+	PageTextCancelEmailChange   = "The requested change of the email of your account will be canceled."
+	PageButtonCancelEmail       = "Cancel email change"
+	PageTitleUnlockAccount      = "Unlock account"
+	PageTextUnlockAccount       = "Your account was locked after too many failed login attempts."
+	PageButtonUnlockAccount     = "Unlock account"
 )
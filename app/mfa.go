@@ -0,0 +1,353 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"image/png"
+	"net/http"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// Размер стороны QR кода с otpauth:// URI в пикселях
+	TOTPQRCodeSize = 256
+	// Период TOTP в секундах
+	TOTPPeriod = 30
+	// Допустимое расхождение часов в шагах TOTP (в обе стороны)
+	TOTPSkew = 1
+)
+
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+	MFAMethodWebAuthn     = "webauthn"
+)
+
+type TOTPCodeBody struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTOTPBody struct {
+	Code     string `json:"code" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Для второго шага входа нужен либо TOTP код, либо код восстановления
+type LoginMFABody struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code" binding:"required_without=Code"`
+}
+
+// Обработчик начала подключения TOTP. Требует аутентификации по access токену (см. AuthMiddleware).
+//
+// Генерирует новый секрет и сохраняет его в зашифрованном виде, в ответе возвращает секрет,
+// otpauth:// URI и QR код (PNG в base64). TOTP включается только после подтверждения кодом в ConfirmTOTPHandler
+func (a *ImplApp) EnrollTOTPHandler(ctx *gin.Context) {
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	if user.TOTPEnabled {
+		ctx.JSON(http.StatusConflict, gin.H{"error": ErrTOTPAlreadyEnabled.Error()})
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      a.TOTPIssuer,
+		AccountName: user.Email,
+		Period:      TOTPPeriod,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// секрет привязан к пользователю: скопированный в запись другого пользователя он не расшифруется
+	encryptedSecret, err := a.SecretEncryptor.Encrypt([]byte(key.Secret()), user.UserID[:])
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user.TOTPSecret = encryptedSecret
+	user.TOTPLastUsedStep = 0
+
+	qrCode, err := encodeQRCode(key)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = a.UserRepo.Update(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"secret":      key.Secret(),
+		"otpauth_uri": key.URL(),
+		"qr_png":      qrCode,
+	})
+}
+
+// Обработчик подтверждения подключения TOTP кодом из приложения-аутентификатора.
+// Требует аутентификации по access токену (см. AuthMiddleware)
+func (a *ImplApp) ConfirmTOTPHandler(ctx *gin.Context) {
+	body := TOTPCodeBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	if user.TOTPEnabled {
+		ctx.JSON(http.StatusConflict, gin.H{"error": ErrTOTPAlreadyEnabled.Error()})
+		return
+	}
+	if user.TOTPSecret == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrTOTPNotEnrolled.Error()})
+		return
+	}
+
+	if !a.VerifyTOTP(user, body.Code) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidMFACode.Error()})
+		return
+	}
+
+	user.TOTPEnabled = true
+	err = a.UserRepo.Update(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recoveryCodes, err := a.GenerateRecoveryCodes(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":        MessageTOTPEnabled,
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// Обработчик отключения TOTP. Требует аутентификации по access токену (см. AuthMiddleware), текущего кода и пароля
+func (a *ImplApp) DisableTOTPHandler(ctx *gin.Context) {
+	body := DisableTOTPBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	if !user.TOTPEnabled {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrTOTPNotEnrolled.Error()})
+		return
+	}
+
+	if !a.CompareHashAndPassword(user.Password, user.PepperVersion, body.Password) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidCredentials.Error()})
+		return
+	}
+	if !a.VerifyTOTP(user, body.Code) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidMFACode.Error()})
+		return
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastUsedStep = 0
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := a.UserRepo.Update(ctx, user)
+		if err != nil {
+			return err
+		}
+		err = a.RecoveryCodeRepo.DeleteByUserID(ctx, user.UserID)
+		if err != nil {
+			return err
+		}
+		return a.EnqueueMail(ctx, user.Email, user.Locale, MailTemplateTOTPDisabled, gin.H{})
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": MessageTOTPDisabled})
+}
+
+// Обработчик второго шага входа. Принимает токен MFA челленджа, выданный LoginHandler, и код TOTP или код восстановления.
+// Неверные коды учитываются наравне с неверными паролями (см. RegisterLoginFailure)
+func (a *ImplApp) LoginMFAHandler(ctx *gin.Context) {
+	body := LoginMFABody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	claims, err := a.JWTManager.ValidateMFAToken(body.MFAToken)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidMFAToken.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, claims.Subject)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	clientIP := a.GetClientIP(ctx, a.LoginRemoteIPMode)
+	if !a.CheckLoginAllowed(ctx, user, clientIP) {
+		return
+	}
+
+	if !user.TOTPEnabled || !a.verifySecondFactor(ctx, user, body) {
+		a.RegisterLoginFailure(ctx, user, clientIP)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidMFACode.Error()})
+		return
+	}
+
+	// сброс счетчиков и использованный шаг TOTP сохраняются в БД вместе с новым refresh токеном
+	ResetLoginFailures(user)
+	err = a.IssueTokenPair(ctx, user, clientIP)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    MessageSuccessfullyLoggedIn,
+		"expires_in": a.JWTManager.GetAccessExpiresSec(),
+	})
+}
+
+// Проверяет второй фактор: TOTP код, если он передан, иначе код восстановления
+func (a *ImplApp) verifySecondFactor(ctx *gin.Context, user *models.User, body LoginMFABody) bool {
+	if body.Code != "" {
+		return a.VerifyTOTP(user, body.Code)
+	}
+	return a.UseRecoveryCode(ctx, user, body.RecoveryCode)
+}
+
+// Возвращает доступные пользователю способы подтверждения входа вторым фактором.
+// Пустой список означает, что второй фактор для входа не требуется
+func (a *ImplApp) MFAMethods(ctx *gin.Context, user *models.User) ([]string, error) {
+	methods := []string{}
+	if user.TOTPEnabled {
+		methods = append(methods, MFAMethodTOTP, MFAMethodRecoveryCode)
+	}
+
+	credentials, err := a.WebAuthnRepo.FindCredentialsByUserID(ctx, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) > 0 {
+		methods = append(methods, MFAMethodWebAuthn)
+	}
+
+	return methods, nil
+}
+
+// Вместо пары токенов отправляет токен MFA челленджа, с которым нужно завершить вход в LoginMFAHandler
+// или FinishWebAuthnMFAHandler. Сохраняет изменения пользователя, сделанные на первом шаге входа
+func (a *ImplApp) StartMFAChallenge(ctx *gin.Context, user *models.User, methods []string) {
+	err := a.UserRepo.Update(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	mfaToken, err := a.JWTManager.GenerateMFAToken(user.UserID.String())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":      MessageMFARequired,
+		"mfa_required": true,
+		"mfa_token":    mfaToken,
+		"mfa_methods":  methods,
+		"expires_in":   a.JWTManager.GetMFAExpiresSec(),
+	})
+}
+
+// Проверяет TOTP код пользователя. Код, шаг которого уже был использован, не принимается.
+// В случае успеха обновляет TOTPLastUsedStep, изменения не сохраняются в БД
+func (a *ImplApp) VerifyTOTP(user *models.User, code string) bool {
+	if user.TOTPSecret == "" {
+		return false
+	}
+
+	secret, err := a.SecretEncryptor.Decrypt(user.TOTPSecret, user.UserID[:])
+	if err != nil {
+		return false
+	}
+
+	now := time.Now()
+	currentStep := now.Unix() / TOTPPeriod
+	for skew := -TOTPSkew; skew <= TOTPSkew; skew++ {
+		step := currentStep + int64(skew)
+		if step <= user.TOTPLastUsedStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(string(secret), time.Unix(step*TOTPPeriod, 0), totp.ValidateOpts{
+			Period:    TOTPPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			user.TOTPLastUsedStep = step
+			return true
+		}
+	}
+
+	return false
+}
+
+// Кодирует otpauth:// URI ключа в QR код (PNG в base64)
+func encodeQRCode(key *otp.Key) (string, error) {
+	image, err := key.Image(TOTPQRCodeSize, TOTPQRCodeSize)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, image)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
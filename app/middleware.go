@@ -0,0 +1,95 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/jwt"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Время после ввода учетных данных, в течении которого разрешены чувствительные операции (см. RequireRecentAuth)
+	RecentAuthMaxAge = 10 * time.Minute
+)
+
+const (
+	// Ключ контекста gin, под которым хранится uuid пользователя из проверенного access токена
+	UserIDContextKey = "user_id"
+	// Ключ контекста gin, под которым хранится payload проверенного access токена
+	AccessClaimsContextKey = "access_claims"
+)
+
+// Middleware аутентификации по access токену из cookie. Принимаются только токены самого сервиса.
+// Токены удаленных и деактивированных пользователей отклоняются, не дожидаясь истечения их срока.
+//
+// В случае успеха кладет в контекст uuid пользователя (UserIDContextKey) и payload токена (AccessClaimsContextKey)
+func (a *ImplApp) AuthMiddleware(ctx *gin.Context) {
+	accessToken, err := ctx.Cookie(AccessTokenName)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrAccessTokenRequired.Error()})
+		return
+	}
+
+	// токены, выданные OAuth клиентам, не дают доступа к управлению аккаунтом
+	claims, err := a.JWTManager.ValidateAccessToken(accessToken)
+	if err != nil || claims.ClientID != "" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrUnauthorized.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, claims.Subject)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrUnauthorized.Error()})
+		return
+	}
+	if !user.Active {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrAccountDeactivated.Error()})
+		return
+	}
+
+	ctx.Set(UserIDContextKey, claims.Subject)
+	ctx.Set(AccessClaimsContextKey, claims)
+	ctx.Next()
+}
+
+// Middleware проверки роли пользователя. Должен использоваться после AuthMiddleware
+func (a *ImplApp) RequireRole(role string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrUnauthorized.Error()})
+			return
+		}
+
+		if user.Role != role {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrForbidden.Error()})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Middleware проверки недавнего входа для чувствительных операций. Должен использоваться после AuthMiddleware.
+//
+// Время входа берется из auth_time access токена и не меняется при /refresh, поэтому после RecentAuthMaxAge
+// пользователь должен войти заново
+func (a *ImplApp) RequireRecentAuth(ctx *gin.Context) {
+	authTime := AccessAuthTime(ctx.MustGet(AccessClaimsContextKey).(*jwt.AccessClaims))
+	if time.Since(authTime) > RecentAuthMaxAge {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrRecentAuthRequired.Error()})
+		return
+	}
+
+	ctx.Next()
+}
+
+// Возвращает время аутентификации пользователя из payload access токена
+func AccessAuthTime(claims *jwt.AccessClaims) time.Time {
+	// токены, выпущенные до появления auth_time, не знают времени аутентификации
+	if claims.AuthTime == nil {
+		return claims.IssuedAt.Time
+	}
+	return claims.AuthTime.Time
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/jwt"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Выполняет защищенный AuthMiddleware запрос с access токеном пользователя userID
+func authenticatedRequest(t *testing.T, a *ImplApp, userID string) *httptest.ResponseRecorder {
+	t.Helper()
+	accessToken, _, err := a.JWTManager.GenereteTokenPair(userID, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to generate tokens: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/protected", a.AuthMiddleware, func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"user_id": ctx.GetString(UserIDContextKey)})
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	request.AddCookie(&http.Cookie{Name: AccessTokenName, Value: accessToken})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAuthMiddlewareChecksUser(t *testing.T) {
+	active := models.NewUser("alice@example.com", "password hash")
+	inactive := models.NewUser("bob@example.com", "password hash")
+	inactive.Active = false
+	deleted := models.NewUser("carol@example.com", "password hash")
+	a := newTestApp(t, active, inactive)
+
+	tests := map[string]struct {
+		user *models.User
+		want int
+	}{
+		"active":   {active, http.StatusOK},
+		"inactive": {inactive, http.StatusForbidden},
+		"deleted":  {deleted, http.StatusUnauthorized},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := authenticatedRequest(t, a, test.user.UserID.String())
+			if recorder.Code != test.want {
+				t.Fatalf("expected %d, got %d: %s", test.want, recorder.Code, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestUserInfoChecksUser(t *testing.T) {
+	active := models.NewUser("alice@example.com", "password hash")
+	inactive := models.NewUser("bob@example.com", "password hash")
+	inactive.Active = false
+	deleted := models.NewUser("carol@example.com", "password hash")
+	a := newTestApp(t, active, inactive)
+
+	tests := map[string]struct {
+		user *models.User
+		want int
+	}{
+		"active":   {active, http.StatusOK},
+		"inactive": {inactive, http.StatusUnauthorized},
+		"deleted":  {deleted, http.StatusUnauthorized},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			accessToken, _, err := a.JWTManager.GenereteTokenPair(test.user.UserID.String(), "127.0.0.1",
+				jwt.WithClientID("client"), jwt.WithScope("openid email"))
+			if err != nil {
+				t.Fatalf("failed to generate tokens: %v", err)
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+			request.Header.Set("Authorization", "Bearer "+accessToken)
+			ctx, recorder := newTestContext(request, "")
+			a.UserInfoHandler(ctx)
+			if recorder.Code != test.want {
+				t.Fatalf("expected %d, got %d: %s", test.want, recorder.Code, recorder.Body)
+			}
+			if test.want == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+				t.Fatalf("unexpected WWW-Authenticate header %q", recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
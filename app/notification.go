@@ -0,0 +1,91 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/outbox"
+	"github.com/gin-gonic/gin"
+)
+
+// Названия шаблонов писем (см. mailer.Templates)
+const (
+	MailTemplateNewIP                = "new_ip"
+	MailTemplatePasswordChanged      = "password_changed"
+	MailTemplateEmailChangeConfirm   = "email_change_confirm"
+	MailTemplateEmailChangeRequested = "email_change_requested"
+	MailTemplateEmailChanged         = "email_changed"
+	MailTemplateAccountLocked        = "account_locked"
+	MailTemplateRecoveryCodeUsed     = "recovery_code_used"
+	MailTemplateTOTPDisabled         = "totp_disabled"
+	MailTemplatePasskeyAdded         = "passkey_added"
+	MailTemplatePasskeyRemoved       = "passkey_removed"
+	MailTemplateEmailLogin           = "email_login"
+)
+
+type ChangeLocaleBody struct {
+	Locale string `json:"locale" binding:"required"`
+}
+
+// Ставит письмо по шаблону template на языке locale в очередь отправки (см. outbox.Worker).
+// Чтобы письмо не потерялось и не ушло при откате изменения, о котором оно сообщает,
+// вызывается с контекстом Transactor.WithinTransaction вместе с этим изменением
+func (a *ImplApp) EnqueueMail(ctx context.Context, to string, locale string, template string, data gin.H) error {
+	return a.enqueueMail(ctx, to, locale, template, data, nil)
+}
+
+// Ставит в очередь письмо со ссылками или кодами, действительными до expiresAt (см. EnqueueMail).
+// Если письмо не удастся отправить до expiresAt, оно не будет отправлено и не сможет быть повторено администратором
+func (a *ImplApp) EnqueueExpiringMail(ctx context.Context, to string, locale string, template string, data gin.H, expiresAt time.Time) error {
+	return a.enqueueMail(ctx, to, locale, template, data, &expiresAt)
+}
+
+func (a *ImplApp) enqueueMail(ctx context.Context, to string, locale string, template string, data gin.H, expiresAt *time.Time) error {
+	message, err := a.MailTemplates.Render(template, locale, data)
+	if err != nil {
+		return err
+	}
+	message.To = []string{to}
+
+	record, err := outbox.NewMessage(template, message, expiresAt, a.SecretEncryptor)
+	if err != nil {
+		return err
+	}
+	return a.OutboxRepo.Create(ctx, record)
+}
+
+// Обработчик смены языка писем пользователя. Требует аутентификации по access токену (см. AuthMiddleware).
+// Принимает тег BCP 47 и сохраняет ближайший поддерживаемый язык
+func (a *ImplApp) ChangeLocaleHandler(ctx *gin.Context) {
+	body := ChangeLocaleBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	locale, ok := a.MailTemplates.MatchLocale(body.Locale)
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrUnsupportedLocale.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	user.Locale = locale
+	err = a.UserRepo.Update(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": MessageLocaleChanged,
+		"locale":  locale,
+	})
+}
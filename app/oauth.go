@@ -0,0 +1,459 @@
+package app
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/jwt"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/oauth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// Размер в байтах случайного кода авторизации
+	oauthCodeSize = 32
+	// Максимальная длина nonce из запроса авторизации
+	oauthNonceMaxLength = 255
+)
+
+var (
+	// Время, в течении которого код авторизации можно обменять на токены
+	OAuthCodeExpires = time.Minute
+)
+
+// Обработчик запроса авторизации OAuth 2.0 (только response_type=code с PKCE S256).
+//
+// Пользователь должен быть авторизован в самом сервисе (cookie access_token после /login), поэтому
+// для входа действуют все обычные проверки: бэкенды паролей, блокировки и второй фактор. Если сессии нет,
+// отвечает 401 login_required, а с prompt=none перенаправляет клиента с этой ошибкой. Сессия, аутентификация
+// в которой была раньше max_age секунд назад, считается отсутствующей.
+// Страницы входа у сервиса нет, поэтому запросить повторную аутентификацию через prompt=login нельзя:
+// такой запрос отклоняется с login_required, для требования недавнего входа клиент должен передать max_age.
+// Согласие пользователя не запрашивается: клиенты регистрируются администратором.
+//
+// Ошибки до проверки client_id и redirect_uri возвращаются в ответе, после - передаются клиенту через redirect_uri
+func (a *ImplApp) AuthorizeHandler(ctx *gin.Context) {
+	client, err := a.OAuthClientRepo.FindByID(ctx, ctx.Query("client_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidClient, "unknown client_id"))
+		return
+	}
+
+	// redirect_uri сравнивается с зарегистрированными посимвольно, без нормализации
+	redirectURI := ctx.Query("redirect_uri")
+	if !client.HasRedirectURI(redirectURI) {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidRequest, "redirect_uri is not registered for client"))
+		return
+	}
+
+	state := ctx.Query("state")
+
+	if ctx.Query("response_type") != oauth.ResponseTypeCode {
+		a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorUnsupportedResponseType, "only code is supported"))
+		return
+	}
+
+	codeChallenge := ctx.Query("code_challenge")
+	if ctx.Query("code_challenge_method") != oauth.CodeChallengeMethodS256 || !oauth.ValidCodeChallenge(codeChallenge) {
+		a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorInvalidRequest, "PKCE with S256 is required"))
+		return
+	}
+
+	nonce := ctx.Query("nonce")
+	if len(nonce) > oauthNonceMaxLength {
+		a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorInvalidRequest, "nonce is too long"))
+		return
+	}
+
+	scopes := oauth.ParseScope(ctx.Query("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !oauth.ScopeAllowed(scopes, client.Scopes) {
+		a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorInvalidScope, ""))
+		return
+	}
+
+	maxAge := -1
+	if rawMaxAge := ctx.Query("max_age"); rawMaxAge != "" {
+		maxAge, err = strconv.Atoi(rawMaxAge)
+		if err != nil || maxAge < 0 {
+			a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorInvalidRequest, "invalid max_age"))
+			return
+		}
+	}
+
+	prompts := strings.Fields(ctx.Query("prompt"))
+	if slices.Contains(prompts, oauth.PromptNone) && len(prompts) > 1 {
+		a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorInvalidRequest, "prompt=none cannot be combined with other values"))
+		return
+	}
+	// OpenID Connect Core 1.0, раздел 3.1.2.1: если повторная аутентификация невозможна, возвращается login_required
+	if slices.Contains(prompts, oauth.PromptLogin) {
+		a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorLoginRequired, "prompt=login is not supported, use max_age to require a recent login"))
+		return
+	}
+
+	user, authTime := a.oauthSessionUser(ctx)
+	if user != nil && maxAge >= 0 && time.Since(authTime) > time.Duration(maxAge)*time.Second {
+		user = nil
+	}
+	if user == nil {
+		if slices.Contains(prompts, oauth.PromptNone) {
+			a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorLoginRequired, ""))
+			return
+		}
+		ctx.JSON(http.StatusUnauthorized, oauth.NewError(oauth.ErrorLoginRequired, "log in and repeat the authorization request"))
+		return
+	}
+
+	code, err := GenerateRandomToken(oauthCodeSize)
+	if err != nil {
+		a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorServerError, ""))
+		return
+	}
+
+	err = a.OAuthTokenRepo.CreateAuthorizationCode(ctx, &models.OAuthAuthorizationCode{
+		CodeHash:            HashTokenSHA256(code),
+		ClientID:            client.ClientID,
+		UserID:              user.UserID,
+		RedirectURI:         redirectURI,
+		Scope:               oauth.JoinScope(scopes),
+		Nonce:               nonce,
+		AuthTime:            authTime,
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: oauth.CodeChallengeMethodS256,
+		ExpiresAt:           time.Now().Add(OAuthCodeExpires),
+	})
+	if err != nil {
+		a.redirectOAuthError(ctx, redirectURI, state, oauth.NewError(oauth.ErrorServerError, ""))
+		return
+	}
+
+	params := url.Values{"code": {code}, "iss": {a.IDTokenIssuer.GetIssuer()}}
+	if state != "" {
+		params.Set("state", state)
+	}
+	a.redirectOAuth(ctx, redirectURI, params)
+}
+
+// Обработчик выдачи токенов OAuth 2.0. Поддерживает grant_type authorization_code, refresh_token,
+// device_code (RFC 8628) и client_credentials (только для сервисных аккаунтов).
+//
+// Конфиденциальные клиенты аутентифицируются секретом (client_secret_basic или client_secret_post),
+// публичные - только client_id, их защищает PKCE
+func (a *ImplApp) TokenHandler(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	// сервисные аккаунты хранятся отдельно от клиентов, действующих от имени пользователей
+	if ctx.PostForm("grant_type") == oauth.GrantTypeClientCredentials {
+		a.exchangeClientCredentials(ctx)
+		return
+	}
+
+	client, oauthErr := a.authenticateOAuthClient(ctx)
+	if oauthErr != nil {
+		respondInvalidClient(ctx, oauthErr)
+		return
+	}
+
+	switch ctx.PostForm("grant_type") {
+	case oauth.GrantTypeAuthorizationCode:
+		a.exchangeAuthorizationCode(ctx, client)
+	case oauth.GrantTypeRefreshToken:
+		a.exchangeOAuthRefreshToken(ctx, client)
+	case oauth.GrantTypeDeviceCode:
+		a.exchangeDeviceCode(ctx, client)
+	default:
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorUnsupportedGrantType, ""))
+	}
+}
+
+// Обменивает код авторизации на токены. Код одноразовый, redirect_uri и code_verifier должны соответствовать запросу авторизации
+func (a *ImplApp) exchangeAuthorizationCode(ctx *gin.Context, client *models.OAuthClient) {
+	code := ctx.PostForm("code")
+	if code == "" {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidRequest, "code is required"))
+		return
+	}
+
+	authorization, err := a.OAuthTokenRepo.TakeAuthorizationCode(ctx, HashTokenSHA256(code))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidGrant, "invalid or expired code"))
+		return
+	}
+
+	if authorization.ClientID != client.ClientID ||
+		authorization.RedirectURI != ctx.PostForm("redirect_uri") ||
+		!oauth.VerifyPKCE(authorization.CodeChallenge, authorization.CodeChallengeMethod, ctx.PostForm("code_verifier")) {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidGrant, ""))
+		return
+	}
+
+	user, err := a.UserRepo.FindByID(ctx, authorization.UserID)
+	if err != nil || !user.Active {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidGrant, ""))
+		return
+	}
+
+	a.issueOAuthTokens(ctx, client, user, authorization.Scope, authorization.AuthTime, authorization.Nonce)
+}
+
+// Обменивает refresh токен клиента на новую пару. Использованный refresh токен становится недействительным.
+// Переданный scope может только сузить выданные ранее
+func (a *ImplApp) exchangeOAuthRefreshToken(ctx *gin.Context, client *models.OAuthClient) {
+	refreshToken := ctx.PostForm("refresh_token")
+	claims, err := a.JWTManager.ValidateRefreshToken(refreshToken)
+	if err != nil || claims.ClientID != client.ClientID {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidGrant, "invalid refresh token"))
+		return
+	}
+
+	tokenID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidGrant, "invalid refresh token"))
+		return
+	}
+
+	record, err := a.OAuthTokenRepo.TakeRefreshToken(ctx, tokenID)
+	if err != nil || subtle.ConstantTimeCompare([]byte(record.TokenHash), []byte(HashTokenSHA256(refreshToken))) != 1 {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidGrant, "invalid refresh token"))
+		return
+	}
+
+	scope := record.Scope
+	if requested := oauth.ParseScope(ctx.PostForm("scope")); len(requested) > 0 {
+		if !oauth.ScopeAllowed(requested, oauth.ParseScope(record.Scope)) {
+			ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidScope, ""))
+			return
+		}
+		scope = oauth.JoinScope(requested)
+	}
+
+	user, err := a.UserRepo.FindByID(ctx, record.UserID)
+	if err != nil || !user.Active {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidGrant, ""))
+		return
+	}
+
+	authTime := time.Now()
+	if claims.AuthTime != nil {
+		authTime = claims.AuthTime.Time
+	}
+
+	// nonce относится только к запросу авторизации, в ID токен при обновлении он не передается
+	a.issueOAuthTokens(ctx, client, user, scope, authTime, "")
+}
+
+// Выпускает пару токенов для клиента через jwt.JWT, сохраняет refresh токен и отправляет ответ /token.
+// Для scope openid в ответ добавляется ID токен
+func (a *ImplApp) issueOAuthTokens(
+	ctx *gin.Context,
+	client *models.OAuthClient,
+	user *models.User,
+	scope string,
+	authTime time.Time,
+	nonce string,
+) {
+	clientIP := a.GetClientIP(ctx, a.LoginRemoteIPMode)
+	accessToken, refreshToken, err := a.JWTManager.GenereteTokenPair(
+		user.UserID.String(),
+		clientIP,
+		jwt.WithClientID(client.ClientID),
+		jwt.WithScope(scope),
+		jwt.WithAuthTime(authTime),
+	)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, oauth.NewError(oauth.ErrorServerError, ""))
+		return
+	}
+
+	refreshClaims, err := a.JWTManager.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, oauth.NewError(oauth.ErrorServerError, ""))
+		return
+	}
+
+	err = a.OAuthTokenRepo.CreateRefreshToken(ctx, &models.OAuthRefreshToken{
+		ID:        uuid.MustParse(refreshClaims.ID),
+		ClientID:  client.ClientID,
+		UserID:    user.UserID,
+		TokenHash: HashTokenSHA256(refreshToken),
+		Scope:     scope,
+		ExpiresAt: refreshClaims.ExpiresAt.Time,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, oauth.NewError(oauth.ErrorServerError, ""))
+		return
+	}
+
+	response := gin.H{
+		"access_token":  accessToken,
+		"token_type":    oauth.TokenTypeBearer,
+		"expires_in":    a.JWTManager.GetAccessExpiresSec(),
+		"refresh_token": refreshToken,
+		"scope":         scope,
+	}
+
+	scopes := oauth.ParseScope(scope)
+	if slices.Contains(scopes, oauth.ScopeOpenID) {
+		claims := userClaims(user, scopes)
+		claims.Nonce = nonce
+		claims.AccessTokenHash = jwt.AccessTokenHash(accessToken)
+
+		idToken, err := a.IDTokenIssuer.GenerateIDToken(user.UserID.String(), client.ClientID, authTime, claims)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, oauth.NewError(oauth.ErrorServerError, ""))
+			return
+		}
+		response["id_token"] = idToken
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// Выдает access токен сервисному аккаунту. Refresh токен не выдается, за новым токеном аккаунт обращается повторно
+func (a *ImplApp) exchangeClientCredentials(ctx *gin.Context) {
+	clientID, clientSecret, oauthErr := oauthClientCredentials(ctx)
+	if oauthErr != nil {
+		respondInvalidClient(ctx, oauthErr)
+		return
+	}
+
+	account, err := a.ServiceAccountRepo.FindByID(ctx, clientID)
+	if err != nil || clientSecret == "" || !CompareHashAndToken(account.SecretHash, clientSecret) {
+		respondInvalidClient(ctx, oauth.NewError(oauth.ErrorInvalidClient, ""))
+		return
+	}
+
+	scopes := oauth.ParseScope(ctx.PostForm("scope"))
+	if len(scopes) == 0 {
+		scopes = account.Scopes
+	}
+	if !oauth.ScopeAllowed(scopes, account.Scopes) {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidScope, ""))
+		return
+	}
+
+	scope := oauth.JoinScope(scopes)
+	accessToken, err := a.JWTManager.GenerateMachineToken(account.ClientID, scope)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, oauth.NewError(oauth.ErrorServerError, ""))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   oauth.TokenTypeBearer,
+		"expires_in":   a.JWTManager.GetAccessExpiresSec(),
+		"scope":        scope,
+	})
+}
+
+// Аутентифицирует клиента по HTTP Basic или параметрам client_id и client_secret формы
+func (a *ImplApp) authenticateOAuthClient(ctx *gin.Context) (*models.OAuthClient, *oauth.Error) {
+	clientID, clientSecret, oauthErr := oauthClientCredentials(ctx)
+	if oauthErr != nil {
+		return nil, oauthErr
+	}
+
+	client, err := a.OAuthClientRepo.FindByID(ctx, clientID)
+	if err != nil {
+		return nil, oauth.NewError(oauth.ErrorInvalidClient, "")
+	}
+
+	if client.Public {
+		if clientSecret != "" {
+			return nil, oauth.NewError(oauth.ErrorInvalidClient, "public client must not use a secret")
+		}
+		return client, nil
+	}
+
+	if clientSecret == "" || !CompareHashAndToken(client.SecretHash, clientSecret) {
+		return nil, oauth.NewError(oauth.ErrorInvalidClient, "")
+	}
+	return client, nil
+}
+
+// Возвращает client_id и client_secret из HTTP Basic или из параметров формы
+func oauthClientCredentials(ctx *gin.Context) (string, string, *oauth.Error) {
+	clientID, clientSecret, basic := ctx.Request.BasicAuth()
+	if basic {
+		// в Basic заголовке значения дополнительно закодированы как application/x-www-form-urlencoded
+		var err error
+		clientID, err = url.QueryUnescape(clientID)
+		if err != nil {
+			return "", "", oauth.NewError(oauth.ErrorInvalidClient, "")
+		}
+		clientSecret, err = url.QueryUnescape(clientSecret)
+		if err != nil {
+			return "", "", oauth.NewError(oauth.ErrorInvalidClient, "")
+		}
+	} else {
+		clientID = ctx.PostForm("client_id")
+		clientSecret = ctx.PostForm("client_secret")
+	}
+
+	if clientID == "" {
+		return "", "", oauth.NewError(oauth.ErrorInvalidClient, "client authentication required")
+	}
+	return clientID, clientSecret, nil
+}
+
+// Отвечает 401 invalid_client. Если клиент использовал HTTP Basic, добавляет заголовок WWW-Authenticate
+func respondInvalidClient(ctx *gin.Context, oauthErr *oauth.Error) {
+	if _, _, basic := ctx.Request.BasicAuth(); basic {
+		ctx.Header("WWW-Authenticate", `Basic realm="token"`)
+	}
+	ctx.JSON(http.StatusUnauthorized, oauthErr)
+}
+
+// Возвращает пользователя сессии сервиса (cookie access_token) и время его аутентификации или nil, если сессии нет
+func (a *ImplApp) oauthSessionUser(ctx *gin.Context) (*models.User, time.Time) {
+	accessToken, err := ctx.Cookie(AccessTokenName)
+	if err != nil {
+		return nil, time.Time{}
+	}
+
+	claims, err := a.JWTManager.ValidateAccessToken(accessToken)
+	if err != nil || claims.ClientID != "" {
+		return nil, time.Time{}
+	}
+
+	// access токен деактивированного пользователя действует до истечения срока, но входа через него не дает
+	user, err := a.UserRepo.FindByIDString(ctx, claims.Subject)
+	if err != nil || !user.Active {
+		return nil, time.Time{}
+	}
+	return user, AccessAuthTime(claims)
+}
+
+// Перенаправляет клиента на redirect_uri с параметрами ответа
+func (a *ImplApp) redirectOAuth(ctx *gin.Context, redirectURI string, params url.Values) {
+	location, err := oauth.BuildRedirectURI(redirectURI, params)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, oauth.NewError(oauth.ErrorInvalidRequest, "invalid redirect_uri"))
+		return
+	}
+	ctx.Redirect(http.StatusFound, location)
+}
+
+// Передает ошибку авторизации клиенту через redirect_uri вместе с state
+func (a *ImplApp) redirectOAuthError(ctx *gin.Context, redirectURI string, state string, oauthErr *oauth.Error) {
+	params := url.Values{"error": {oauthErr.Code}, "iss": {a.IDTokenIssuer.GetIssuer()}}
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	a.redirectOAuth(ctx, redirectURI, params)
+}
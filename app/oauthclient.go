@@ -0,0 +1,116 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/oauth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Размер в байтах случайного секрета OAuth клиента
+const oauthClientSecretSize = 32
+
+type CreateOAuthClientBody struct {
+	Name         string   `json:"name" binding:"required"`
+	RedirectURIs []string `json:"redirect_uris" binding:"required,min=1"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+}
+
+// Обработчик регистрации OAuth клиента. Требует роли администратора.
+// Секрет конфиденциального клиента возвращается только в этом ответе, в БД хранится его хеш
+func (a *ImplApp) CreateOAuthClientHandler(ctx *gin.Context) {
+	body := CreateOAuthClientBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	for _, redirectURI := range body.RedirectURIs {
+		if oauth.ValidateRedirectURI(redirectURI) != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRedirectURI.Error(), "redirect_uri": redirectURI})
+			return
+		}
+	}
+
+	scopes := body.Scopes
+	if scopes == nil {
+		scopes = oauth.DefaultScopes
+	}
+
+	client := &models.OAuthClient{
+		ClientID:     uuid.NewString(),
+		Name:         body.Name,
+		RedirectURIs: body.RedirectURIs,
+		Scopes:       scopes,
+		Public:       body.Public,
+	}
+
+	response := gin.H{
+		"message":   MessageOAuthClientCreated,
+		"client_id": client.ClientID,
+	}
+
+	if !client.Public {
+		secret, err := GenerateRandomToken(oauthClientSecretSize)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		client.SecretHash, err = HashToken(secret)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response["client_secret"] = secret
+	}
+
+	err = a.OAuthClientRepo.Create(ctx, client)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, response)
+}
+
+// Обработчик получения списка OAuth клиентов. Требует роли администратора
+func (a *ImplApp) ListOAuthClientsHandler(ctx *gin.Context) {
+	clients, err := a.OAuthClientRepo.FindAll(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(clients))
+	for _, client := range clients {
+		result = append(result, gin.H{
+			"client_id":     client.ClientID,
+			"name":          client.Name,
+			"redirect_uris": client.RedirectURIs,
+			"scopes":        client.Scopes,
+			"public":        client.Public,
+			"created_at":    client.CreatedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"clients": result})
+}
+
+// Обработчик удаления OAuth клиента. Требует роли администратора
+func (a *ImplApp) DeleteOAuthClientHandler(ctx *gin.Context) {
+	deleted, err := a.OAuthClientRepo.Delete(ctx, ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrOAuthClientNotFound.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": MessageOAuthClientDeleted})
+}
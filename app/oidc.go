@@ -0,0 +1,109 @@
+package app
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/jwt"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/oauth"
+	"github.com/gin-gonic/gin"
+)
+
+// Обработчик документа обнаружения OpenID Connect (/.well-known/openid-configuration)
+func (a *ImplApp) OpenIDConfigurationHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"issuer":                        a.IDTokenIssuer.GetIssuer(),
+		"authorization_endpoint":        a.BaseURL + "/authorize",
+		"token_endpoint":                a.BaseURL + "/token",
+		"device_authorization_endpoint": a.BaseURL + "/device/code",
+		"userinfo_endpoint":             a.BaseURL + "/userinfo",
+		"jwks_uri":                      a.BaseURL + "/.well-known/jwks.json",
+		"response_types_supported":      []string{oauth.ResponseTypeCode},
+		"response_modes_supported":      []string{"query"},
+		"grant_types_supported": []string{
+			oauth.GrantTypeAuthorizationCode,
+			oauth.GrantTypeRefreshToken,
+			oauth.GrantTypeClientCredentials,
+			oauth.GrantTypeDeviceCode,
+		},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      oauth.DefaultScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{oauth.CodeChallengeMethodS256},
+		"prompt_values_supported":               []string{oauth.PromptNone},
+		"claims_supported": []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "email", "email_verified", "updated_at",
+		},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
+
+// Обработчик набора публичных ключей для проверки ID токенов (/.well-known/jwks.json)
+func (a *ImplApp) JWKSHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, a.IDTokenIssuer.GetJWKS())
+}
+
+// Обработчик UserInfo OpenID Connect. Требует access токен OAuth клиента со scope openid в заголовке
+// Authorization: Bearer. Набор полей определяется выданными scope, для деактивированного пользователя токен недействителен
+func (a *ImplApp) UserInfoHandler(ctx *gin.Context) {
+	claims, err := a.JWTManager.ValidateAccessToken(BearerToken(ctx))
+	if err != nil || claims.ClientID == "" || claims.Machine {
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		ctx.JSON(http.StatusUnauthorized, oauth.NewError(oauth.ErrorInvalidToken, ""))
+		return
+	}
+
+	scopes := oauth.ParseScope(claims.Scope)
+	if !slices.Contains(scopes, oauth.ScopeOpenID) {
+		ctx.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		ctx.JSON(http.StatusForbidden, oauth.NewError(oauth.ErrorInsufficientScope, ""))
+		return
+	}
+
+	// токены деактивированного пользователя недействительны так же, как токены удаленного
+	user, err := a.UserRepo.FindByIDString(ctx, claims.Subject)
+	if err != nil || !user.Active {
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		ctx.JSON(http.StatusUnauthorized, oauth.NewError(oauth.ErrorInvalidToken, ""))
+		return
+	}
+
+	userInfo := userClaims(user, scopes)
+	response := gin.H{"sub": user.UserID.String()}
+	if slices.Contains(scopes, oauth.ScopeEmail) {
+		response["email"] = userInfo.Email
+		response["email_verified"] = *userInfo.EmailVerified
+	}
+	if slices.Contains(scopes, oauth.ScopeProfile) {
+		response["updated_at"] = userInfo.UpdatedAt
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// Возвращает токен из заголовка Authorization: Bearer или пустую строку
+func BearerToken(ctx *gin.Context) string {
+	header := ctx.GetHeader("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, oauth.TokenTypeBearer) {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Заполняет утверждения о пользователе, доступные по выданным scope
+func userClaims(user *models.User, scopes []string) jwt.IDClaims {
+	claims := jwt.IDClaims{}
+	if slices.Contains(scopes, oauth.ScopeEmail) {
+		emailVerified := user.EmailVerified
+		claims.Email = user.Email
+		claims.EmailVerified = &emailVerified
+	}
+	if slices.Contains(scopes, oauth.ScopeProfile) {
+		claims.UpdatedAt = user.UpdatedAt.Unix()
+	}
+	return claims
+}
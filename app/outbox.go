@@ -0,0 +1,174 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// Размер страницы списка писем очереди по умолчанию
+	OutboxDefaultPageSize = 50
+	// Максимальный размер страницы списка писем очереди
+	OutboxMaxPageSize = 200
+)
+
+// Обработчик просмотра очереди писем. Требует роли администратора.
+// Параметры запроса: status (pending, sent или dead, по умолчанию все), offset и limit.
+// Содержимое писем не возвращается, т.к. может содержать ссылки и коды входа
+func (a *ImplApp) ListOutboxHandler(ctx *gin.Context) {
+	status := ctx.Query("status")
+	switch status {
+	case "", models.OutboxStatusPending, models.OutboxStatusSent, models.OutboxStatusDead:
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidOutboxStatus.Error()})
+		return
+	}
+
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(OutboxDefaultPageSize)))
+	if err != nil || limit <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+	limit = min(limit, OutboxMaxPageSize)
+
+	messages, total, err := a.OutboxRepo.Find(ctx, status, offset, limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, outboxMessageResponse(&message))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"messages": result,
+		"total":    total,
+		"offset":   offset,
+		"limit":    limit,
+	})
+}
+
+// Обработчик просмотра письма очереди. Требует роли администратора
+func (a *ImplApp) GetOutboxMessageHandler(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrOutboxMessageNotFound.Error()})
+		return
+	}
+
+	message, err := a.OutboxRepo.FindByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrOutboxMessageNotFound.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, outboxMessageResponse(message))
+}
+
+// Обработчик повтора отправки письма, исчерпавшего попытки. Требует роли администратора.
+// Письмо возвращается в очередь со сброшенным счетчиком попыток. Устаревшие письма не повторяются,
+// т.к. ссылки и коды в них уже недействительны
+func (a *ImplApp) RetryOutboxMessageHandler(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrOutboxMessageNotFound.Error()})
+		return
+	}
+
+	retried, err := a.OutboxRepo.Retry(ctx, id, time.Now())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !retried {
+		a.outboxRetryConflict(ctx, id)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": MessageOutboxMessageRetried})
+}
+
+// Обработчик метрик очереди писем. Требует роли администратора.
+// Возвращает количество писем по статусам из БД и счетчики обработчика этого экземпляра сервиса с момента запуска
+func (a *ImplApp) OutboxStatsHandler(ctx *gin.Context) {
+	stats, err := a.OutboxRepo.Stats(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	oldestPendingAge := 0.0
+	if stats.OldestPendingAt != nil {
+		oldestPendingAge = time.Since(*stats.OldestPendingAt).Seconds()
+	}
+
+	metrics := a.OutboxWorker.Metrics()
+	worker := gin.H{
+		"sent":          metrics.Sent,
+		"failed":        metrics.Failed,
+		"dead_lettered": metrics.DeadLettered,
+		"last_run_at":   nil,
+	}
+	if !metrics.LastRunAt.IsZero() {
+		worker["last_run_at"] = metrics.LastRunAt
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"counts":                     stats.Counts,
+		"oldest_pending_age_seconds": oldestPendingAge,
+		"worker":                     worker,
+	})
+}
+
+// Отвечает на неудачный повтор письма id причиной отказа
+func (a *ImplApp) outboxRetryConflict(ctx *gin.Context, id uuid.UUID) {
+	message, err := a.OutboxRepo.FindByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrOutboxMessageNotFound.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if message.Status == models.OutboxStatusDead && message.ExpiresAt != nil && !time.Now().Before(*message.ExpiresAt) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": ErrOutboxMessageExpired.Error()})
+		return
+	}
+	ctx.JSON(http.StatusConflict, gin.H{"error": ErrOutboxMessageNotDead.Error()})
+}
+
+func outboxMessageResponse(message *models.OutboxMessage) gin.H {
+	return gin.H{
+		"id":              message.ID.String(),
+		"template":        message.Template,
+		"recipient":       message.Recipient,
+		"subject":         message.Subject,
+		"status":          message.Status,
+		"attempts":        message.Attempts,
+		"last_error":      message.LastError,
+		"next_attempt_at": message.NextAttemptAt,
+		"sent_at":         message.SentAt,
+		"expires_at":      message.ExpiresAt,
+		"created_at":      message.CreatedAt,
+	}
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/hasher"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/policy"
+	"github.com/gin-gonic/gin"
+)
+
+type ChangePasswordBody struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// Обработчик смены пароля. Требует аутентификации по access токену (см. AuthMiddleware).
+//
+// После смены пароля refresh токен пользователя перевыпускается, поэтому все остальные устройства теряют возможность
+// выполнить /refresh, а текущее получает новую пару токенов
+func (a *ImplApp) ChangePasswordHandler(ctx *gin.Context) {
+	body := ChangePasswordBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	// паролем пользователя внешнего бэкенда управляет сам бэкенд
+	if user.AuthSource != models.AuthSourceLocal {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrExternalAccount.Error()})
+		return
+	}
+
+	if !a.CompareHashAndPassword(user.Password, user.PepperVersion, body.CurrentPassword) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidCredentials.Error()})
+		return
+	}
+
+	if !a.CheckPasswordPolicy(ctx, body.NewPassword, user.Email) {
+		return
+	}
+
+	hashedPassword, pepperVersion, err := a.HashPassword(body.NewPassword)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user.Password = hashedPassword
+	user.PepperVersion = pepperVersion
+
+	accessToken, refreshToken, err := a.JWTManager.GenereteTokenPair(user.UserID.String(), a.GetClientIP(ctx, a.LoginRemoteIPMode))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Новый refresh токен сохраняется вместе с новым паролем, старые refresh токены других устройств становятся недействительны
+	b64token := EncodeTokenToBase64(refreshToken)
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := a.SaveRefreshToDB(ctx, b64token, user)
+		if err != nil {
+			return err
+		}
+		return a.EnqueueMail(ctx, user.Email, user.Locale, MailTemplatePasswordChanged, gin.H{})
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	a.SetTokenCookies(ctx, accessToken, b64token)
+
+	// refresh токены OAuth клиентов тоже отзываются
+	err = a.OAuthTokenRepo.DeleteRefreshTokensByUserID(ctx, user.UserID)
+	if err != nil {
+		slog.Warn("Failed to revoke oauth refresh tokens", "error", err.Error())
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    MessageSuccessfullyPasswordChanged,
+		"expires_in": a.JWTManager.GetAccessExpiresSec(),
+	})
+}
+
+// Проверяет пароль парольной политикой. Если пароль не прошел проверку, отправляет ответ с нарушениями и возвращает false
+func (a *ImplApp) CheckPasswordPolicy(ctx *gin.Context, password string, email string) bool {
+	err := a.PasswordPolicy.Validate(password, email)
+	if err == nil {
+		return true
+	}
+
+	var validationErr *policy.ValidationError
+	if errors.As(err, &validationErr) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":      ErrPasswordPolicy.Error(),
+			"violations": validationErr.Violations,
+		})
+		return false
+	}
+
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	return false
+}
+
+// Хеширует пароль текущим алгоритмом (см. hasher.Hasher) для записи в БД.
+// Перед хешированием применяется текущая версия перца, она возвращается вторым значением и хранится рядом с хешем
+func (a *ImplApp) HashPassword(password string) (string, int, error) {
+	pepperVersion := a.Pepper.CurrentVersion()
+	peppered, err := a.Pepper.Apply(pepperVersion, password)
+	if err != nil {
+		return "", 0, err
+	}
+
+	hashedPassword, err := a.PasswordHasher.Hash(peppered)
+	if err != nil {
+		return "", 0, err
+	}
+	return hashedPassword, pepperVersion, nil
+}
+
+// Сравнивает хешированный пароль и сырой пароль. Алгоритм определяется по префиксу хеша,
+// перед сравнением к паролю применяется перец той версии, с которой был создан хеш
+func (a *ImplApp) CompareHashAndPassword(hashedPassword string, pepperVersion int, rawPassword string) bool {
+	ok, err := hasher.CompareWithPepper(a.PasswordHasher, a.Pepper, hashedPassword, pepperVersion, rawPassword)
+	if err != nil {
+		slog.Warn("Failed to apply pepper", "version", pepperVersion, "error", err.Error())
+		return false
+	}
+	return ok
+}
+
+// Перехеширует пароль пользователя, если его хеш создан устаревшим алгоритмом, с устаревшими параметрами
+// или с устаревшей версией перца. Вызывать только после успешной проверки пароля. Изменения не сохраняются в БД.
+// Пароли пользователей внешних бэкендов (см. authenticator.Authenticator) локально не хранятся
+func (a *ImplApp) RehashPasswordIfNeeded(user *models.User, rawPassword string) {
+	if user.AuthSource != models.AuthSourceLocal {
+		return
+	}
+	if !a.PasswordHasher.NeedsRehash(user.Password) && user.PepperVersion == a.Pepper.CurrentVersion() {
+		return
+	}
+
+	hashedPassword, pepperVersion, err := a.HashPassword(rawPassword)
+	if err != nil {
+		slog.Warn("Failed to rehash password", "error", err.Error())
+		return
+	}
+	user.Password = hashedPassword
+	user.PepperVersion = pepperVersion
+}
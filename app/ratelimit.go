@@ -0,0 +1,144 @@
+package app
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+)
+
+// Названия маршрутов в настройках ограничения частоты запросов
+const (
+	RateLimitRouteRegister          = "register"
+	RateLimitRouteLogin             = "login"
+	RateLimitRouteRefresh           = "refresh"
+	RateLimitRouteLoginMFA          = "loginmfa"
+	RateLimitRouteLoginEmail        = "loginemail"
+	RateLimitRouteLoginEmailConsume = "loginemailconsume"
+	RateLimitRouteLoginFederated    = "loginfederated"
+	RateLimitRouteOAuthToken        = "oauthtoken"
+	RateLimitRouteOAuthDevice       = "oauthdevice"
+	RateLimitRouteDeviceVerify      = "deviceverify"
+)
+
+// Один проверяемый лимит: ключ bucket и его параметры
+type rateLimitCheck struct {
+	key   string
+	limit ratelimit.Limit
+}
+
+// Функция получения идентификатора аккаунта из запроса для лимита на аккаунт. Пустая строка - аккаунт неизвестен
+type AccountKeyFunc func(ctx *gin.Context) string
+
+// Middleware ограничения частоты запросов к маршруту route (token bucket).
+//
+// Проверяет лимиты на IP, на аккаунт (если accountKey не nil и вернул не пустую строку) и общий лимит маршрута.
+// Выставляет заголовки RateLimit-Limit, RateLimit-Remaining и RateLimit-Reset по самому строгому из лимитов,
+// при превышении отвечает 429 с заголовком Retry-After.
+// Если хранилище лимитов недоступно, запрос отклоняется с 503: иначе его отказ снимал бы защиту от перебора
+func (a *ImplApp) RateLimitMiddleware(route string, accountKey AccountKeyFunc) gin.HandlerFunc {
+	limits := a.RateLimits[route]
+	remoteIPMode := a.LoginRemoteIPMode
+	if route == RateLimitRouteRefresh {
+		remoteIPMode = a.RefreshRemoteIPMode
+	}
+
+	return func(ctx *gin.Context) {
+		checks := []rateLimitCheck{
+			{key: rateLimitKey(route, "ip", a.GetClientIP(ctx, remoteIPMode)), limit: limits.IP},
+			{key: route + ":route", limit: limits.Route},
+		}
+		if accountKey != nil {
+			if account := accountKey(ctx); account != "" {
+				checks = append(checks, rateLimitCheck{key: rateLimitKey(route, "account", account), limit: limits.Account})
+			}
+		}
+
+		var strictest *ratelimit.Result
+		for _, check := range checks {
+			if !check.limit.Enabled() {
+				continue
+			}
+
+			result, err := a.RateLimiter.Take(ctx, check.key, check.limit)
+			if err != nil {
+				slog.Warn("Failed to check rate limit", "key", check.key, "error", err.Error())
+				ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": ErrRateLimitUnavailable.Error()})
+				return
+			}
+
+			if strictest == nil || !result.Allowed || result.Remaining < strictest.Remaining {
+				strictest = &result
+			}
+			if !result.Allowed {
+				break
+			}
+		}
+
+		if strictest == nil {
+			ctx.Next()
+			return
+		}
+
+		ctx.Header("RateLimit-Limit", strconv.Itoa(strictest.Limit))
+		ctx.Header("RateLimit-Remaining", strconv.Itoa(strictest.Remaining))
+		ctx.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(strictest.ResetAfter.Seconds()))))
+
+		if !strictest.Allowed {
+			SetRetryAfter(ctx, strictest.RetryAfter)
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": ErrRateLimitExceeded.Error()})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Идентификатор аккаунта для /login/:guid - guid из пути. Не uuid не может быть аккаунтом,
+// поэтому для него лимит на аккаунт не проверяется и не занимает место в хранилище
+func LoginAccountKey(ctx *gin.Context) string {
+	id, err := uuid.Parse(ctx.Param("guid"))
+	if err != nil {
+		return ""
+	}
+	return id.String()
+}
+
+// Идентификатор аккаунта для /login - логин из тела запроса, приведенный так же, как email при поиске пользователя
+func PasswordLoginAccountKey(ctx *gin.Context) string {
+	body := PasswordLoginBody{}
+	err := ctx.ShouldBindBodyWith(&body, binding.JSON)
+	if err != nil {
+		return ""
+	}
+	return models.NormalizeEmail(body.Login)
+}
+
+// Идентификатор аккаунта для /refresh - subject access токена из cookie (подпись проверяется, время жизни нет)
+func (a *ImplApp) RefreshAccountKey(ctx *gin.Context) string {
+	accessToken, err := ctx.Cookie(AccessTokenName)
+	if err != nil {
+		return ""
+	}
+	claims, err := a.JWTManager.GetAccessClaimsWithoutValidation(accessToken)
+	if err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// Собирает ключ bucket из маршрута, вида лимита и идентификатора клиента. Идентификатор задает клиент,
+// поэтому слишком длинный заменяется своим sha256 хешем, чтобы ключ поместился в ratelimit.MaxKeyLength
+func rateLimitKey(route string, kind string, value string) string {
+	key := route + ":" + kind + ":" + value
+	if len(key) <= ratelimit.MaxKeyLength {
+		return key
+	}
+	return route + ":" + kind + ":sha256:" + HashTokenSHA256(value)
+}
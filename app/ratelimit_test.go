@@ -0,0 +1,36 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+func TestPasswordLoginAccountKey(t *testing.T) {
+	tests := map[string]struct {
+		body string
+		want string
+	}{
+		"normalized login": {`{"login":" Alice@Example.com ","password":"password"}`, "alice@example.com"},
+		"invalid body":     {`{"login":`, ""},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader([]byte(test.body)))
+			request.Header.Set("Content-Type", "application/json")
+			ctx, _ := newTestContext(request, "")
+
+			if got := PasswordLoginAccountKey(ctx); got != test.want {
+				t.Fatalf("expected key %q, got %q", test.want, got)
+			}
+			// обработчик читает тело после функции ключа
+			body := PasswordLoginBody{}
+			if err := ctx.ShouldBindBodyWith(&body, binding.JSON); test.want != "" && (err != nil || body.Password != "password") {
+				t.Fatalf("body is not available to the handler: %+v, %v", body, err)
+			}
+		})
+	}
+}
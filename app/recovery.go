@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Количество кодов восстановления в одном наборе
+	RecoveryCodesCount = 10
+	// Длина одной половины кода восстановления (код имеет вид xxxxx-xxxxx)
+	recoveryCodeHalfLength = 5
+	// Алфавит кодов восстановления без похожих друг на друга символов (0/o, 1/l/i)
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// Обработчик перевыпуска кодов восстановления. Требует аутентификации по access токену (см. AuthMiddleware)
+// и текущего TOTP кода. Старый набор кодов становится недействительным
+func (a *ImplApp) RegenerateRecoveryCodesHandler(ctx *gin.Context) {
+	body := TOTPCodeBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	if !user.TOTPEnabled {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrTOTPNotEnrolled.Error()})
+		return
+	}
+	if !a.VerifyTOTP(user, body.Code) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidMFACode.Error()})
+		return
+	}
+
+	err = a.UserRepo.Update(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := a.GenerateRecoveryCodes(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":        MessageRecoveryCodesRegenerated,
+		"recovery_codes": codes,
+	})
+}
+
+// Генерирует новый набор кодов восстановления и сохраняет их хеши, заменяя старый набор.
+// Возвращает коды в открытом виде, они показываются пользователю один раз
+func (a *ImplApp) GenerateRecoveryCodes(ctx *gin.Context, user *models.User) ([]string, error) {
+	codes := make([]string, 0, RecoveryCodesCount)
+	records := make([]*models.RecoveryCode, 0, RecoveryCodesCount)
+	for range RecoveryCodesCount {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		hashedCode, err := HashToken(code)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+		records = append(records, models.NewRecoveryCode(user.UserID, hashedCode))
+	}
+
+	err := a.RecoveryCodeRepo.ReplaceForUser(ctx, user.UserID, records)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Проверяет и погашает код восстановления пользователя. При успешном использовании отправляет письмо-предупреждение
+func (a *ImplApp) UseRecoveryCode(ctx *gin.Context, user *models.User, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false
+	}
+
+	records, err := a.RecoveryCodeRepo.FindUnusedByUserID(ctx, user.UserID)
+	if err != nil {
+		slog.Warn("Failed to load recovery codes", "error", err.Error())
+		return false
+	}
+
+	for _, record := range records {
+		if !CompareHashAndToken(record.CodeHash, code) {
+			continue
+		}
+
+		// код мог быть использован параллельным запросом между чтением и проверкой
+		used := false
+		err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			used, err = a.RecoveryCodeRepo.MarkUsed(ctx, record.ID, time.Now())
+			if err != nil || !used {
+				return err
+			}
+			return a.EnqueueMail(ctx, user.Email, user.Locale, MailTemplateRecoveryCodeUsed, gin.H{"Remaining": len(records) - 1})
+		})
+		if err != nil {
+			slog.Warn("Failed to use recovery code", "error", err.Error())
+			return false
+		}
+		return used
+	}
+
+	return false
+}
+
+// Генерирует код восстановления вида xxxxx-xxxxx
+func generateRecoveryCode() (string, error) {
+	bytes := make([]byte, recoveryCodeHalfLength*2)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	var code strings.Builder
+	for i, b := range bytes {
+		if i == recoveryCodeHalfLength {
+			code.WriteByte('-')
+		}
+		// небольшое смещение распределения из-за остатка от деления несущественно для 50 бит энтропии
+		code.WriteByte(recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// Приводит введенный пользователем код к виду, в котором он был сгенерирован
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) != recoveryCodeHalfLength*2 {
+		return ""
+	}
+	return code[:recoveryCodeHalfLength] + "-" + code[recoveryCodeHalfLength:]
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/federation"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// Название cookie с идентификатором незавершенного входа через провайдер SAML
+	SAMLLoginCookieName = "saml_login_session"
+	// Путь cookie незавершенного входа: она нужна только обработчикам /login/saml
+	samlLoginCookiePath = "/login/saml"
+)
+
+// Обработчик метаданных сервиса как поставщика услуг SAML. Адрес метаданных регистрируется у провайдера
+func (a *ImplApp) SAMLMetadataHandler(ctx *gin.Context) {
+	provider, ok := a.SAMLProviders[ctx.Param("provider")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUnknownIdentityProvider.Error()})
+		return
+	}
+
+	metadata, err := provider.Metadata()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// Обработчик начала входа через провайдер SAML. Перенаправляет пользователя к провайдеру с запросом AuthnRequest.
+//
+// ID запроса и RelayState сохраняются в БД, браузер получает cookie с идентификатором сессии
+func (a *ImplApp) BeginSAMLLoginHandler(ctx *gin.Context) {
+	provider, ok := a.SAMLProviders[ctx.Param("provider")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUnknownIdentityProvider.Error()})
+		return
+	}
+
+	a.redirectToSAMLProvider(ctx, provider, nil)
+}
+
+// Сохраняет ID запроса AuthnRequest и перенаправляет пользователя к провайдеру SAML.
+// Если передан userID, после возврата личность привязывается к этому пользователю вместо входа
+func (a *ImplApp) redirectToSAMLProvider(ctx *gin.Context, provider federation.SAMLProvider, userID *uuid.UUID) {
+	sessionID, err := GenerateRandomToken(federatedLoginTokenSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	relayState, err := GenerateRandomToken(federatedLoginTokenSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	authnRequestURL, requestID, err := provider.AuthnRequestURL(relayState)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = a.IdentityRepo.CreateLoginSession(ctx, &models.FederatedLoginSession{
+		ID:        HashTokenSHA256(sessionID),
+		Provider:  provider.Name(),
+		UserID:    userID,
+		StateHash: HashTokenSHA256(relayState),
+		Nonce:     requestID,
+		ExpiresAt: time.Now().Add(FederatedLoginExpires),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.setSAMLLoginCookie(ctx, sessionID, int(FederatedLoginExpires.Seconds()))
+	ctx.Redirect(http.StatusFound, authnRequestURL)
+}
+
+// Обработчик Assertion Consumer Service: принимает ответ провайдера SAML (HTTP-POST), проверяет подпись
+// утверждения закрепленными сертификатами и выдает пару токенов так же, как вход по паролю.
+//
+// Пользователь находится или создается так же, как при входе через провайдер OpenID Connect (см. FederatedLoginCallbackHandler).
+// Ответы, не запрошенные сервисом (IdP-initiated), не принимаются
+func (a *ImplApp) SAMLACSHandler(ctx *gin.Context) {
+	provider, ok := a.SAMLProviders[ctx.Param("provider")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUnknownIdentityProvider.Error()})
+		return
+	}
+
+	sessionID, err := ctx.Cookie(SAMLLoginCookieName)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidFederatedLogin.Error()})
+		return
+	}
+	a.setSAMLLoginCookie(ctx, "", -1)
+
+	session, err := a.IdentityRepo.TakeLoginSession(ctx, HashTokenSHA256(sessionID), provider.Name())
+	if err != nil || subtle.ConstantTimeCompare([]byte(session.StateHash), []byte(HashTokenSHA256(ctx.PostForm("RelayState")))) != 1 {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidFederatedLogin.Error()})
+		return
+	}
+
+	identity, err := provider.ParseResponse(ctx.Request, session.Nonce)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidFederatedLogin.Error()})
+		return
+	}
+
+	if session.UserID != nil {
+		a.linkIdentity(ctx, *session.UserID, identity)
+		return
+	}
+
+	a.completeFederatedLogin(ctx, identity)
+}
+
+// Устанавливает cookie незавершенного входа через SAML. Ответ провайдера приходит POST запросом с его страницы,
+// поэтому cookie выставляется с SameSite=None, а такие cookie браузеры принимают только с Secure
+func (a *ImplApp) setSAMLLoginCookie(ctx *gin.Context, value string, maxAge int) {
+	ctx.SetSameSite(http.SameSiteNoneMode)
+	ctx.SetCookie(SAMLLoginCookieName, value, maxAge, samlLoginCookiePath, a.Domain, true, true)
+	ctx.SetSameSite(http.SameSiteDefaultMode)
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/federation"
+)
+
+// Провайдер SAML, принимающий любой ответ на свой последний запрос. Подпись ответов проверяется
+// в тестах federation, здесь проверяется только то, как обработчики хранят и расходуют запросы
+type stubSAMLProvider struct {
+	federation.SAMLProvider
+
+	mu      sync.Mutex
+	parsed  int
+	request string
+}
+
+func (p *stubSAMLProvider) Name() string {
+	return "corp"
+}
+
+func (p *stubSAMLProvider) AuthnRequestURL(relayState string) (string, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.request = "id-" + relayState
+	return "https://idp.example.com/sso?" + url.Values{"RelayState": {relayState}}.Encode(), p.request, nil
+}
+
+func (p *stubSAMLProvider) ParseResponse(r *http.Request, requestID string) (*federation.Identity, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.parsed++
+	if requestID != p.request {
+		return nil, federation.ErrInvalidAssertion
+	}
+	return &federation.Identity{
+		Provider:      "corp",
+		Subject:       "alice-subject",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		EmailTrusted:  true,
+	}, nil
+}
+
+func TestSAMLACSRejectsReplayedResponse(t *testing.T) {
+	a := newTestApp(t)
+	provider := &stubSAMLProvider{}
+	a.SAMLProviders = map[string]federation.SAMLProvider{"corp": provider}
+
+	ctx, recorder := newTestContext(httptest.NewRequest(http.MethodGet, samlLoginCookiePath+"/corp", nil), "")
+	ctx.AddParam("provider", "corp")
+	a.BeginSAMLLoginHandler(ctx)
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("expected redirect to the provider, got %d: %s", recorder.Code, recorder.Body)
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	cookies := recorder.Result().Cookies()
+	form := url.Values{
+		"SAMLResponse": {"response"},
+		"RelayState":   {location.Query().Get("RelayState")},
+	}
+
+	postResponse := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, samlLoginCookiePath+"/corp/acs", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		ctx, recorder := newTestContext(request, "")
+		ctx.AddParam("provider", "corp")
+		a.SAMLACSHandler(ctx)
+		return recorder
+	}
+
+	if recorder := postResponse(); recorder.Code != http.StatusOK {
+		t.Fatalf("expected successful login, got %d: %s", recorder.Code, recorder.Body)
+	}
+	// тот же ответ с той же cookie: запрос уже израсходован
+	if recorder := postResponse(); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a replayed response, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if provider.parsed != 1 {
+		t.Fatalf("expected the replayed response not to reach the provider, parsed %d", provider.parsed)
+	}
+}
@@ -0,0 +1,412 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/oauth"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/policy"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/repositories"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/scim"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	// Количество пользователей в ответе на запрос списка, если клиент не передал count
+	SCIMDefaultPageSize = 100
+)
+
+// Путь SCIM API относительно адреса сервиса
+const scimPath = "/scim/v2"
+
+var scimUserFields = map[string]string{
+	scim.AttributeID:          repositories.UserFieldID,
+	scim.AttributeExternalID:  repositories.UserFieldExternalID,
+	scim.AttributeUserName:    repositories.UserFieldEmail,
+	scim.AttributeEmailsValue: repositories.UserFieldEmail,
+	scim.AttributeActive:      repositories.UserFieldActive,
+}
+
+var scimUserOperators = map[string]string{
+	scim.OperatorEqual:      repositories.UserOpEqual,
+	scim.OperatorNotEqual:   repositories.UserOpNotEqual,
+	scim.OperatorContains:   repositories.UserOpContains,
+	scim.OperatorStartsWith: repositories.UserOpStartsWith,
+	scim.OperatorEndsWith:   repositories.UserOpEndsWith,
+}
+
+// Middleware аутентификации SCIM API. Требует access токен сервисного аккаунта со scope scim
+// в заголовке Authorization: Bearer (см. exchangeClientCredentials)
+func (a *ImplApp) SCIMAuthMiddleware(ctx *gin.Context) {
+	claims, err := a.JWTManager.ValidateAccessToken(BearerToken(ctx))
+	if err != nil || !claims.Machine {
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		abortSCIMError(ctx, http.StatusUnauthorized, "", ErrUnauthorized.Error())
+		return
+	}
+
+	if !slices.Contains(oauth.ParseScope(claims.Scope), oauth.ScopeSCIM) {
+		ctx.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="scim"`)
+		abortSCIMError(ctx, http.StatusForbidden, "", ErrForbidden.Error())
+		return
+	}
+
+	ctx.Next()
+}
+
+// Обработчик описания возможностей SCIM API
+func (a *ImplApp) SCIMServiceProviderConfigHandler(ctx *gin.Context) {
+	respondSCIM(ctx, http.StatusOK, scim.ServiceProviderConfig(a.BaseURL+scimPath))
+}
+
+// Обработчик списка типов ресурсов SCIM API
+func (a *ImplApp) SCIMResourceTypesHandler(ctx *gin.Context) {
+	resourceTypes := scim.ResourceTypes(a.BaseURL + scimPath)
+	respondSCIM(ctx, http.StatusOK, scim.NewListResponse(resourceTypes, int64(len(resourceTypes)), 1, len(resourceTypes)))
+}
+
+// Обработчик списка схем SCIM API
+func (a *ImplApp) SCIMSchemasHandler(ctx *gin.Context) {
+	schemas := scim.Schemas(a.BaseURL + scimPath)
+	respondSCIM(ctx, http.StatusOK, scim.NewListResponse(schemas, int64(len(schemas)), 1, len(schemas)))
+}
+
+// Обработчик схемы SCIM API по ее идентификатору
+func (a *ImplApp) SCIMSchemaHandler(ctx *gin.Context) {
+	for _, schema := range scim.Schemas(a.BaseURL + scimPath) {
+		if schema["id"] == ctx.Param("id") {
+			respondSCIM(ctx, http.StatusOK, schema)
+			return
+		}
+	}
+	respondSCIMError(ctx, http.StatusNotFound, "", ErrSCIMSchemaNotFound.Error())
+}
+
+// Обработчик списка пользователей SCIM с фильтрацией (параметр filter) и пагинацией (startIndex и count).
+// Поддерживаемые фильтры описаны в scim.ParseFilter
+func (a *ImplApp) SCIMListUsersHandler(ctx *gin.Context) {
+	scimConditions, err := scim.ParseFilter(ctx.Query("filter"))
+	if err != nil {
+		respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeInvalidFilter, err.Error())
+		return
+	}
+	conditions := make([]repositories.UserCondition, 0, len(scimConditions))
+	for _, condition := range scimConditions {
+		conditions = append(conditions, repositories.UserCondition{
+			Field:    scimUserFields[condition.Attribute],
+			Operator: scimUserOperators[condition.Operator],
+			Value:    condition.Value,
+		})
+	}
+
+	startIndex, err := scimQueryInt(ctx, "startIndex", 1)
+	if err != nil {
+		respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeInvalidValue, err.Error())
+		return
+	}
+	count, err := scimQueryInt(ctx, "count", SCIMDefaultPageSize)
+	if err != nil {
+		respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeInvalidValue, err.Error())
+		return
+	}
+	// значения вне допустимых границ приводятся к ним (RFC 7644, раздел 3.4.2.4)
+	startIndex = max(startIndex, 1)
+	count = min(max(count, 0), scim.MaxResults)
+
+	users, total, err := a.UserRepo.Search(ctx, conditions, startIndex-1, count)
+	if err != nil {
+		respondSCIMError(ctx, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	resources := make([]scim.User, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, a.scimUser(&user))
+	}
+	respondSCIM(ctx, http.StatusOK, scim.NewListResponse(resources, total, startIndex, len(resources)))
+}
+
+// Обработчик получения пользователя SCIM
+func (a *ImplApp) SCIMGetUserHandler(ctx *gin.Context) {
+	user, ok := a.findSCIMUser(ctx)
+	if !ok {
+		return
+	}
+	respondSCIM(ctx, http.StatusOK, a.scimUser(user))
+}
+
+// Обработчик создания пользователя SCIM. userName становится email пользователя и считается подтвержденным,
+// так как его передает доверенная система учета. Без password пользователь входит только беспарольными способами
+func (a *ImplApp) SCIMCreateUserHandler(ctx *gin.Context) {
+	resource := scim.User{}
+	err := ctx.ShouldBindJSON(&resource)
+	if err != nil {
+		respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeInvalidSyntax, ErrInvalidRequestData.Error())
+		return
+	}
+
+	user := models.NewUser("", "")
+	if _, ok := a.applySCIMUser(ctx, user, &resource); !ok {
+		return
+	}
+
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := a.UserRepo.Create(ctx, user)
+		if err != nil || user.Active {
+			return err
+		}
+		// gorm не записывает нулевое значение поля с default при создании, поэтому неактивность сохраняется
+		// отдельно, в той же транзакции, чтобы пользователь не остался активным при ошибке
+		return a.UserRepo.Update(ctx, user)
+	})
+	if !a.checkSCIMSaveError(ctx, err) {
+		return
+	}
+
+	ctx.Header("Location", a.scimUserLocation(user))
+	respondSCIM(ctx, http.StatusCreated, a.scimUser(user))
+}
+
+// Обработчик замены пользователя SCIM. Отсутствующие externalId и active сбрасываются в значения по умолчанию,
+// отсутствующий password оставляет прежний пароль
+func (a *ImplApp) SCIMReplaceUserHandler(ctx *gin.Context) {
+	user, ok := a.findSCIMUser(ctx)
+	if !ok {
+		return
+	}
+
+	resource := scim.User{}
+	err := ctx.ShouldBindJSON(&resource)
+	if err != nil {
+		respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeInvalidSyntax, ErrInvalidRequestData.Error())
+		return
+	}
+
+	a.saveSCIMUser(ctx, user, &resource)
+}
+
+// Обработчик частичного изменения пользователя SCIM (см. scim.ApplyPatch)
+func (a *ImplApp) SCIMPatchUserHandler(ctx *gin.Context) {
+	user, ok := a.findSCIMUser(ctx)
+	if !ok {
+		return
+	}
+
+	request := scim.PatchRequest{}
+	err := ctx.ShouldBindJSON(&request)
+	if err != nil || len(request.Operations) == 0 {
+		respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeInvalidSyntax, ErrInvalidRequestData.Error())
+		return
+	}
+
+	// emails повторяет userName, поэтому в исходный ресурс не попадает: иначе смена одного userName
+	// не совпала бы с прежним адресом в emails
+	resource := a.scimUser(user)
+	resource.Emails = nil
+	err = scim.ApplyPatch(&resource, request.Operations)
+	if err != nil {
+		scimType := scim.ErrorTypeInvalidValue
+		switch {
+		case errors.Is(err, scim.ErrInvalidPath):
+			scimType = scim.ErrorTypeInvalidPath
+		case errors.Is(err, scim.ErrAttributeNotRemovable):
+			scimType = scim.ErrorTypeMutability
+		case errors.Is(err, scim.ErrUnsupportedPatchOp):
+			scimType = scim.ErrorTypeInvalidSyntax
+		}
+		respondSCIMError(ctx, http.StatusBadRequest, scimType, err.Error())
+		return
+	}
+
+	a.saveSCIMUser(ctx, user, &resource)
+}
+
+// Обработчик удаления пользователя SCIM. Все сеансы пользователя отзываются: refresh токены OAuth удаляются
+// вместе с ним (см. UserRepo.DeleteByID), а access токены перестают приниматься (см. AuthMiddleware)
+func (a *ImplApp) SCIMDeleteUserHandler(ctx *gin.Context) {
+	user, ok := a.findSCIMUser(ctx)
+	if !ok {
+		return
+	}
+
+	err := a.UserRepo.DeleteByID(ctx, user.UserID)
+	if err != nil {
+		respondSCIMError(ctx, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// Применяет ресурс к пользователю, сохраняет его и отправляет ответ с обновленным ресурсом
+func (a *ImplApp) saveSCIMUser(ctx *gin.Context, user *models.User, resource *scim.User) {
+	revoke, ok := a.applySCIMUser(ctx, user, resource)
+	if !ok {
+		return
+	}
+
+	err := a.UserRepo.Update(ctx, user)
+	if !a.checkSCIMSaveError(ctx, err) {
+		return
+	}
+	if revoke {
+		a.revokeOAuthSessions(ctx, user)
+	}
+
+	respondSCIM(ctx, http.StatusOK, a.scimUser(user))
+}
+
+// Переносит атрибуты ресурса SCIM в запись пользователя. Изменения не сохраняются в БД.
+//
+// Первым значением возвращает true, если сеансы пользователя нужно отозвать (пользователь деактивирован или
+// сменен пароль). Refresh токен сервиса в этом случае уже сброшен в записи, refresh токены OAuth клиентов
+// удаляются после сохранения (см. revokeOAuthSessions). Если ресурс некорректен, отправляет ответ с ошибкой
+// и возвращает false вторым значением
+func (a *ImplApp) applySCIMUser(ctx *gin.Context, user *models.User, resource *scim.User) (bool, bool) {
+	email := models.NormalizeEmail(resource.UserName)
+	if email == "" {
+		respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeInvalidValue, ErrSCIMUserNameRequired.Error())
+		return false, false
+	}
+	if primary := resource.PrimaryEmail(); primary != "" && !strings.EqualFold(primary, email) {
+		respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeInvalidValue, ErrSCIMEmailMismatch.Error())
+		return false, false
+	}
+
+	revoke := false
+	if resource.Password != "" {
+		// паролем пользователя внешнего бэкенда управляет сам бэкенд
+		if user.AuthSource != models.AuthSourceLocal {
+			respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeMutability, ErrExternalAccount.Error())
+			return false, false
+		}
+
+		err := a.PasswordPolicy.Validate(resource.Password, email)
+		var validationErr *policy.ValidationError
+		if errors.As(err, &validationErr) {
+			respondSCIMError(ctx, http.StatusBadRequest, scim.ErrorTypeInvalidValue, validationErr.Error())
+			return false, false
+		}
+		if err != nil {
+			respondSCIMError(ctx, http.StatusInternalServerError, "", err.Error())
+			return false, false
+		}
+
+		hashedPassword, pepperVersion, err := a.HashPassword(resource.Password)
+		if err != nil {
+			respondSCIMError(ctx, http.StatusInternalServerError, "", err.Error())
+			return false, false
+		}
+		user.Password = hashedPassword
+		user.PepperVersion = pepperVersion
+		revoke = true
+	}
+
+	if email != user.Email {
+		user.Email = email
+		user.EmailVerified = true
+		resetEmailChange(user)
+	}
+	user.ExternalID = resource.ExternalID
+
+	active := resource.IsActive()
+	if user.Active && !active {
+		revoke = true
+	}
+	user.Active = active
+
+	if revoke {
+		user.RefreshToken = ""
+	}
+	return revoke, true
+}
+
+// Проверяет ошибку сохранения пользователя SCIM. Если она есть, отправляет ответ с ошибкой и возвращает false
+func (a *ImplApp) checkSCIMSaveError(ctx *gin.Context, err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		respondSCIMError(ctx, http.StatusConflict, scim.ErrorTypeUniqueness, ErrEmailTaken.Error())
+		return false
+	}
+	if err != nil {
+		respondSCIMError(ctx, http.StatusInternalServerError, "", err.Error())
+		return false
+	}
+	return true
+}
+
+// Удаляет refresh токены OAuth клиентов пользователя. Ошибка только логируется
+func (a *ImplApp) revokeOAuthSessions(ctx *gin.Context, user *models.User) {
+	err := a.OAuthTokenRepo.DeleteRefreshTokensByUserID(ctx, user.UserID)
+	if err != nil {
+		slog.Warn("Failed to revoke oauth refresh tokens", "error", err.Error())
+	}
+}
+
+// Находит пользователя по id из пути. Если пользователь не найден, отправляет ответ с ошибкой и возвращает false
+func (a *ImplApp) findSCIMUser(ctx *gin.Context) (*models.User, bool) {
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.Param("id"))
+	if err != nil {
+		respondSCIMError(ctx, http.StatusNotFound, "", ErrUserNotFound.Error())
+		return nil, false
+	}
+	return user, true
+}
+
+// Собирает ресурс SCIM пользователя. Пароль в ресурс не попадает
+func (a *ImplApp) scimUser(user *models.User) scim.User {
+	active := user.Active
+	created := user.CreatedAt
+	lastModified := user.UpdatedAt
+	return scim.User{
+		Schemas:    []string{scim.SchemaUser},
+		ID:         user.UserID.String(),
+		ExternalID: user.ExternalID,
+		UserName:   user.Email,
+		Emails:     []scim.Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:     &active,
+		Meta: &scim.Meta{
+			ResourceType: scim.ResourceTypeUser,
+			Created:      &created,
+			LastModified: &lastModified,
+			Location:     a.scimUserLocation(user),
+		},
+	}
+}
+
+func (a *ImplApp) scimUserLocation(user *models.User) string {
+	return a.BaseURL + scimPath + "/Users/" + user.UserID.String()
+}
+
+// Возвращает целочисленный параметр запроса или defaultValue, если параметра нет
+func scimQueryInt(ctx *gin.Context, name string, defaultValue int) (int, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New(name + " must be an integer")
+	}
+	return result, nil
+}
+
+func respondSCIM(ctx *gin.Context, status int, body any) {
+	ctx.Header("Content-Type", scim.ContentType)
+	ctx.JSON(status, body)
+}
+
+func respondSCIMError(ctx *gin.Context, status int, scimType string, detail string) {
+	respondSCIM(ctx, status, scim.NewError(status, scimType, detail))
+}
+
+func abortSCIMError(ctx *gin.Context, status int, scimType string, detail string) {
+	ctx.Header("Content-Type", scim.ContentType)
+	ctx.AbortWithStatusJSON(status, scim.NewError(status, scimType, detail))
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CreateServiceAccountBody struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes"`
+}
+
+// Обработчик создания сервисного аккаунта. Требует роли администратора.
+// Секрет возвращается только в этом ответе, в БД хранится его хеш
+func (a *ImplApp) CreateServiceAccountHandler(ctx *gin.Context) {
+	body := CreateServiceAccountBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	scopes := body.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	secret, err := GenerateRandomToken(oauthClientSecretSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	secretHash, err := HashToken(secret)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	account := &models.ServiceAccount{
+		ClientID:   uuid.NewString(),
+		SecretHash: secretHash,
+		Name:       body.Name,
+		Scopes:     scopes,
+	}
+	err = a.ServiceAccountRepo.Create(ctx, account)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message":       MessageServiceAccountCreated,
+		"client_id":     account.ClientID,
+		"client_secret": secret,
+	})
+}
+
+// Обработчик получения списка сервисных аккаунтов. Требует роли администратора
+func (a *ImplApp) ListServiceAccountsHandler(ctx *gin.Context) {
+	accounts, err := a.ServiceAccountRepo.FindAll(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(accounts))
+	for _, account := range accounts {
+		result = append(result, gin.H{
+			"client_id":  account.ClientID,
+			"name":       account.Name,
+			"scopes":     account.Scopes,
+			"created_at": account.CreatedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"service_accounts": result})
+}
+
+// Обработчик удаления сервисного аккаунта. Требует роли администратора.
+// Уже выданные токены действуют до истечения срока, новые аккаунт получить не сможет
+func (a *ImplApp) DeleteServiceAccountHandler(ctx *gin.Context) {
+	deleted, err := a.ServiceAccountRepo.Delete(ctx, ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrServiceAccountNotFound.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": MessageServiceAccountDeleted})
+}
@@ -1,8 +1,10 @@
 package app
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -42,17 +44,21 @@ func CompareHashAndToken(hashedToken string, b64token string) bool {
 	return err == nil
 }
 
-// Хеширует пароль с использованием bcrypt для записи в БД
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// Генерирует случайный токен из size байт, закодированный в base64 (URL safe, без паддинга)
+func GenerateRandomToken(size int) (string, error) {
+	bytes := make([]byte, size)
+	_, err := rand.Read(bytes)
 	if err != nil {
 		return "", err
 	}
-	return string(hash), nil
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
 }
 
-// Сравнивает хешированный bcrypt пароль и сырой пароль
-func CompareHashAndPassword(hashedPassword string, rawPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(rawPassword))
-	return err == nil
+// Хеширует случайный токен при помощи sha256 и возвращает hex строку.
+//
+// В отличие от HashToken результат детерминирован, поэтому по нему можно искать запись в БД.
+// Использовать только для токенов с высокой энтропией (см. GenerateRandomToken)
+func HashTokenSHA256(token string) string {
+	sha := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sha[:])
 }
@@ -0,0 +1,529 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const (
+	// Название cookie с идентификатором незавершенной WebAuthn церемонии
+	WebAuthnSessionCookieName = "webauthn_session"
+	// Размер в байтах случайного идентификатора WebAuthn церемонии
+	webAuthnSessionIDSize = 32
+)
+
+var (
+	// Время на завершение WebAuthn церемонии, если библиотека не задала свое
+	WebAuthnSessionExpires = 5 * time.Minute
+)
+
+type WebAuthnMFABeginBody struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// Пользователь вместе со своими учетными данными в виде, который ожидает go-webauthn
+type webAuthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+// В качестве user handle используются байты uuid пользователя
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.user.UserID[:]
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, credential := range u.credentials {
+		credentials = append(credentials, toWebAuthnCredential(credential))
+	}
+	return credentials
+}
+
+// Обработчик начала регистрации passkey. Требует аутентификации по access токену (см. AuthMiddleware)
+// и недавнего входа (см. RequireRecentAuth). Возвращает параметры для navigator.credentials.create()
+func (a *ImplApp) BeginWebAuthnRegistrationHandler(ctx *gin.Context) {
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	waUser, err := a.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	creation, session, err := a.WebAuthn.BeginRegistration(
+		waUser,
+		webauthn.WithExclusions(webauthn.Credentials(waUser.WebAuthnCredentials()).CredentialDescriptors()),
+		// учетные данные должны быть обнаруживаемыми, иначе вход без пароля невозможен
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = a.saveWebAuthnSession(ctx, models.WebAuthnSessionPurposeRegistration, &user.UserID, session)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, creation)
+}
+
+// Обработчик завершения регистрации passkey. Требует аутентификации по access токену (см. AuthMiddleware)
+// и недавнего входа (см. RequireRecentAuth). Принимает ответ navigator.credentials.create() в теле запроса
+func (a *ImplApp) FinishWebAuthnRegistrationHandler(ctx *gin.Context) {
+	user, err := a.UserRepo.FindByIDString(ctx, ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	session, err := a.takeWebAuthnSession(ctx, models.WebAuthnSessionPurposeRegistration)
+	if err != nil || session.UserID == nil || *session.UserID != user.UserID {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidWebAuthnSession.Error()})
+		return
+	}
+
+	waUser, err := a.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := a.WebAuthn.FinishRegistration(waUser, *session.data, ctx.Request)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrWebAuthnVerificationFailed.Error()})
+		return
+	}
+
+	record := fromWebAuthnCredential(user.UserID, credential)
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := a.WebAuthnRepo.CreateCredential(ctx, record)
+		if err != nil {
+			return err
+		}
+		return a.EnqueueMail(ctx, user.Email, user.Locale, MailTemplatePasskeyAdded, gin.H{})
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": MessageWebAuthnCredentialRegistered,
+		"id":      record.ID.String(),
+	})
+}
+
+// Обработчик получения списка passkey пользователя. Требует аутентификации по access токену (см. AuthMiddleware)
+func (a *ImplApp) ListWebAuthnCredentialsHandler(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	credentials, err := a.WebAuthnRepo.FindCredentialsByUserID(ctx, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(credentials))
+	for _, credential := range credentials {
+		result = append(result, gin.H{
+			"id":           credential.ID.String(),
+			"transports":   splitTransports(credential.Transports),
+			"attachment":   credential.Attachment,
+			"created_at":   credential.CreatedAt,
+			"last_used_at": credential.LastUsedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"credentials": result})
+}
+
+// Обработчик удаления passkey пользователя. Требует аутентификации по access токену (см. AuthMiddleware)
+// и недавнего входа (см. RequireRecentAuth). Последний оставшийся способ входа удалить нельзя.
+// Об удалении пользователь получает письмо
+func (a *ImplApp) DeleteWebAuthnCredentialHandler(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.GetString(UserIDContextKey))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrWebAuthnCredentialNotFound.Error()})
+		return
+	}
+
+	deleted := false
+	err = a.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// блокировка пользователя не дает параллельным удалениям удалить два последних способа входа
+		user, err := a.UserRepo.FindByIDForUpdate(ctx, userID)
+		if err != nil {
+			return err
+		}
+		err = a.CheckNotLastLoginMethod(ctx, user)
+		if err != nil {
+			return err
+		}
+		deleted, err = a.WebAuthnRepo.DeleteCredential(ctx, userID, id)
+		if err != nil || !deleted {
+			return err
+		}
+		return a.EnqueueMail(ctx, user.Email, user.Locale, MailTemplatePasskeyRemoved, gin.H{})
+	})
+	if !a.handleLoginMethodRemovalError(ctx, err) {
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrWebAuthnCredentialNotFound.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": MessageWebAuthnCredentialDeleted})
+}
+
+// Обработчик начала входа по passkey без пароля. Пользователь определяется по выбранным учетным данным.
+// Возвращает параметры для navigator.credentials.get()
+func (a *ImplApp) BeginPasskeyLoginHandler(ctx *gin.Context) {
+	assertion, session, err := a.WebAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = a.saveWebAuthnSession(ctx, models.WebAuthnSessionPurposeLogin, nil, session)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, assertion)
+}
+
+// Обработчик завершения входа по passkey без пароля. Принимает ответ navigator.credentials.get() в теле запроса
+// и выдает обычную пару токенов. Passkey с проверкой пользователя (UV) сам по себе является двумя факторами
+func (a *ImplApp) FinishPasskeyLoginHandler(ctx *gin.Context) {
+	session, err := a.takeWebAuthnSession(ctx, models.WebAuthnSessionPurposeLogin)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidWebAuthnSession.Error()})
+		return
+	}
+
+	var waUser *webAuthnUser
+	_, credential, err := a.WebAuthn.FinishPasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		user, err := a.UserRepo.FindByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		waUser, err = a.loadWebAuthnUser(ctx, user)
+		return waUser, err
+	}, *session.data, ctx.Request)
+	if err != nil || waUser == nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrWebAuthnVerificationFailed.Error()})
+		return
+	}
+
+	clientIP := a.GetClientIP(ctx, a.LoginRemoteIPMode)
+	if !a.CheckLoginAllowed(ctx, waUser.user, clientIP) {
+		return
+	}
+
+	if !a.updateWebAuthnCredentialUsage(ctx, waUser, credential) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrWebAuthnVerificationFailed.Error()})
+		return
+	}
+
+	ResetLoginFailures(waUser.user)
+	err = a.IssueTokenPair(ctx, waUser.user, clientIP)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    MessageSuccessfullyLoggedIn,
+		"expires_in": a.JWTManager.GetAccessExpiresSec(),
+	})
+}
+
+// Обработчик начала проверки passkey как второго фактора. Принимает токен MFA челленджа, выданный LoginHandler
+func (a *ImplApp) BeginWebAuthnMFAHandler(ctx *gin.Context) {
+	body := WebAuthnMFABeginBody{}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidRequestData.Error()})
+		return
+	}
+
+	claims, err := a.JWTManager.ValidateMFAToken(body.MFAToken)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidMFAToken.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByIDString(ctx, claims.Subject)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	waUser, err := a.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(waUser.credentials) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrWebAuthnNotEnrolled.Error()})
+		return
+	}
+
+	assertion, session, err := a.WebAuthn.BeginLogin(waUser)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = a.saveWebAuthnSession(ctx, models.WebAuthnSessionPurposeMFA, &user.UserID, session)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, assertion)
+}
+
+// Обработчик завершения проверки passkey как второго фактора. Принимает ответ navigator.credentials.get() в теле запроса.
+// Церемония была начата только после проверки токена MFA челленджа, поэтому пользователь берется из ее данных
+func (a *ImplApp) FinishWebAuthnMFAHandler(ctx *gin.Context) {
+	session, err := a.takeWebAuthnSession(ctx, models.WebAuthnSessionPurposeMFA)
+	if err != nil || session.UserID == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidWebAuthnSession.Error()})
+		return
+	}
+
+	user, err := a.UserRepo.FindByID(ctx, *session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrUserNotFound.Error()})
+		return
+	}
+
+	clientIP := a.GetClientIP(ctx, a.LoginRemoteIPMode)
+	if !a.CheckLoginAllowed(ctx, user, clientIP) {
+		return
+	}
+
+	waUser, err := a.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := a.WebAuthn.FinishLogin(waUser, *session.data, ctx.Request)
+	if err != nil || !a.updateWebAuthnCredentialUsage(ctx, waUser, credential) {
+		a.RegisterLoginFailure(ctx, user, clientIP)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": ErrWebAuthnVerificationFailed.Error()})
+		return
+	}
+
+	ResetLoginFailures(user)
+	err = a.IssueTokenPair(ctx, user, clientIP)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    MessageSuccessfullyLoggedIn,
+		"expires_in": a.JWTManager.GetAccessExpiresSec(),
+	})
+}
+
+// Загружает учетные данные пользователя для церемоний go-webauthn
+func (a *ImplApp) loadWebAuthnUser(ctx *gin.Context, user *models.User) (*webAuthnUser, error) {
+	credentials, err := a.WebAuthnRepo.FindCredentialsByUserID(ctx, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{
+		user:        user,
+		credentials: credentials,
+	}, nil
+}
+
+// Обновляет счетчик подписей и время использования учетных данных после успешной проверки.
+// Возвращает false, если счетчик указывает на клонированный аутентификатор
+func (a *ImplApp) updateWebAuthnCredentialUsage(ctx *gin.Context, waUser *webAuthnUser, credential *webauthn.Credential) bool {
+	if credential.Authenticator.CloneWarning {
+		slog.Warn("WebAuthn sign counter did not increase, authenticator may be cloned", "user_id", waUser.user.UserID.String())
+		return false
+	}
+
+	for _, record := range waUser.credentials {
+		if string(record.CredentialID) != string(credential.ID) {
+			continue
+		}
+
+		now := time.Now()
+		record.SignCount = credential.Authenticator.SignCount
+		record.Flags = uint8(credential.Flags.ProtocolValue())
+		record.LastUsedAt = &now
+		err := a.WebAuthnRepo.UpdateCredential(ctx, &record)
+		if err != nil {
+			slog.Warn("Failed to update WebAuthn credential", "error", err.Error())
+		}
+		return true
+	}
+
+	return false
+}
+
+// Сохраняет данные церемонии в БД и устанавливает cookie с ее идентификатором
+func (a *ImplApp) saveWebAuthnSession(ctx *gin.Context, purpose string, userID *uuid.UUID, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	sessionID, err := GenerateRandomToken(webAuthnSessionIDSize)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := session.Expires
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(WebAuthnSessionExpires)
+	}
+
+	err = a.WebAuthnRepo.CreateSession(ctx, &models.WebAuthnSession{
+		ID:        HashTokenSHA256(sessionID),
+		Purpose:   purpose,
+		UserID:    userID,
+		Data:      data,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.SetCookie(WebAuthnSessionCookieName, sessionID, int(time.Until(expiresAt).Seconds()), "/", a.Domain, false, true)
+	return nil
+}
+
+// Данные церемонии вместе с разобранным webauthn.SessionData
+type takenWebAuthnSession struct {
+	*models.WebAuthnSession
+	data *webauthn.SessionData
+}
+
+// Забирает данные церемонии по cookie. Данные удаляются из БД, повторно завершить церемонию нельзя
+func (a *ImplApp) takeWebAuthnSession(ctx *gin.Context, purpose string) (*takenWebAuthnSession, error) {
+	sessionID, err := ctx.Cookie(WebAuthnSessionCookieName)
+	if err != nil {
+		return nil, err
+	}
+	ctx.SetCookie(WebAuthnSessionCookieName, "", -1, "/", a.Domain, false, true)
+
+	session, err := a.WebAuthnRepo.TakeSession(ctx, HashTokenSHA256(sessionID), purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	data := webauthn.SessionData{}
+	err = json.Unmarshal(session.Data, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Challenge == "" {
+		return nil, errors.New("empty webauthn challenge")
+	}
+
+	return &takenWebAuthnSession{
+		WebAuthnSession: session,
+		data:            &data,
+	}, nil
+}
+
+// Преобразует сохраненные учетные данные в вид go-webauthn
+func toWebAuthnCredential(record models.WebAuthnCredential) webauthn.Credential {
+	transports := []protocol.AuthenticatorTransport{}
+	for _, transport := range splitTransports(record.Transports) {
+		transports = append(transports, protocol.AuthenticatorTransport(transport))
+	}
+
+	return webauthn.Credential{
+		ID:              record.CredentialID,
+		PublicKey:       record.PublicKey,
+		AttestationType: record.AttestationType,
+		Transport:       transports,
+		Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(record.Flags)),
+		Authenticator: webauthn.Authenticator{
+			AAGUID:     record.AAGUID,
+			SignCount:  record.SignCount,
+			Attachment: protocol.AuthenticatorAttachment(record.Attachment),
+		},
+	}
+}
+
+// Преобразует учетные данные go-webauthn в модель для сохранения
+func fromWebAuthnCredential(userID uuid.UUID, credential *webauthn.Credential) *models.WebAuthnCredential {
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	return &models.WebAuthnCredential{
+		ID:              uuid.New(),
+		UserID:          userID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      strings.Join(transports, ","),
+		Flags:           uint8(credential.Flags.ProtocolValue()),
+		Attachment:      string(credential.Authenticator.Attachment),
+	}
+}
+
+func splitTransports(transports string) []string {
+	if transports == "" {
+		return []string{}
+	}
+	return strings.Split(transports, ",")
+}
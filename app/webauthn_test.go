@@ -0,0 +1,309 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost"
+)
+
+// Программный аутентификатор: один ключ P-256, аттестация "none". Счетчик подписей задается при каждом входе
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	_, _ = rand.Read(credentialID)
+	return &softAuthenticator{t: t, key: key, credentialID: credentialID}
+}
+
+// Отвечает на параметры navigator.credentials.create(), как это сделал бы браузер
+func (s *softAuthenticator) create(options protocol.PublicKeyCredentialCreationOptions) []byte {
+	s.t.Helper()
+	userHandle, err := base64.RawURLEncoding.DecodeString(options.User.ID.(string))
+	if err != nil {
+		s.t.Fatalf("failed to decode user handle: %v", err)
+	}
+	s.userHandle = userHandle
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: s.key.X.FillBytes(make([]byte, 32)),
+		YCoord: s.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		s.t.Fatalf("failed to encode public key: %v", err)
+	}
+
+	authData := s.authData(byte(protocol.FlagUserPresent|protocol.FlagUserVerified|protocol.FlagAttestedCredentialData), 0)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(s.credentialID)))
+	authData = append(authData, s.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		s.t.Fatalf("failed to encode attestation object: %v", err)
+	}
+
+	return s.marshal(map[string]any{
+		"clientDataJSON":    s.clientData("webauthn.create", options.Challenge),
+		"attestationObject": encode(attestationObject),
+		"transports":        []string{"internal"},
+	})
+}
+
+// Отвечает на параметры navigator.credentials.get() с указанным значением счетчика подписей
+func (s *softAuthenticator) get(options protocol.PublicKeyCredentialRequestOptions, signCount uint32) []byte {
+	s.t.Helper()
+	clientData := s.clientData("webauthn.get", options.Challenge)
+	rawClientData, _ := base64.RawURLEncoding.DecodeString(clientData)
+	authData := s.authData(byte(protocol.FlagUserPresent|protocol.FlagUserVerified), signCount)
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	digest := sha256.Sum256(append(slices.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+	if err != nil {
+		s.t.Fatalf("failed to sign assertion: %v", err)
+	}
+
+	return s.marshal(map[string]any{
+		"clientDataJSON":    clientData,
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(s.userHandle),
+	})
+}
+
+func (s *softAuthenticator) authData(flags byte, signCount uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	authData := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(authData, signCount)
+}
+
+func (s *softAuthenticator) clientData(ceremony string, challenge protocol.URLEncodedBase64) string {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge.String(),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		s.t.Fatalf("failed to encode client data: %v", err)
+	}
+	return encode(data)
+}
+
+func (s *softAuthenticator) marshal(response map[string]any) []byte {
+	s.t.Helper()
+	data, err := json.Marshal(map[string]any{
+		"id":                      encode(s.credentialID),
+		"rawId":                   encode(s.credentialID),
+		"type":                    "public-key",
+		"authenticatorAttachment": "platform",
+		"response":                response,
+	})
+	if err != nil {
+		s.t.Fatalf("failed to encode credential: %v", err)
+	}
+	return data
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newWebAuthnTestApp(t *testing.T) (*ImplApp, *models.User) {
+	t.Helper()
+	user := models.NewUser("user@example.com", "password hash")
+	a := newTestApp(t, user)
+
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: "test",
+		RPOrigins:     []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatalf("failed to build relying party: %v", err)
+	}
+	a.WebAuthn = relyingParty
+	return a, user
+}
+
+// Регистрирует passkey программного аутентификатора через обработчики /webauthn/register/begin и /finish
+func registerSoftAuthenticator(t *testing.T, a *ImplApp, user *models.User) *softAuthenticator {
+	t.Helper()
+
+	ctx, recorder := newTestContext(httptest.NewRequest(http.MethodPost, "/webauthn/register/begin", nil), user.UserID.String())
+	a.BeginWebAuthnRegistrationHandler(ctx)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("begin registration: status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+	creation := protocol.CredentialCreation{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &creation)
+	if err != nil {
+		t.Fatalf("failed to decode creation options: %v", err)
+	}
+
+	authenticator := newSoftAuthenticator(t)
+	request := httptest.NewRequest(http.MethodPost, "/webauthn/register/finish", bytes.NewReader(authenticator.create(creation.Response)))
+	for _, cookie := range recorder.Result().Cookies() {
+		request.AddCookie(cookie)
+	}
+	ctx, recorder = newTestContext(request, user.UserID.String())
+	a.FinishWebAuthnRegistrationHandler(ctx)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("finish registration: status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+	return authenticator
+}
+
+// Проходит церемонию входа с сохраненными учетными данными пользователя так же, как обработчики входа по passkey
+func loginSoftAuthenticator(t *testing.T, a *ImplApp, user *models.User, authenticator *softAuthenticator, signCount uint32) bool {
+	t.Helper()
+
+	ctx, _ := newTestContext(httptest.NewRequest(http.MethodPost, "/login/mfa/webauthn/finish", nil), "")
+	waUser, err := a.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to load credentials: %v", err)
+	}
+	assertion, session, err := a.WebAuthn.BeginLogin(waUser)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/login/mfa/webauthn/finish", bytes.NewReader(authenticator.get(assertion.Response, signCount)))
+	credential, err := a.WebAuthn.FinishLogin(waUser, *session, ctx.Request)
+	if err != nil {
+		t.Fatalf("finish login: %v", err)
+	}
+	return a.updateWebAuthnCredentialUsage(ctx, waUser, credential)
+}
+
+func TestWebAuthnRegistrationAndLoginRoundTrip(t *testing.T) {
+	a, user := newWebAuthnTestApp(t)
+	authenticator := registerSoftAuthenticator(t, a, user)
+
+	credentials, _ := a.WebAuthnRepo.FindCredentialsByUserID(t.Context(), user.UserID)
+	if len(credentials) != 1 {
+		t.Fatalf("expected 1 stored credential, got %d", len(credentials))
+	}
+	if !bytes.Equal(credentials[0].CredentialID, authenticator.credentialID) {
+		t.Fatalf("stored credential id does not match the authenticator")
+	}
+	if got := splitTransports(credentials[0].Transports); !slices.Equal(got, []string{"internal"}) {
+		t.Fatalf("unexpected transports %v", got)
+	}
+	if got := a.OutboxRepo.(*fakeOutboxRepo).templates(); !slices.Equal(got, []string{MailTemplatePasskeyAdded}) {
+		t.Fatalf("unexpected mails %v", got)
+	}
+
+	if !loginSoftAuthenticator(t, a, user, authenticator, 1) {
+		t.Fatalf("login with a fresh sign count was rejected")
+	}
+	if !loginSoftAuthenticator(t, a, user, authenticator, 2) {
+		t.Fatalf("login with an increased sign count was rejected")
+	}
+
+	credentials, _ = a.WebAuthnRepo.FindCredentialsByUserID(t.Context(), user.UserID)
+	if credentials[0].SignCount != 2 {
+		t.Fatalf("expected stored sign count 2, got %d", credentials[0].SignCount)
+	}
+	if credentials[0].LastUsedAt == nil {
+		t.Fatalf("expected last used time to be set")
+	}
+}
+
+func TestWebAuthnSignCountRegressionRejected(t *testing.T) {
+	a, user := newWebAuthnTestApp(t)
+	authenticator := registerSoftAuthenticator(t, a, user)
+
+	if !loginSoftAuthenticator(t, a, user, authenticator, 5) {
+		t.Fatalf("login with a fresh sign count was rejected")
+	}
+
+	for _, signCount := range []uint32{5, 3} {
+		if loginSoftAuthenticator(t, a, user, authenticator, signCount) {
+			t.Fatalf("login with sign count %d after 5 was accepted", signCount)
+		}
+	}
+
+	credentials, _ := a.WebAuthnRepo.FindCredentialsByUserID(t.Context(), user.UserID)
+	if credentials[0].SignCount != 5 {
+		t.Fatalf("expected stored sign count to stay 5, got %d", credentials[0].SignCount)
+	}
+}
+
+func TestDeleteWebAuthnCredentialSendsMail(t *testing.T) {
+	a, user := newWebAuthnTestApp(t)
+	registerSoftAuthenticator(t, a, user)
+	credentials, _ := a.WebAuthnRepo.FindCredentialsByUserID(t.Context(), user.UserID)
+
+	ctx, recorder := newTestContext(httptest.NewRequest(http.MethodDelete, "/webauthn/credentials/"+credentials[0].ID.String(), nil), user.UserID.String())
+	ctx.AddParam("id", credentials[0].ID.String())
+	a.DeleteWebAuthnCredentialHandler(ctx)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("delete: status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+
+	credentials, _ = a.WebAuthnRepo.FindCredentialsByUserID(t.Context(), user.UserID)
+	if len(credentials) != 0 {
+		t.Fatalf("credential was not deleted")
+	}
+	want := []string{MailTemplatePasskeyAdded, MailTemplatePasskeyRemoved}
+	if got := a.OutboxRepo.(*fakeOutboxRepo).templates(); !slices.Equal(got, want) {
+		t.Fatalf("expected mails %v, got %v", want, got)
+	}
+}
+
+func TestDeleteUnknownWebAuthnCredentialSendsNoMail(t *testing.T) {
+	a, user := newWebAuthnTestApp(t)
+	registerSoftAuthenticator(t, a, user)
+	id := uuid.NewString()
+
+	ctx, recorder := newTestContext(httptest.NewRequest(http.MethodDelete, "/webauthn/credentials/"+id, nil), user.UserID.String())
+	ctx.AddParam("id", id)
+	a.DeleteWebAuthnCredentialHandler(ctx)
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", recorder.Code)
+	}
+	if got := a.OutboxRepo.(*fakeOutboxRepo).templates(); !slices.Equal(got, []string{MailTemplatePasskeyAdded}) {
+		t.Fatalf("expected no mail about removal, got %v", got)
+	}
+}
@@ -1,16 +1,34 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"strconv"
 
 	"github.com/AlexandrShapkin/auth-go-test-task/app"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/authenticator"
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/config"
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/db"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/encryption"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/federation"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/hasher"
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/jwt"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/lockout"
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/mailer"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/outbox"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/policy"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/ratelimit"
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -25,6 +43,428 @@ func mustLoadConfig() *config.Config {
 	return cfg
 }
 
+// Функция обязана собрать шифратор секретов, хранящихся в БД
+func mustBuildSecretEncryptor(mfaCfg config.MFA) encryption.Encryptor {
+	key, err := base64.StdEncoding.DecodeString(mfaCfg.EncryptionKey)
+	if err != nil {
+		slog.Error("Failed to decode encryption key", "error", err)
+		os.Exit(1)
+	}
+
+	encryptor, err := encryption.NewAESGCMEncryptor(key)
+	if err != nil {
+		slog.Error("Failed to build encryptor", "error", err)
+		os.Exit(1)
+	}
+	return encryptor
+}
+
+// Функция обязана собрать relying party для WebAuthn. По умолчанию используются домен и базовый URL приложения
+func mustBuildWebAuthn(webAuthnCfg config.WebAuthn, appCfg config.App) *webauthn.WebAuthn {
+	rpID := webAuthnCfg.RPID
+	if rpID == "" {
+		rpID = appCfg.Domain
+	}
+	rpOrigins := webAuthnCfg.RPOrigins
+	if len(rpOrigins) == 0 {
+		rpOrigins = []string{appCfg.BaseURL}
+	}
+
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: webAuthnCfg.RPDisplayName,
+		RPOrigins:     rpOrigins,
+	})
+	if err != nil {
+		slog.Error("Failed to build webauthn relying party", "error", err)
+		os.Exit(1)
+	}
+	return relyingParty
+}
+
+// Функция обязана собрать издателя ID токенов OpenID Connect. Без файла ключа генерирует временный ключ,
+// только если это явно разрешено (для разработки), иначе завершает работу
+func mustBuildIDTokenIssuer(oidcCfg config.OIDC, appCfg config.App) jwt.IDTokenIssuer {
+	issuer := oidcCfg.Issuer
+	if issuer == "" {
+		issuer = appCfg.BaseURL
+	}
+
+	var privateKey *rsa.PrivateKey
+	var err error
+	switch {
+	case oidcCfg.SigningKeyFile != "":
+		privateKey, err = jwt.LoadRSAPrivateKey(oidcCfg.SigningKeyFile)
+	case oidcCfg.AllowTemporaryKey:
+		slog.Warn("OIDC signing key file is not set, using a temporary key")
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		slog.Error("OIDC signing key file is not set, set oidc.signingkeyfile or oidc.allowtemporarykey for development")
+		os.Exit(1)
+	}
+	if err != nil {
+		slog.Error("Failed to load OIDC signing key", "error", err)
+		os.Exit(1)
+	}
+
+	return jwt.NewIDTokenIssuer(privateKey, issuer, jwt.IDExpires)
+}
+
+// Функция обязана собрать хешер паролей. Хеши обоих поддерживаемых алгоритмов остаются проверяемыми
+func mustBuildHasher(hasherCfg config.Hasher) hasher.Hasher {
+	bcryptHasher, err := hasher.NewBcryptHasher(hasherCfg.BcryptCost)
+	if err != nil {
+		slog.Error("Failed to build bcrypt hasher", "error", err)
+		os.Exit(1)
+	}
+	argon2idHasher := hasher.NewArgon2idHasher(
+		hasherCfg.Argon2.Time,
+		hasherCfg.Argon2.Memory,
+		hasherCfg.Argon2.Threads,
+		hasherCfg.Argon2.KeyLength,
+		hasherCfg.Argon2.SaltLength,
+	)
+
+	switch hasherCfg.Algorithm {
+	case "", "bcrypt":
+		return hasher.NewMultiHasher(bcryptHasher, argon2idHasher)
+	case "argon2id":
+		return hasher.NewMultiHasher(argon2idHasher, bcryptHasher)
+	default:
+		slog.Error("Unknown hashing algorithm", "algorithm", hasherCfg.Algorithm)
+		os.Exit(1)
+		return nil
+	}
+}
+
+// Функция обязана собрать перец из конфигурации и файла секретов (секреты из файла имеют приоритет)
+func mustBuildPepper(pepperCfg config.Pepper) hasher.Pepper {
+	keys := map[int][]byte{}
+	for versionString, secret := range pepperCfg.Keys {
+		version, err := strconv.Atoi(versionString)
+		if err != nil || version == hasher.NoPepper {
+			slog.Error("Invalid pepper version", "version", versionString)
+			os.Exit(1)
+		}
+		keys[version] = []byte(secret)
+	}
+
+	if pepperCfg.File != "" {
+		fileKeys, err := hasher.LoadPepperFile(pepperCfg.File)
+		if err != nil {
+			slog.Error("Failed to load pepper file", "error", err)
+			os.Exit(1)
+		}
+		maps.Copy(keys, fileKeys)
+	}
+
+	pepper, err := hasher.NewPepper(keys, pepperCfg.Current)
+	if err != nil {
+		slog.Error("Failed to build pepper", "error", err)
+		os.Exit(1)
+	}
+	return pepper
+}
+
+// Функция обязана собрать цепочку бэкендов проверки пароля. Каждое название в цепочке должно быть local
+// или названием настроенного LDAP бэкенда
+func mustBuildAuthenticator(
+	authCfg config.Auth,
+	userRepo repositories.UserRepo,
+	passwordHasher hasher.Hasher,
+	pepper hasher.Pepper,
+) authenticator.Authenticator {
+	chain := authCfg.Chain
+	if len(chain) == 0 {
+		chain = []string{models.AuthSourceLocal}
+	}
+
+	authenticators := []authenticator.Authenticator{}
+	for _, name := range chain {
+		if name == models.AuthSourceLocal {
+			authenticators = append(authenticators, authenticator.NewLocalAuthenticator(userRepo, passwordHasher, pepper))
+			continue
+		}
+
+		ldapCfg, ok := authCfg.LDAP[name]
+		if !ok {
+			slog.Error("Unknown authenticator in chain", "name", name)
+			os.Exit(1)
+		}
+
+		var rootCAs []byte
+		if ldapCfg.CAFile != "" {
+			var err error
+			rootCAs, err = os.ReadFile(ldapCfg.CAFile)
+			if err != nil {
+				slog.Error("Failed to read LDAP CA file", "name", name, "error", err)
+				os.Exit(1)
+			}
+		}
+
+		ldapAuthenticator, err := authenticator.NewLDAPAuthenticator(name, authenticator.LDAPOptions{
+			URL:            ldapCfg.URL,
+			BindDN:         ldapCfg.BindDN,
+			BindPassword:   ldapCfg.BindPassword,
+			BaseDN:         ldapCfg.BaseDN,
+			UserFilter:     ldapCfg.UserFilter,
+			EmailAttribute: ldapCfg.EmailAttribute,
+			StartTLS:       ldapCfg.StartTLS,
+			RootCAs:        rootCAs,
+			Timeout:        ldapCfg.Timeout,
+		}, userRepo)
+		if err != nil {
+			slog.Error("Failed to build LDAP authenticator", "name", name, "error", err)
+			os.Exit(1)
+		}
+		authenticators = append(authenticators, ldapAuthenticator)
+	}
+
+	return authenticator.NewChainAuthenticator(authenticators...)
+}
+
+// Функция обязана собрать внешние провайдеры личности из настроек.
+// Адрес обратного вызова по умолчанию - app.baseurl + /login/federated/<название>/callback
+func mustBuildFederationProviders(federationCfg config.Federation, appCfg config.App) map[string]federation.Provider {
+	providers := map[string]federation.Provider{}
+	for name, providerCfg := range federationCfg.Providers {
+		redirectURL := providerCfg.RedirectURL
+		if redirectURL == "" {
+			redirectURL = appCfg.BaseURL + "/login/federated/" + name + "/callback"
+		}
+
+		provider, err := federation.NewOIDCProvider(name, federation.OIDCProviderOptions{
+			DisplayName:    providerCfg.DisplayName,
+			Issuer:         providerCfg.Issuer,
+			ClientID:       providerCfg.ClientID,
+			ClientSecret:   providerCfg.ClientSecret,
+			RedirectURL:    redirectURL,
+			Scopes:         providerCfg.Scopes,
+			TrustEmail:     providerCfg.TrustEmail,
+			TrustedDomains: providerCfg.TrustedDomains,
+		})
+		if err != nil {
+			slog.Error("Failed to build identity provider", "name", name, "error", err)
+			os.Exit(1)
+		}
+		providers[name] = provider
+	}
+	return providers
+}
+
+// Функция обязана собрать провайдеры SAML из настроек. Названия не должны совпадать с провайдерами OpenID Connect,
+// так как личности пользователей хранятся по названию провайдера.
+// Адреса метаданных и ACS - app.baseurl + /login/saml/<название>/metadata и /acs
+func mustBuildSAMLProviders(
+	federationCfg config.Federation,
+	appCfg config.App,
+	federationProviders map[string]federation.Provider,
+) map[string]federation.SAMLProvider {
+	providers := map[string]federation.SAMLProvider{}
+	for name, providerCfg := range federationCfg.SAML {
+		if _, ok := federationProviders[name]; ok {
+			slog.Error("Identity provider name is used twice", "name", name)
+			os.Exit(1)
+		}
+
+		certData, err := os.ReadFile(providerCfg.IDPCertFile)
+		if err != nil {
+			slog.Error("Failed to read SAML IdP certificate file", "name", name, "error", err)
+			os.Exit(1)
+		}
+		idpCertificates, err := federation.ParseCertificatesPEM(certData)
+		if err != nil {
+			slog.Error("Failed to parse SAML IdP certificates", "name", name, "error", err)
+			os.Exit(1)
+		}
+
+		var key *rsa.PrivateKey
+		var certificate *x509.Certificate
+		if providerCfg.KeyFile != "" {
+			key, err = jwt.LoadRSAPrivateKey(providerCfg.KeyFile)
+			if err != nil {
+				slog.Error("Failed to load SAML SP key", "name", name, "error", err)
+				os.Exit(1)
+			}
+
+			certData, err := os.ReadFile(providerCfg.CertFile)
+			if err != nil {
+				slog.Error("Failed to read SAML SP certificate file", "name", name, "error", err)
+				os.Exit(1)
+			}
+			certificates, err := federation.ParseCertificatesPEM(certData)
+			if err != nil {
+				slog.Error("Failed to parse SAML SP certificate", "name", name, "error", err)
+				os.Exit(1)
+			}
+			certificate = certificates[0]
+		}
+
+		baseURL := appCfg.BaseURL + "/login/saml/" + name
+		provider, err := federation.NewSAMLProvider(name, federation.SAMLProviderOptions{
+			DisplayName:      providerCfg.DisplayName,
+			IDPEntityID:      providerCfg.IDPEntityID,
+			IDPSSOURL:        providerCfg.IDPSSOURL,
+			IDPCertificates:  idpCertificates,
+			EntityID:         providerCfg.EntityID,
+			MetadataURL:      baseURL + "/metadata",
+			ACSURL:           baseURL + "/acs",
+			Key:              key,
+			Certificate:      certificate,
+			EmailAttribute:   providerCfg.EmailAttribute,
+			SubjectAttribute: providerCfg.SubjectAttribute,
+			TrustEmail:       providerCfg.TrustEmail,
+			TrustedDomains:   providerCfg.TrustedDomains,
+		})
+		if err != nil {
+			slog.Error("Failed to build SAML provider", "name", name, "error", err)
+			os.Exit(1)
+		}
+		providers[name] = provider
+	}
+	return providers
+}
+
+// Функция обязана собрать отправку писем через SMTP транспорт. Без host используется smtp.gmail.com,
+// без username - адрес отправителя. Если указан DKIM ключ, письма подписываются перед отправкой,
+// а адрес отправителя должен быть в домене подписи
+func mustBuildMailer(mailCfg config.Mail) mailer.Mailer {
+	host := mailCfg.Host
+	if host == "" {
+		host = mailer.GmailSMTPHost
+	}
+	username := mailCfg.Username
+	if username == "" {
+		username = mailCfg.From
+	}
+
+	var rootCAs []byte
+	if mailCfg.CAFile != "" {
+		var err error
+		rootCAs, err = os.ReadFile(mailCfg.CAFile)
+		if err != nil {
+			slog.Error("Failed to read mail CA file", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	transport, err := mailer.NewSMTPTransport(mailer.SMTPOptions{
+		Host:     host,
+		Port:     mailCfg.Port,
+		Security: mailCfg.Security,
+		Auth:     mailCfg.Auth,
+		Username: username,
+		Password: mailCfg.Pass,
+		RootCAs:  rootCAs,
+		HeloName: mailCfg.HeloName,
+		Timeout:  mailCfg.Timeout,
+	})
+	if err != nil {
+		slog.Error("Failed to build mail transport", "error", err)
+		os.Exit(1)
+	}
+
+	if mailCfg.DKIM.KeyFile != "" {
+		keyPEM, err := os.ReadFile(mailCfg.DKIM.KeyFile)
+		if err != nil {
+			slog.Error("Failed to read DKIM key file", "error", err)
+			os.Exit(1)
+		}
+		key, err := mailer.ParseDKIMPrivateKey(keyPEM)
+		if err != nil {
+			slog.Error("Failed to parse DKIM key", "error", err)
+			os.Exit(1)
+		}
+		transport, err = mailer.NewDKIMTransport(transport, mailer.DKIMOptions{
+			Domain:     mailCfg.DKIM.Domain,
+			Selector:   mailCfg.DKIM.Selector,
+			PrivateKey: key,
+		})
+		if err != nil {
+			slog.Error("Failed to build DKIM transport", "error", err)
+			os.Exit(1)
+		}
+		// письма подписываются только если их отправитель в домене подписи, иначе они не уйдут
+		err = mailer.CheckDKIMDomain(mailCfg.DKIM.Domain, mailCfg.From)
+		if err != nil {
+			slog.Error("DKIM domain does not cover mail sender", "error", err)
+			os.Exit(1)
+		}
+	}
+	return mailer.NewMailer(mailCfg.From, transport)
+}
+
+// Функция обязана загрузить шаблоны писем. Ошибка в любом шаблоне каталога оператора не дает запустить сервис
+func mustBuildMailTemplates(mailCfg config.Mail) mailer.Templates {
+	templates, err := mailer.NewTemplates(mailCfg.TemplatesDir, mailCfg.DefaultLocale)
+	if err != nil {
+		slog.Error("Failed to load mail templates", "error", err)
+		os.Exit(1)
+	}
+	return templates
+}
+
+// Функция обязана собрать парольную политику. Если указан каталог утекших паролей, он должен существовать
+func mustBuildPasswordPolicy(passwordCfg config.Password) policy.PasswordPolicy {
+	var breached policy.BreachedCorpus
+	if passwordCfg.BreachedDir != "" {
+		corpus, err := policy.NewDirBreachedCorpus(passwordCfg.BreachedDir)
+		if err != nil {
+			slog.Error("Failed to load breached passwords corpus", "error", err)
+			os.Exit(1)
+		}
+		breached = corpus
+	}
+
+	return policy.NewPasswordPolicy(
+		passwordCfg.MinLength,
+		passwordCfg.MaxLength,
+		passwordCfg.MinStrength,
+		breached,
+	)
+}
+
+// Функция обязана собрать хранилище лимитов частоты запросов
+func mustBuildRateLimiter(rateLimitCfg config.RateLimit, database *gorm.DB) (ratelimit.Store, map[string]ratelimit.RouteLimits) {
+	var store ratelimit.Store
+	switch rateLimitCfg.Store {
+	case "", "memory":
+		store = ratelimit.NewMemoryStore()
+	case "postgres":
+		store = ratelimit.NewPostgresStore(database)
+	default:
+		slog.Error("Unknown rate limit store", "store", rateLimitCfg.Store)
+		os.Exit(1)
+	}
+
+	limits := map[string]ratelimit.RouteLimits{}
+	for route, routeCfg := range rateLimitCfg.Routes {
+		limits[route] = ratelimit.RouteLimits{
+			IP:      ratelimit.Limit{Rate: routeCfg.IP.Rate, Burst: routeCfg.IP.Burst},
+			Account: ratelimit.Limit{Rate: routeCfg.Account.Rate, Burst: routeCfg.Account.Burst},
+			Route:   ratelimit.Limit{Rate: routeCfg.Route.Rate, Burst: routeCfg.Route.Burst},
+		}
+	}
+
+	return store, limits
+}
+
+// Функция обязана собрать роутер gin. IP клиента берется из X-Forwarded-For только для запросов от app.trustedproxies
+func mustBuildRouter(appCfg config.App) *gin.Engine {
+	router := gin.Default()
+	// nil не доверяет ни одному прокси, в отличие от настройки gin по умолчанию, доверяющей всем
+	trustedProxies := appCfg.TrustedProxies
+	if len(trustedProxies) == 0 {
+		trustedProxies = nil
+	}
+	err := router.SetTrustedProxies(trustedProxies)
+	if err != nil {
+		slog.Error("Failed to set trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	return router
+}
+
 // Функция обязана совершить успешное подключение к базе данных, иначе продолжать работу программы нет смысла
 func mustConnectDB(dbCfg config.Database) *gorm.DB {
 	dsn := fmt.Sprintf(
@@ -54,20 +494,91 @@ func main() {
 		jwt.AccessExpires,
 		jwt.RefreshExpires,
 		jwt.ParseLeewayWindow,
+		jwt.MFAExpires,
 	)
 
+	idTokenIssuer := mustBuildIDTokenIssuer(cfg.OIDC, cfg.App)
+
 	database := mustConnectDB(cfg.Database)
 	userRepo := repositories.NewUserRepo(database)
+	loginFailureRepo := repositories.NewLoginFailureRepo(database)
+	recoveryCodeRepo := repositories.NewRecoveryCodeRepo(database)
+	webAuthnRepo := repositories.NewWebAuthnRepo(database)
+	emailLoginRepo := repositories.NewEmailLoginRepo(database)
+	identityRepo := repositories.NewIdentityRepo(database)
+	oauthClientRepo := repositories.NewOAuthClientRepo(database)
+	oauthTokenRepo := repositories.NewOAuthTokenRepo(database)
+	serviceAccountRepo := repositories.NewServiceAccountRepo(database)
+	outboxRepo := repositories.NewOutboxRepo(database)
+	transactor := repositories.NewTransactor(database)
 
-	mailer := mailer.NewMailer(cfg.Mail.From, cfg.Mail.Pass)
-
-	application := app.NewApp(
-		jwtManager,
-		userRepo,
-		mailer,
-		cfg.App.LoginRemoteIPMode,
-		cfg.App.RefreshRemoteIPMode,
-		cfg.App.Domain,
+	mailer := mustBuildMailer(cfg.Mail)
+	mailTemplates := mustBuildMailTemplates(cfg.Mail)
+	secretEncryptor := mustBuildSecretEncryptor(cfg.MFA)
+	outboxWorker := outbox.NewWorker(outboxRepo, mailer, secretEncryptor, outbox.Options{
+		Interval:      cfg.Outbox.Interval,
+		BatchSize:     cfg.Outbox.BatchSize,
+		MaxAttempts:   cfg.Outbox.MaxAttempts,
+		BaseDelay:     cfg.Outbox.BaseDelay,
+		MaxDelay:      cfg.Outbox.MaxDelay,
+		Lease:         cfg.Outbox.Lease,
+		Retention:     cfg.Outbox.Retention,
+		DeadRetention: cfg.Outbox.DeadRetention,
+	})
+	webAuthn := mustBuildWebAuthn(cfg.WebAuthn, cfg.App)
+	passwordHasher := mustBuildHasher(cfg.Hasher)
+	pepper := mustBuildPepper(cfg.Hasher.Pepper)
+	passwordAuthenticator := mustBuildAuthenticator(cfg.Auth, userRepo, passwordHasher, pepper)
+	federationProviders := mustBuildFederationProviders(cfg.Federation, cfg.App)
+	samlProviders := mustBuildSAMLProviders(cfg.Federation, cfg.App, federationProviders)
+	passwordPolicy := mustBuildPasswordPolicy(cfg.Password)
+	lockoutPolicy := lockout.NewLockoutPolicy(
+		lockout.Thresholds{Delay: cfg.Lockout.DelayThreshold, Lock: cfg.Lockout.LockThreshold},
+		lockout.Thresholds{Delay: cfg.Lockout.IPDelayThreshold, Lock: cfg.Lockout.IPLockThreshold},
+		cfg.Lockout.BaseDelay,
+		cfg.Lockout.MaxDelay,
+		cfg.Lockout.LockDuration,
+		cfg.Lockout.IPWindow,
 	)
+
+	rateLimiter, rateLimits := mustBuildRateLimiter(cfg.RateLimit, database)
+
+	application := app.NewApp(app.Dependencies{
+		JWTManager:          jwtManager,
+		IDTokenIssuer:       idTokenIssuer,
+		UserRepo:            userRepo,
+		LoginFailureRepo:    loginFailureRepo,
+		RecoveryCodeRepo:    recoveryCodeRepo,
+		WebAuthnRepo:        webAuthnRepo,
+		EmailLoginRepo:      emailLoginRepo,
+		IdentityRepo:        identityRepo,
+		OAuthClientRepo:     oauthClientRepo,
+		OAuthTokenRepo:      oauthTokenRepo,
+		ServiceAccountRepo:  serviceAccountRepo,
+		OutboxRepo:          outboxRepo,
+		Transactor:          transactor,
+		Mailer:              mailer,
+		MailTemplates:       mailTemplates,
+		OutboxWorker:        outboxWorker,
+		SecretEncryptor:     secretEncryptor,
+		WebAuthn:            webAuthn,
+		PasswordHasher:      passwordHasher,
+		Pepper:              pepper,
+		Authenticator:       passwordAuthenticator,
+		FederationProviders: federationProviders,
+		SAMLProviders:       samlProviders,
+		PasswordPolicy:      passwordPolicy,
+		LockoutPolicy:       lockoutPolicy,
+		RateLimiter:         rateLimiter,
+		RateLimits:          rateLimits,
+		Router:              mustBuildRouter(cfg.App),
+		LoginRemoteIPMode:   cfg.App.LoginRemoteIPMode,
+		RefreshRemoteIPMode: cfg.App.RefreshRemoteIPMode,
+		Domain:              cfg.App.Domain,
+		TOTPIssuer:          cfg.MFA.Issuer,
+		BaseURL:             cfg.App.BaseURL,
+	})
+	go outboxWorker.Run(context.Background())
+	go ratelimit.RunCleanup(context.Background(), rateLimiter, ratelimit.DefaultCleanupInterval)
 	application.Run(cfg.App.Addr)
 }
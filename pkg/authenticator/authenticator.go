@@ -0,0 +1,58 @@
+package authenticator
+
+import (
+	"context"
+	"errors"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+)
+
+// Бэкенд проверки логина и пароля
+type Authenticator interface {
+	// Возвращает название бэкенда. Пользователи, созданные бэкендом, хранят его в models.User.AuthSource
+	Name() string
+	// Проверяет логин и пароль и возвращает пользователя.
+	//
+	// ErrUserNotFound означает, что бэкенд не знает такого пользователя и можно попробовать следующий.
+	// При ErrInvalidCredentials вместе с ошибкой возвращается пользователь, если он уже есть в БД,
+	// чтобы неудачную попытку можно было учесть для его аккаунта
+	Authenticate(ctx context.Context, login string, password string) (*models.User, error)
+}
+
+// Цепочка бэкендов, которые проверяются по порядку до первого, знающего пользователя
+type ChainAuthenticator struct {
+	Authenticators []Authenticator
+}
+
+// Конструктор цепочки бэкендов
+func NewChainAuthenticator(authenticators ...Authenticator) Authenticator {
+	return &ChainAuthenticator{
+		Authenticators: authenticators,
+	}
+}
+
+func (c *ChainAuthenticator) Name() string {
+	return "chain"
+}
+
+// Недоступность одного бэкенда не мешает проверке следующих, но если ни один не узнал пользователя,
+// возвращается ErrBackendUnavailable, т.к. пользователь мог быть в недоступном бэкенде
+func (c *ChainAuthenticator) Authenticate(ctx context.Context, login string, password string) (*models.User, error) {
+	unavailable := false
+	for _, authenticator := range c.Authenticators {
+		user, err := authenticator.Authenticate(ctx, login, password)
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		}
+		if errors.Is(err, ErrBackendUnavailable) {
+			unavailable = true
+			continue
+		}
+		return user, err
+	}
+
+	if unavailable {
+		return nil, ErrBackendUnavailable
+	}
+	return nil, ErrUserNotFound
+}
@@ -0,0 +1,11 @@
+package authenticator
+
+import "errors"
+
+var (
+	ErrInvalidCredentials   = errors.New("invalid credentials")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrBackendUnavailable   = errors.New("authentication backend is unavailable")
+	ErrUnknownAuthenticator = errors.New("unknown authenticator")
+	ErrMissingEmail         = errors.New("ldap entry has no email attribute")
+)
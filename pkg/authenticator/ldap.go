@@ -0,0 +1,228 @@
+package authenticator
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/repositories"
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	// Фильтр поиска пользователя по умолчанию. {login} заменяется экранированным логином
+	DefaultLDAPUserFilter = "(&(objectClass=person)(|(uid={login})(mail={login})))"
+	// Атрибут с email пользователя по умолчанию
+	DefaultLDAPEmailAttribute = "mail"
+)
+
+var (
+	// Таймаут подключения и операций LDAP по умолчанию
+	DefaultLDAPTimeout = 5 * time.Second
+)
+
+// Параметры подключения к LDAP серверу
+type LDAPOptions struct {
+	// Адрес сервера: ldap://host:389 или ldaps://host:636
+	URL string
+	// DN сервисной учетной записи для поиска пользователей. Пустой - анонимный поиск
+	BindDN string
+	// Пароль сервисной учетной записи
+	BindPassword string
+	// DN, внутри которого ищутся пользователи
+	BaseDN string
+	// Фильтр поиска пользователя, {login} заменяется экранированным логином
+	UserFilter string
+	// Атрибут с email пользователя, по нему пользователь связывается с models.User
+	EmailAttribute string
+	// Выполнять StartTLS после подключения по ldap://
+	StartTLS bool
+	// PEM сертификаты доверенных CA. Пустой - системные
+	RootCAs []byte
+	// Таймаут подключения и операций
+	Timeout time.Duration
+}
+
+// Бэкенд, проверяющий пароль bind'ом в LDAP/Active Directory от имени найденного пользователя.
+// Пользователь, впервые вошедший через LDAP, создается в БД с AuthSource равным названию бэкенда
+type LDAPAuthenticator struct {
+	BackendName string
+	Options     LDAPOptions
+	UserRepo    repositories.UserRepo
+	TLSConfig   *tls.Config
+}
+
+// Конструктор LDAP бэкенда. Нулевые значения фильтра, атрибута email и таймаута заменяются значениями по умолчанию
+func NewLDAPAuthenticator(name string, options LDAPOptions, userRepo repositories.UserRepo) (Authenticator, error) {
+	if options.UserFilter == "" {
+		options.UserFilter = DefaultLDAPUserFilter
+	}
+	if options.EmailAttribute == "" {
+		options.EmailAttribute = DefaultLDAPEmailAttribute
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultLDAPTimeout
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(options.RootCAs) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(options.RootCAs) {
+			return nil, fmt.Errorf("ldap %s: no certificates found in root CAs", name)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &LDAPAuthenticator{
+		BackendName: name,
+		Options:     options,
+		UserRepo:    userRepo,
+		TLSConfig:   tlsConfig,
+	}, nil
+}
+
+func (a *LDAPAuthenticator) Name() string {
+	return a.BackendName
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, login string, password string) (*models.User, error) {
+	// bind с пустым паролем является анонимным и завершается успешно на многих серверах
+	if login == "" || password == "" {
+		return nil, ErrUserNotFound
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	defer conn.Close()
+
+	entry, err := a.findEntry(conn, login)
+	if err != nil {
+		return nil, err
+	}
+
+	email := entry.GetAttributeValue(a.Options.EmailAttribute)
+	if email == "" {
+		slog.Warn("LDAP entry has no email attribute", "backend", a.BackendName, "dn", entry.DN)
+		return nil, ErrMissingEmail
+	}
+
+	user, err := a.UserRepo.FindByEmail(ctx, email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	if err == nil && user.AuthSource != a.BackendName {
+		// email уже занят пользователем другого бэкенда, LDAP не должен получать доступ к его аккаунту
+		slog.Warn("LDAP user email belongs to another backend", "backend", a.BackendName, "source", user.AuthSource)
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		user = nil
+	}
+
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return user, ErrInvalidCredentials
+	}
+	if err != nil {
+		return user, fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+
+	if user != nil {
+		return user, nil
+	}
+	return a.provisionUser(ctx, email)
+}
+
+// Подключается к серверу и выполняет bind сервисной учетной записью
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(
+		a.Options.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.Options.Timeout}),
+		ldap.DialWithTLSConfig(a.TLSConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.Options.Timeout)
+
+	if a.Options.StartTLS {
+		err = conn.StartTLS(a.TLSConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if a.Options.BindDN != "" {
+		err = conn.Bind(a.Options.BindDN, a.Options.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Находит единственную запись пользователя по логину
+func (a *LDAPAuthenticator) findEntry(conn *ldap.Conn, login string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(a.Options.UserFilter, "{login}", ldap.EscapeFilter(login))
+	request := ldap.NewSearchRequest(
+		a.Options.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(a.Options.Timeout.Seconds()),
+		false,
+		filter,
+		[]string{"dn", a.Options.EmailAttribute},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	// неоднозначный логин не должен приводить ко входу в произвольный аккаунт
+	if len(result.Entries) != 1 {
+		return nil, ErrUserNotFound
+	}
+	return result.Entries[0], nil
+}
+
+// Создает пользователя при первом входе. Пароль не хранится, его проверяет LDAP
+func (a *LDAPAuthenticator) provisionUser(ctx context.Context, email string) (*models.User, error) {
+	user := models.NewUser(email, "")
+	user.AuthSource = a.BackendName
+	// адрес получен из каталога, а не введен пользователем
+	user.EmailVerified = true
+
+	err := a.UserRepo.Create(ctx, user)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// пользователь мог быть создан параллельным входом
+		user, err = a.UserRepo.FindByEmail(ctx, email)
+		if err == nil && user.AuthSource != a.BackendName {
+			return nil, ErrUserNotFound
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+
+	slog.Info("Provisioned user from LDAP", "backend", a.BackendName, "user_id", user.UserID.String())
+	return user, nil
+}
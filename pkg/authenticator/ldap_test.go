@@ -0,0 +1,306 @@
+package authenticator
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/repositories"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+// Запись каталога тестового LDAP сервера
+type testLDAPEntry struct {
+	DN       string
+	UID      string
+	Mail     string
+	Password string
+}
+
+// LDAP сервер в памяти. Поддерживает только bind, поиск и unbind, которых достаточно для LDAPAuthenticator.
+// Запись подходит под фильтр, если он содержит условие (uid=...) или (mail=...) с ее экранированным значением.
+// Запросы сохраняются, чтобы тесты могли проверить отправленные фильтры и bind'ы
+type testLDAPServer struct {
+	listener net.Listener
+	entries  []testLDAPEntry
+
+	mu      sync.Mutex
+	filters []string
+	binds   []string
+}
+
+func newTestLDAPServer(t *testing.T, entries ...testLDAPEntry) *testLDAPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &testLDAPServer{listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *testLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			writeLDAPMessage(conn, messageID, ldapResult(ldap.ApplicationBindResponse, s.bind(dn, password)))
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				writeLDAPMessage(conn, messageID, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultFilterError))
+				continue
+			}
+			for _, entry := range s.search(filter) {
+				writeLDAPMessage(conn, messageID, ldapEntry(entry))
+			}
+			writeLDAPMessage(conn, messageID, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		default:
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) bind(dn string, password string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// анонимный bind сервисной учетной записи
+	if dn == "" && password == "" {
+		return ldap.LDAPResultSuccess
+	}
+	s.binds = append(s.binds, dn)
+	for _, entry := range s.entries {
+		if entry.DN == dn && entry.Password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+func (s *testLDAPServer) search(filter string) []testLDAPEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters = append(s.filters, filter)
+	// uid и mail в каталогах сравниваются без учета регистра
+	filter = strings.ToLower(filter)
+	found := []testLDAPEntry{}
+	for _, entry := range s.entries {
+		if strings.Contains(filter, strings.ToLower("(uid="+ldap.EscapeFilter(entry.UID)+")")) ||
+			strings.Contains(filter, strings.ToLower("(mail="+ldap.EscapeFilter(entry.Mail)+")")) {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+// Возвращает DN, для которых выполнялся bind пользователя
+func (s *testLDAPServer) userBinds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.binds...)
+}
+
+func (s *testLDAPServer) lastFilter() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.filters) == 0 {
+		return ""
+	}
+	return s.filters[len(s.filters)-1]
+}
+
+func writeLDAPMessage(conn net.Conn, messageID int64, op *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	envelope.AppendChild(op)
+	_, _ = conn.Write(envelope.Bytes())
+}
+
+func ldapResult(tag ber.Tag, code int64) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return result
+}
+
+func ldapEntry(entry testLDAPEntry) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+	attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "mail", "Type"))
+	values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+	values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.Mail, "Value"))
+	attribute.AppendChild(values)
+	attributes.AppendChild(attribute)
+	result.AppendChild(attributes)
+	return result
+}
+
+// Репозиторий пользователей в памяти
+type testUserRepo struct {
+	repositories.UserRepo
+
+	mu    sync.Mutex
+	users []*models.User
+}
+
+func (r *testUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.Email == models.NormalizeEmail(email) {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *testUserRepo) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.users {
+		if existing.Email == user.Email {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	r.users = append(r.users, user)
+	return nil
+}
+
+func newTestLDAPAuthenticator(t *testing.T, server *testLDAPServer, users ...*models.User) Authenticator {
+	t.Helper()
+	authenticator, err := NewLDAPAuthenticator("corp", LDAPOptions{
+		URL:    server.URL(),
+		BaseDN: "dc=example,dc=com",
+	}, &testUserRepo{users: users})
+	if err != nil {
+		t.Fatalf("failed to build authenticator: %v", err)
+	}
+	return authenticator
+}
+
+var testAlice = testLDAPEntry{
+	DN:       "uid=alice,dc=example,dc=com",
+	UID:      "alice",
+	Mail:     "Alice@Example.com",
+	Password: "alice password",
+}
+
+func TestLDAPAuthenticateProvisionsUser(t *testing.T) {
+	server := newTestLDAPServer(t, testAlice)
+	authenticator := newTestLDAPAuthenticator(t, server)
+
+	user, err := authenticator.Authenticate(t.Context(), "alice", "alice password")
+	if err != nil {
+		t.Fatalf("expected successful login, got %v", err)
+	}
+	if user.Email != "alice@example.com" || user.AuthSource != "corp" || !user.EmailVerified {
+		t.Fatalf("unexpected provisioned user %+v", user)
+	}
+
+	again, err := authenticator.Authenticate(t.Context(), "alice@example.com", "alice password")
+	if err != nil {
+		t.Fatalf("expected successful login by email, got %v", err)
+	}
+	if again.UserID != user.UserID {
+		t.Fatalf("second login provisioned another user")
+	}
+}
+
+func TestLDAPAuthenticateWrongPassword(t *testing.T) {
+	server := newTestLDAPServer(t, testAlice)
+	authenticator := newTestLDAPAuthenticator(t, server)
+
+	_, err := authenticator.Authenticate(t.Context(), "alice", "wrong password")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if binds := server.userBinds(); len(binds) != 1 || binds[0] != testAlice.DN {
+		t.Fatalf("expected a single bind as alice, got %v", binds)
+	}
+}
+
+func TestLDAPAuthenticateEscapesFilter(t *testing.T) {
+	server := newTestLDAPServer(t, testAlice)
+	authenticator := newTestLDAPAuthenticator(t, server)
+
+	_, err := authenticator.Authenticate(t.Context(), "*)(uid=*", "alice password")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	want := `(&(objectClass=person)(|(uid=\2a\29\28uid=\2a)(mail=\2a\29\28uid=\2a)))`
+	if got := server.lastFilter(); got != want {
+		t.Fatalf("expected filter %s, got %s", want, got)
+	}
+	if binds := server.userBinds(); len(binds) != 0 {
+		t.Fatalf("expected no user bind, got %v", binds)
+	}
+}
+
+func TestLDAPAuthenticateAmbiguousLogin(t *testing.T) {
+	bob := testLDAPEntry{
+		DN:       "uid=bob,dc=example,dc=com",
+		UID:      "bob",
+		Mail:     "alice",
+		Password: "bob password",
+	}
+	server := newTestLDAPServer(t, testAlice, bob)
+	authenticator := newTestLDAPAuthenticator(t, server)
+
+	// uid одной записи совпадает с mail другой
+	_, err := authenticator.Authenticate(t.Context(), "alice", "bob password")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+	if binds := server.userBinds(); len(binds) != 0 {
+		t.Fatalf("expected no user bind, got %v", binds)
+	}
+}
+
+func TestLDAPAuthenticateRefusesEmailOfAnotherSource(t *testing.T) {
+	server := newTestLDAPServer(t, testAlice)
+	local := models.NewUser("alice@example.com", "password hash")
+	authenticator := newTestLDAPAuthenticator(t, server, local)
+
+	user, err := authenticator.Authenticate(t.Context(), "alice", "alice password")
+	if !errors.Is(err, ErrUserNotFound) || user != nil {
+		t.Fatalf("expected ErrUserNotFound without a user, got %v, %v", user, err)
+	}
+	if binds := server.userBinds(); len(binds) != 0 {
+		t.Fatalf("expected no user bind, got %v", binds)
+	}
+}
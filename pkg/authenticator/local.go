@@ -0,0 +1,53 @@
+package authenticator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/hasher"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/repositories"
+	"gorm.io/gorm"
+)
+
+// Бэкенд, проверяющий пароль по хешу из БД. Логином является email
+type LocalAuthenticator struct {
+	UserRepo       repositories.UserRepo
+	PasswordHasher hasher.Hasher
+	Pepper         hasher.Pepper
+}
+
+// Конструктор локального бэкенда
+func NewLocalAuthenticator(userRepo repositories.UserRepo, passwordHasher hasher.Hasher, pepper hasher.Pepper) Authenticator {
+	return &LocalAuthenticator{
+		UserRepo:       userRepo,
+		PasswordHasher: passwordHasher,
+		Pepper:         pepper,
+	}
+}
+
+func (a *LocalAuthenticator) Name() string {
+	return models.AuthSourceLocal
+}
+
+// Пользователи внешних бэкендов для локального бэкенда не существуют
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, login string, password string) (*models.User, error) {
+	user, err := a.UserRepo.FindByEmail(ctx, login)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+
+	if user.AuthSource != models.AuthSourceLocal {
+		return nil, ErrUserNotFound
+	}
+
+	ok, err := hasher.CompareWithPepper(a.PasswordHasher, a.Pepper, user.Password, user.PepperVersion, password)
+	if err != nil || !ok {
+		return user, ErrInvalidCredentials
+	}
+	return user, nil
+}
@@ -1,10 +1,22 @@
 package config
 
+import "time"
+
 type Config struct {
-	App      App      `mapstructure:"app"`
-	Mail     Mail     `mapstructure:"mail"`
-	JWT      JWT      `mapstracture:"jwt"`
-	Database Database `mapstracture:"database"`
+	App        App        `mapstructure:"app"`
+	Mail       Mail       `mapstructure:"mail"`
+	Outbox     Outbox     `mapstructure:"outbox"`
+	Password   Password   `mapstructure:"password"`
+	Hasher     Hasher     `mapstructure:"hasher"`
+	Auth       Auth       `mapstructure:"auth"`
+	Lockout    Lockout    `mapstructure:"lockout"`
+	MFA        MFA        `mapstructure:"mfa"`
+	WebAuthn   WebAuthn   `mapstructure:"webauthn"`
+	OIDC       OIDC       `mapstructure:"oidc"`
+	Federation Federation `mapstructure:"federation"`
+	RateLimit  RateLimit  `mapstructure:"ratelimit"`
+	JWT        JWT        `mapstracture:"jwt"`
+	Database   Database   `mapstracture:"database"`
 }
 
 type App struct {
@@ -12,11 +24,162 @@ type App struct {
 	LoginRemoteIPMode   bool   `mapstructure:"lremoteipmode"`
 	RefreshRemoteIPMode bool   `mapstructure:"rremoteipmode"`
 	Domain              string `mapstructure:"domain"`
+	BaseURL             string `mapstructure:"baseurl"`
+	// Адреса и подсети прокси, которым доверяется заголовок X-Forwarded-For. Пустой - не доверять никому
+	TrustedProxies []string `mapstructure:"trustedproxies"`
 }
 
 type Mail struct {
-	From string `mapstructure:"from"`
-	Pass string `mapstructure:"pass"`
+	From     string        `mapstructure:"from"`
+	Pass     string        `mapstructure:"pass"`
+	Host     string        `mapstructure:"host"`
+	Port     int           `mapstructure:"port"`
+	Security string        `mapstructure:"security"`
+	Auth     string        `mapstructure:"auth"`
+	Username string        `mapstructure:"username"`
+	CAFile   string        `mapstructure:"cafile"`
+	HeloName string        `mapstructure:"heloname"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	// Каталог шаблонов писем, заменяющих встроенные (см. mailer.NewTemplates)
+	TemplatesDir  string `mapstructure:"templatesdir"`
+	DefaultLocale string `mapstructure:"defaultlocale"`
+	DKIM          DKIM   `mapstructure:"dkim"`
+}
+
+type DKIM struct {
+	Domain   string `mapstructure:"domain"`
+	Selector string `mapstructure:"selector"`
+	KeyFile  string `mapstructure:"keyfile"`
+}
+
+type Outbox struct {
+	Interval      time.Duration `mapstructure:"interval"`
+	BatchSize     int           `mapstructure:"batchsize"`
+	MaxAttempts   int           `mapstructure:"maxattempts"`
+	BaseDelay     time.Duration `mapstructure:"basedelay"`
+	MaxDelay      time.Duration `mapstructure:"maxdelay"`
+	Lease         time.Duration `mapstructure:"lease"`
+	Retention     time.Duration `mapstructure:"retention"`
+	DeadRetention time.Duration `mapstructure:"deadretention"`
+}
+
+type Password struct {
+	MinLength   int    `mapstructure:"minlength"`
+	MaxLength   int    `mapstructure:"maxlength"`
+	MinStrength int    `mapstructure:"minstrength"`
+	BreachedDir string `mapstructure:"breacheddir"`
+}
+
+type Auth struct {
+	Chain []string        `mapstructure:"chain"`
+	LDAP  map[string]LDAP `mapstructure:"ldap"`
+}
+
+type LDAP struct {
+	URL            string        `mapstructure:"url"`
+	BindDN         string        `mapstructure:"binddn"`
+	BindPassword   string        `mapstructure:"bindpassword"`
+	BaseDN         string        `mapstructure:"basedn"`
+	UserFilter     string        `mapstructure:"userfilter"`
+	EmailAttribute string        `mapstructure:"emailattribute"`
+	StartTLS       bool          `mapstructure:"starttls"`
+	CAFile         string        `mapstructure:"cafile"`
+	Timeout        time.Duration `mapstructure:"timeout"`
+}
+
+type Hasher struct {
+	Algorithm  string `mapstructure:"algorithm"`
+	BcryptCost int    `mapstructure:"bcryptcost"`
+	Argon2     Argon2 `mapstructure:"argon2"`
+	Pepper     Pepper `mapstructure:"pepper"`
+}
+
+type Pepper struct {
+	Current int               `mapstructure:"current"`
+	Keys    map[string]string `mapstructure:"keys"`
+	File    string            `mapstructure:"file"`
+}
+
+type Argon2 struct {
+	Time       uint32 `mapstructure:"time"`
+	Memory     uint32 `mapstructure:"memory"`
+	Threads    uint8  `mapstructure:"threads"`
+	KeyLength  uint32 `mapstructure:"keylength"`
+	SaltLength uint32 `mapstructure:"saltlength"`
+}
+
+type Lockout struct {
+	DelayThreshold   int           `mapstructure:"delaythreshold"`
+	LockThreshold    int           `mapstructure:"lockthreshold"`
+	IPDelayThreshold int           `mapstructure:"ipdelaythreshold"`
+	IPLockThreshold  int           `mapstructure:"iplockthreshold"`
+	BaseDelay        time.Duration `mapstructure:"basedelay"`
+	MaxDelay         time.Duration `mapstructure:"maxdelay"`
+	LockDuration     time.Duration `mapstructure:"lockduration"`
+	IPWindow         time.Duration `mapstructure:"ipwindow"`
+}
+
+type MFA struct {
+	Issuer        string `mapstructure:"issuer"`
+	EncryptionKey string `mapstructure:"encryptionkey"`
+}
+
+type WebAuthn struct {
+	RPID          string   `mapstructure:"rpid"`
+	RPDisplayName string   `mapstructure:"rpdisplayname"`
+	RPOrigins     []string `mapstructure:"rporigins"`
+}
+
+type OIDC struct {
+	Issuer            string `mapstructure:"issuer"`
+	SigningKeyFile    string `mapstructure:"signingkeyfile"`
+	AllowTemporaryKey bool   `mapstructure:"allowtemporarykey"`
+}
+
+type Federation struct {
+	Providers map[string]FederationProvider `mapstructure:"providers"`
+	SAML      map[string]SAMLProvider       `mapstructure:"saml"`
+}
+
+type FederationProvider struct {
+	DisplayName    string   `mapstructure:"displayname"`
+	Issuer         string   `mapstructure:"issuer"`
+	ClientID       string   `mapstructure:"clientid"`
+	ClientSecret   string   `mapstructure:"clientsecret"`
+	RedirectURL    string   `mapstructure:"redirecturl"`
+	Scopes         []string `mapstructure:"scopes"`
+	TrustEmail     bool     `mapstructure:"trustemail"`
+	TrustedDomains []string `mapstructure:"trusteddomains"`
+}
+
+type SAMLProvider struct {
+	DisplayName      string   `mapstructure:"displayname"`
+	IDPEntityID      string   `mapstructure:"idpentityid"`
+	IDPSSOURL        string   `mapstructure:"idpssourl"`
+	IDPCertFile      string   `mapstructure:"idpcertfile"`
+	EntityID         string   `mapstructure:"entityid"`
+	KeyFile          string   `mapstructure:"keyfile"`
+	CertFile         string   `mapstructure:"certfile"`
+	EmailAttribute   string   `mapstructure:"emailattribute"`
+	SubjectAttribute string   `mapstructure:"subjectattribute"`
+	TrustEmail       bool     `mapstructure:"trustemail"`
+	TrustedDomains   []string `mapstructure:"trusteddomains"`
+}
+
+type RateLimit struct {
+	Store  string                    `mapstructure:"store"`
+	Routes map[string]RouteRateLimit `mapstructure:"routes"`
+}
+
+type RouteRateLimit struct {
+	IP      Limit `mapstructure:"ip"`
+	Account Limit `mapstructure:"account"`
+	Route   Limit `mapstructure:"route"`
+}
+
+type Limit struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
 }
 
 type JWT struct {
@@ -6,13 +6,28 @@ import (
 )
 
 func ConnectDB(dialector *gorm.Dialector) (*gorm.DB, error) {
-	db, err := gorm.Open(*dialector, &gorm.Config{})
+	// TranslateError позволяет отлавливать нарушения уникальных индексов через gorm.ErrDuplicatedKey
+	db, err := gorm.Open(*dialector, &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
 
 	err = db.AutoMigrate(
 		&models.User{},
+		&models.LoginFailure{},
+		&models.RateLimitBucket{},
+		&models.RecoveryCode{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnSession{},
+		&models.EmailLogin{},
+		&models.Identity{},
+		&models.FederatedLoginSession{},
+		&models.OAuthClient{},
+		&models.ServiceAccount{},
+		&models.OAuthAuthorizationCode{},
+		&models.OAuthDeviceCode{},
+		&models.OAuthRefreshToken{},
+		&models.OutboxMessage{},
 	)
 
 	if err != nil {
@@ -20,4 +35,4 @@ func ConnectDB(dialector *gorm.Dialector) (*gorm.DB, error) {
 	}
 
 	return db, nil
-}
\ No newline at end of file
+}
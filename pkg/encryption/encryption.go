@@ -0,0 +1,65 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+)
+
+type AESGCMEncryptor struct {
+	AEAD cipher.AEAD
+}
+
+// Шифрование небольших секретов для хранения в БД (например TOTP секретов).
+//
+// associatedData не шифруется и не хранится, но проверяется при расшифровке. В нее передается идентификатор
+// записи-владельца секрета, чтобы шифротекст, скопированный в другую запись, не расшифровывался
+type Encryptor interface {
+	// Шифрует данные, результат - base64 строка, содержащая nonce и шифротекст
+	Encrypt(plaintext []byte, associatedData []byte) (string, error)
+	// Расшифровывает строку, полученную из Encrypt с той же associatedData
+	Decrypt(ciphertext string, associatedData []byte) ([]byte, error)
+}
+
+// Конструктор шифратора AES-GCM. Ключ должен иметь длину 16, 24 или 32 байта
+func NewAESGCMEncryptor(key []byte) (Encryptor, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AESGCMEncryptor{
+		AEAD: aead,
+	}, nil
+}
+
+func (e *AESGCMEncryptor) Encrypt(plaintext []byte, associatedData []byte) (string, error) {
+	nonce := make([]byte, e.AEAD.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := e.AEAD.Seal(nonce, nonce, plaintext, associatedData)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (e *AESGCMEncryptor) Decrypt(ciphertext string, associatedData []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := e.AEAD.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, ErrCiphertextTooShort
+	}
+
+	return e.AEAD.Open(nil, sealed[:nonceSize], sealed[nonceSize:], associatedData)
+}
@@ -0,0 +1,7 @@
+package encryption
+
+import "errors"
+
+var (
+	ErrCiphertextTooShort = errors.New("ciphertext is too short")
+)
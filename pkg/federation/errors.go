@@ -0,0 +1,14 @@
+package federation
+
+import "errors"
+
+var (
+	ErrProviderUnavailable = errors.New("identity provider is unavailable")
+	ErrInvalidIDToken      = errors.New("invalid id token")
+	ErrNonceMismatch       = errors.New("id token nonce mismatch")
+	ErrMissingIDToken      = errors.New("token response has no id token")
+	ErrCodeExchange        = errors.New("authorization code exchange failed")
+	ErrInvalidOptions      = errors.New("invalid identity provider options")
+	ErrInvalidAssertion    = errors.New("invalid saml assertion")
+	ErrNoCertificates      = errors.New("no certificates found in pem data")
+)
@@ -0,0 +1,190 @@
+package federation
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Scope, запрашиваемые у провайдера, если в настройках они не указаны
+var DefaultScopes = []string{oidc.ScopeOpenID, "email", "profile"}
+
+// Внешняя личность пользователя, подтвержденная провайдером
+type Identity struct {
+	// Название провайдера в настройках
+	Provider string
+	// Идентификатор пользователя у провайдера (sub), уникален в пределах провайдера
+	Subject string
+	// Email пользователя. Может быть пустым, если провайдер его не передал
+	Email string
+	// Подтвердил ли провайдер email пользователя
+	EmailVerified bool
+	// Подтвержден ли email провайдером, которому доверено подтверждать адреса этого домена (см. EmailTrusted).
+	// Только такая личность может быть автоматически связана с существующим пользователем по email
+	EmailTrusted bool
+}
+
+// Внешний провайдер личности, с которым сервис работает как клиент OpenID Connect
+type Provider interface {
+	// Название провайдера в настройках
+	Name() string
+	// Название провайдера для показа пользователю
+	DisplayName() string
+	// Возвращает адрес страницы входа провайдера. codeVerifier - случайная строка PKCE, хранящаяся до обратного вызова
+	AuthCodeURL(ctx context.Context, state string, nonce string, codeVerifier string) (string, error)
+	// Обменивает код авторизации на токены, проверяет подпись ID токена по JWKS провайдера,
+	// издателя, аудиторию, срок действия и nonce. Возвращает личность пользователя из ID токена
+	Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Identity, error)
+}
+
+// Параметры провайдера OpenID Connect
+type OIDCProviderOptions struct {
+	// Название провайдера для показа пользователю. По умолчанию название в настройках
+	DisplayName string
+	// Идентификатор издателя, по нему загружается документ /.well-known/openid-configuration
+	Issuer string
+	// client_id сервиса у провайдера
+	ClientID string
+	// client_secret сервиса у провайдера. Пустой для публичного клиента
+	ClientSecret string
+	// Адрес обратного вызова, зарегистрированный у провайдера
+	RedirectURL string
+	// Запрашиваемые scope. По умолчанию DefaultScopes
+	Scopes []string
+	// HTTP клиент для запросов к провайдеру. По умолчанию http.DefaultClient
+	HTTPClient *http.Client
+	// Доверять подтвержденным провайдером адресам (email_verified) для связывания с существующими пользователями.
+	// Включается только для провайдеров, которые сами контролируют адреса, иначе любой их пользователь
+	// с чужим адресом получит доступ к аккаунту
+	TrustEmail bool
+	// Домены адресов, которым доверяется при TrustEmail. Пустой - любые домены
+	TrustedDomains []string
+}
+
+// Провайдер OpenID Connect. Документ обнаружения загружается при первом обращении, а не при запуске,
+// чтобы недоступный провайдер не мешал запуску сервиса и входу через остальные способы
+type OIDCProvider struct {
+	ProviderName string
+	Options      OIDCProviderOptions
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// Конструктор провайдера OpenID Connect. Более предпочтительно, чем создание из голой структуры
+func NewOIDCProvider(name string, opts OIDCProviderOptions) (Provider, error) {
+	if opts.Issuer == "" || opts.ClientID == "" || opts.RedirectURL == "" {
+		return nil, fmt.Errorf("%w: issuer, client id and redirect url are required", ErrInvalidOptions)
+	}
+	if opts.DisplayName == "" {
+		opts.DisplayName = name
+	}
+	if len(opts.Scopes) == 0 {
+		opts.Scopes = DefaultScopes
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+
+	return &OIDCProvider{
+		ProviderName: name,
+		Options:      opts,
+	}, nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.ProviderName
+}
+
+func (p *OIDCProvider) DisplayName() string {
+	return p.Options.DisplayName
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeVerifier string) (string, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return p.oauth2Config(provider).AuthCodeURL(
+		state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(codeVerifier),
+	), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Identity, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = oidc.ClientContext(ctx, p.Options.HTTPClient)
+	token, err := p.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCodeExchange, err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.Options.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	return &Identity{
+		Provider:      p.ProviderName,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		EmailTrusted:  claims.EmailVerified && EmailTrusted(p.Options.TrustEmail, p.Options.TrustedDomains, claims.Email),
+	}, nil
+}
+
+// Загружает документ обнаружения провайдера. Успешный результат запоминается, ошибка - нет
+func (p *OIDCProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return p.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, p.Options.HTTPClient), p.Options.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+	p.provider = provider
+	return provider, nil
+}
+
+func (p *OIDCProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.Options.ClientID,
+		ClientSecret: p.Options.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  p.Options.RedirectURL,
+		Scopes:       p.Options.Scopes,
+	}
+}
@@ -0,0 +1,272 @@
+package federation
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/crewjam/saml"
+)
+
+const (
+	// Атрибут утверждения с email пользователя по умолчанию
+	DefaultSAMLEmailAttribute = "email"
+)
+
+// Внешний провайдер личности, с которым сервис работает как поставщик услуг SAML 2.0 (SP).
+// Запрос аутентификации отправляется через HTTP-Redirect, ответ принимается через HTTP-POST
+type SAMLProvider interface {
+	// Название провайдера в настройках
+	Name() string
+	// Название провайдера для показа пользователю
+	DisplayName() string
+	// Возвращает метаданные SP для регистрации у провайдера
+	Metadata() ([]byte, error)
+	// Возвращает адрес страницы входа провайдера с запросом AuthnRequest и ID этого запроса,
+	// который должен вернуться в InResponseTo ответа
+	AuthnRequestURL(relayState string) (string, string, error)
+	// Проверяет ответ провайдера из формы запроса: подпись закрепленными сертификатами, издателя, получателя,
+	// аудиторию, сроки действия и InResponseTo. Возвращает личность пользователя из утверждения
+	ParseResponse(r *http.Request, requestID string) (*Identity, error)
+}
+
+// Параметры провайдера SAML
+type SAMLProviderOptions struct {
+	// Название провайдера для показа пользователю. По умолчанию название в настройках
+	DisplayName string
+	// entityID провайдера, должен совпадать с Issuer ответов
+	IDPEntityID string
+	// Адрес SingleSignOnService провайдера с привязкой HTTP-Redirect
+	IDPSSOURL string
+	// Закрепленные сертификаты подписи провайдера. Ответы, подписанные другими ключами, отклоняются,
+	// даже если провайдер опубликовал их в своих метаданных
+	IDPCertificates []*x509.Certificate
+	// entityID сервиса. По умолчанию MetadataURL
+	EntityID string
+	// Адрес метаданных сервиса
+	MetadataURL string
+	// Адрес Assertion Consumer Service, на который провайдер отправляет ответ
+	ACSURL string
+	// Ключ сервиса для подписи AuthnRequest и расшифровки утверждений. Необязателен
+	Key *rsa.PrivateKey
+	// Сертификат ключа сервиса, публикуется в метаданных. Обязателен вместе с Key
+	Certificate *x509.Certificate
+	// Атрибут утверждения с email пользователя. По умолчанию DefaultSAMLEmailAttribute.
+	// Если атрибута нет, а NameID имеет формат emailAddress, email берется из NameID
+	EmailAttribute string
+	// Атрибут утверждения с постоянным идентификатором пользователя. По умолчанию - NameID
+	SubjectAttribute string
+	// Доверять адресу email из утверждения: считать его подтвержденным и связывать по нему личность
+	// с существующим пользователем. Включается только для провайдера, который сам выдает адреса,
+	// иначе любой его пользователь с чужим адресом получит доступ к аккаунту
+	TrustEmail bool
+	// Домены адресов, которым доверяется при TrustEmail. Пустой - любые домены
+	TrustedDomains []string
+}
+
+// Провайдер SAML на основе crewjam/saml
+type ImplSAMLProvider struct {
+	ProviderName    string
+	Options         SAMLProviderOptions
+	ServiceProvider *saml.ServiceProvider
+}
+
+// Конструктор провайдера SAML. Более предпочтительно, чем создание из голой структуры
+func NewSAMLProvider(name string, opts SAMLProviderOptions) (SAMLProvider, error) {
+	if opts.IDPEntityID == "" || opts.IDPSSOURL == "" || opts.MetadataURL == "" || opts.ACSURL == "" {
+		return nil, fmt.Errorf("%w: idp entity id, idp sso url, metadata url and acs url are required", ErrInvalidOptions)
+	}
+	if len(opts.IDPCertificates) == 0 {
+		return nil, fmt.Errorf("%w: at least one pinned idp certificate is required", ErrInvalidOptions)
+	}
+	if (opts.Key == nil) != (opts.Certificate == nil) {
+		return nil, fmt.Errorf("%w: sp key and certificate must be set together", ErrInvalidOptions)
+	}
+	if opts.DisplayName == "" {
+		opts.DisplayName = name
+	}
+	if opts.EmailAttribute == "" {
+		opts.EmailAttribute = DefaultSAMLEmailAttribute
+	}
+
+	metadataURL, err := url.Parse(opts.MetadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+	}
+	acsURL, err := url.Parse(opts.ACSURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+	}
+
+	// метаданные провайдера собираются из настроек, а не загружаются по сети: доверие определяется
+	// только закрепленными сертификатами
+	keyDescriptors := make([]saml.KeyDescriptor, 0, len(opts.IDPCertificates))
+	for _, certificate := range opts.IDPCertificates {
+		keyDescriptors = append(keyDescriptors, saml.KeyDescriptor{
+			Use: "signing",
+			KeyInfo: saml.KeyInfo{
+				X509Data: saml.X509Data{
+					X509Certificates: []saml.X509Certificate{{Data: encodeCertificate(certificate)}},
+				},
+			},
+		})
+	}
+
+	serviceProvider := &saml.ServiceProvider{
+		EntityID:    opts.EntityID,
+		Key:         opts.Key,
+		Certificate: opts.Certificate,
+		MetadataURL: *metadataURL,
+		AcsURL:      *acsURL,
+		IDPMetadata: &saml.EntityDescriptor{
+			EntityID: opts.IDPEntityID,
+			IDPSSODescriptors: []saml.IDPSSODescriptor{{
+				SSODescriptor: saml.SSODescriptor{
+					RoleDescriptor: saml.RoleDescriptor{
+						ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
+						KeyDescriptors:             keyDescriptors,
+					},
+				},
+				SingleSignOnServices: []saml.Endpoint{{
+					Binding:  saml.HTTPRedirectBinding,
+					Location: opts.IDPSSOURL,
+				}},
+			}},
+		},
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+	}
+	if opts.Key != nil {
+		serviceProvider.SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	}
+
+	return &ImplSAMLProvider{
+		ProviderName:    name,
+		Options:         opts,
+		ServiceProvider: serviceProvider,
+	}, nil
+}
+
+func (p *ImplSAMLProvider) Name() string {
+	return p.ProviderName
+}
+
+func (p *ImplSAMLProvider) DisplayName() string {
+	return p.Options.DisplayName
+}
+
+func (p *ImplSAMLProvider) Metadata() ([]byte, error) {
+	return xml.MarshalIndent(p.ServiceProvider.Metadata(), "", "  ")
+}
+
+func (p *ImplSAMLProvider) AuthnRequestURL(relayState string) (string, string, error) {
+	request, err := p.ServiceProvider.MakeAuthenticationRequest(
+		p.ServiceProvider.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding,
+		saml.HTTPPostBinding,
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	redirectURL, err := request.Redirect(url.QueryEscape(relayState), p.ServiceProvider)
+	if err != nil {
+		return "", "", err
+	}
+	return redirectURL.String(), request.ID, nil
+}
+
+func (p *ImplSAMLProvider) ParseResponse(r *http.Request, requestID string) (*Identity, error) {
+	// crewjam/saml читает r.PostForm, который заполняется только после разбора формы
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAssertion, err)
+	}
+
+	assertion, err := p.ServiceProvider.ParseResponse(r, []string{requestID})
+	if err != nil {
+		var invalidResponse *saml.InvalidResponseError
+		if errors.As(err, &invalidResponse) {
+			err = invalidResponse.PrivateErr
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAssertion, err)
+	}
+
+	var nameID *saml.NameID
+	if assertion.Subject != nil {
+		nameID = assertion.Subject.NameID
+	}
+
+	subject := samlAttribute(assertion, p.Options.SubjectAttribute)
+	if p.Options.SubjectAttribute == "" && nameID != nil {
+		subject = nameID.Value
+	}
+	if subject == "" {
+		return nil, fmt.Errorf("%w: assertion has no subject", ErrInvalidAssertion)
+	}
+
+	email := samlAttribute(assertion, p.Options.EmailAttribute)
+	if email == "" && nameID != nil && nameID.Format == string(saml.EmailAddressNameIDFormat) {
+		email = nameID.Value
+	}
+
+	// в SAML нет признака подтверждения адреса: подписанное утверждение подтверждает адрес, только если
+	// провайдеру доверено выдавать адреса этого домена
+	trusted := EmailTrusted(p.Options.TrustEmail, p.Options.TrustedDomains, email)
+	return &Identity{
+		Provider:      p.ProviderName,
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: trusted,
+		EmailTrusted:  trusted,
+	}, nil
+}
+
+// Разбирает PEM блоки CERTIFICATE, например закрепленные сертификаты провайдера
+func ParseCertificatesPEM(data []byte) ([]*x509.Certificate, error) {
+	certificates := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	if len(certificates) == 0 {
+		return nil, ErrNoCertificates
+	}
+	return certificates, nil
+}
+
+// Возвращает первое значение атрибута утверждения по Name или FriendlyName. Пустая строка, если атрибута нет
+func samlAttribute(assertion *saml.Assertion, name string) string {
+	if name == "" {
+		return ""
+	}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if (attribute.Name == name || attribute.FriendlyName == name) && len(attribute.Values) > 0 {
+				return attribute.Values[0].Value
+			}
+		}
+	}
+	return ""
+}
+
+func encodeCertificate(certificate *x509.Certificate) string {
+	return base64.StdEncoding.EncodeToString(certificate.Raw)
+}
@@ -0,0 +1,251 @@
+package federation
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+)
+
+const (
+	testIDPMetadataURL = "https://idp.example.com/metadata"
+	testSPBaseURL      = "https://sp.example.com/login/saml/corp"
+)
+
+// Генерирует ключ RSA и самоподписанный сертификат для него
+func newTestCertificate(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return key, certificate
+}
+
+// Провайдер SAML, выпускающий ответы на запросы тестового сервиса. Ответ и утверждение подписываются его ключом
+type testSAMLIdP struct {
+	idp *saml.IdentityProvider
+}
+
+func newTestSAMLIdP(t *testing.T) *testSAMLIdP {
+	t.Helper()
+	key, certificate := newTestCertificate(t, "idp.example.com")
+	metadataURL, _ := url.Parse(testIDPMetadataURL)
+	ssoURL, _ := url.Parse("https://idp.example.com/sso")
+	return &testSAMLIdP{idp: &saml.IdentityProvider{
+		Key:         key,
+		Certificate: certificate,
+		MetadataURL: *metadataURL,
+		SSOURL:      *ssoURL,
+	}}
+}
+
+func (p *testSAMLIdP) certificate() *x509.Certificate {
+	return p.idp.Certificate
+}
+
+// Выпускает ответ на запрос requestID с утверждением о пользователе subject с адресом email.
+// Возвращает XML ответа. Если sign равен false, ни ответ, ни утверждение не подписываются
+func (p *testSAMLIdP) response(t *testing.T, sp SAMLProvider, requestID string, subject string, email string, sign bool) []byte {
+	t.Helper()
+	serviceProvider := sp.(*ImplSAMLProvider).ServiceProvider
+	spMetadata := serviceProvider.Metadata()
+
+	req := &saml.IdpAuthnRequest{
+		IDP:                     p.idp,
+		HTTPRequest:             httptest.NewRequest(http.MethodPost, serviceProvider.AcsURL.String(), nil),
+		Request:                 saml.AuthnRequest{ID: requestID, IssueInstant: time.Now()},
+		ServiceProviderMetadata: spMetadata,
+		SPSSODescriptor:         &spMetadata.SPSSODescriptors[0],
+		ACSEndpoint:             &saml.IndexedEndpoint{Binding: saml.HTTPPostBinding, Location: serviceProvider.AcsURL.String()},
+		Now:                     time.Now(),
+	}
+	err := saml.DefaultAssertionMaker{}.MakeAssertion(req, &saml.Session{
+		NameID:       subject,
+		NameIDFormat: string(saml.PersistentNameIDFormat),
+		CreateTime:   time.Now(),
+		CustomAttributes: []saml.Attribute{{
+			Name:   DefaultSAMLEmailAttribute,
+			Values: []saml.AttributeValue{{Type: "xs:string", Value: email}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to make assertion: %v", err)
+	}
+
+	var responseEl *etree.Element
+	if sign {
+		err = req.MakeResponse()
+		if err != nil {
+			t.Fatalf("failed to make response: %v", err)
+		}
+		responseEl = req.ResponseEl
+	} else {
+		response := &saml.Response{
+			Destination:  req.ACSEndpoint.Location,
+			ID:           "id-unsigned-response",
+			InResponseTo: requestID,
+			IssueInstant: req.Now,
+			Version:      "2.0",
+			Issuer:       &saml.Issuer{Value: testIDPMetadataURL},
+			Status:       saml.Status{StatusCode: saml.StatusCode{Value: saml.StatusSuccess}},
+		}
+		responseEl = response.Element()
+		responseEl.AddChild(req.Assertion.Element())
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(responseEl)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatalf("failed to serialize response: %v", err)
+	}
+	return data
+}
+
+func newTestSAMLProvider(t *testing.T, idpCertificate *x509.Certificate, opts SAMLProviderOptions) SAMLProvider {
+	t.Helper()
+	opts.IDPEntityID = testIDPMetadataURL
+	opts.IDPSSOURL = "https://idp.example.com/sso"
+	opts.IDPCertificates = []*x509.Certificate{idpCertificate}
+	opts.MetadataURL = testSPBaseURL + "/metadata"
+	opts.ACSURL = testSPBaseURL + "/acs"
+	provider, err := NewSAMLProvider("corp", opts)
+	if err != nil {
+		t.Fatalf("failed to build provider: %v", err)
+	}
+	return provider
+}
+
+// Передает ответ провайдеру так же, как его отправляет браузер на ACS
+func parseTestResponse(provider SAMLProvider, response []byte, requestID string) (*Identity, error) {
+	form := url.Values{
+		"SAMLResponse": {base64.StdEncoding.EncodeToString(response)},
+		"RelayState":   {"relay state"},
+	}
+	request := httptest.NewRequest(http.MethodPost, testSPBaseURL+"/acs", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return provider.ParseResponse(request, requestID)
+}
+
+func TestSAMLParseResponseValid(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp.certificate(), SAMLProviderOptions{
+		TrustEmail:     true,
+		TrustedDomains: []string{"example.com"},
+	})
+
+	response := idp.response(t, provider, "id-request", "alice-subject", "alice@example.com", true)
+	identity, err := parseTestResponse(provider, response, "id-request")
+	if err != nil {
+		t.Fatalf("expected a valid response, got %v", err)
+	}
+	want := Identity{
+		Provider:      "corp",
+		Subject:       "alice-subject",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		EmailTrusted:  true,
+	}
+	if *identity != want {
+		t.Fatalf("expected identity %+v, got %+v", want, *identity)
+	}
+}
+
+func TestSAMLParseResponseUntrustedEmail(t *testing.T) {
+	tests := map[string]SAMLProviderOptions{
+		"trust disabled":   {},
+		"untrusted domain": {TrustEmail: true, TrustedDomains: []string{"corp.example.com"}},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			idp := newTestSAMLIdP(t)
+			provider := newTestSAMLProvider(t, idp.certificate(), opts)
+
+			response := idp.response(t, provider, "id-request", "alice-subject", "alice@example.com", true)
+			identity, err := parseTestResponse(provider, response, "id-request")
+			if err != nil {
+				t.Fatalf("expected a valid response, got %v", err)
+			}
+			if identity.EmailVerified || identity.EmailTrusted {
+				t.Fatalf("email of an untrusted provider was trusted: %+v", identity)
+			}
+		})
+	}
+}
+
+func TestSAMLParseResponseRejectsInvalidSignature(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp.certificate(), SAMLProviderOptions{TrustEmail: true})
+
+	tests := map[string][]byte{
+		"unsigned": idp.response(t, provider, "id-request", "alice-subject", "alice@example.com", false),
+		"tampered": bytes.ReplaceAll(
+			idp.response(t, provider, "id-request", "mallory-subject", "mallory@example.com", true),
+			[]byte("mallory@example.com"),
+			[]byte("alice@example.com"),
+		),
+		// провайдер с тем же entityID, но ключом, который не закреплен в настройках
+		"unpinned certificate": newTestSAMLIdP(t).response(t, provider, "id-request", "alice-subject", "alice@example.com", true),
+	}
+	for name, response := range tests {
+		t.Run(name, func(t *testing.T) {
+			identity, err := parseTestResponse(provider, response, "id-request")
+			if !errors.Is(err, ErrInvalidAssertion) {
+				t.Fatalf("expected ErrInvalidAssertion, got %+v, %v", identity, err)
+			}
+		})
+	}
+}
+
+func TestSAMLParseResponseRejectsWrongInResponseTo(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp.certificate(), SAMLProviderOptions{TrustEmail: true})
+
+	tests := map[string]struct {
+		inResponseTo string
+		requestID    string
+	}{
+		"another request": {"id-other-request", "id-request"},
+		// перехваченный ответ на завершенный вход предъявляется при следующем входе
+		"replayed for a new login": {"id-request", "id-next-request"},
+		// ответ, не запрошенный сервисом
+		"idp initiated": {"", "id-request"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			response := idp.response(t, provider, test.inResponseTo, "alice-subject", "alice@example.com", true)
+			identity, err := parseTestResponse(provider, response, test.requestID)
+			if !errors.Is(err, ErrInvalidAssertion) {
+				t.Fatalf("expected ErrInvalidAssertion, got %+v, %v", identity, err)
+			}
+		})
+	}
+}
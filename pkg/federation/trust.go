@@ -0,0 +1,29 @@
+package federation
+
+import (
+	"slices"
+	"strings"
+)
+
+// Проверяет, доверено ли провайдеру подтверждать принадлежность адреса email.
+//
+// Связывание внешней личности с существующим пользователем по email передает провайдеру доступ к аккаунту,
+// поэтому доверять можно только провайдеру, который сам выдает и контролирует адреса (например, корпоративному
+// каталогу). trustEmail включает доверие, непустой trustedDomains ограничивает его перечисленными доменами
+func EmailTrusted(trustEmail bool, trustedDomains []string, email string) bool {
+	if !trustEmail || email == "" {
+		return false
+	}
+	if len(trustedDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	return slices.ContainsFunc(trustedDomains, func(trusted string) bool {
+		return strings.EqualFold(strings.TrimSpace(trusted), domain)
+	})
+}
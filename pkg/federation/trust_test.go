@@ -0,0 +1,29 @@
+package federation
+
+import "testing"
+
+func TestEmailTrusted(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustEmail     bool
+		trustedDomains []string
+		email          string
+		want           bool
+	}{
+		{"trust disabled", false, nil, "alice@example.com", false},
+		{"any domain", true, nil, "alice@example.com", true},
+		{"empty email", true, nil, "", false},
+		{"listed domain", true, []string{"corp.example.com", " Example.com "}, "alice@EXAMPLE.com", true},
+		{"other domain", true, []string{"example.com"}, "alice@evil.com", false},
+		{"subdomain is not listed", true, []string{"example.com"}, "alice@mail.example.com", false},
+		{"quoted at sign", true, []string{"example.com"}, `"alice@example.com"@evil.com`, false},
+		{"no domain", true, []string{"example.com"}, "alice", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := EmailTrusted(test.trustEmail, test.trustedDomains, test.email); got != test.want {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
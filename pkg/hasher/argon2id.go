@@ -0,0 +1,144 @@
+package hasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Префикс хешей argon2id в формате PHC
+const argon2idPrefix = "$argon2id$"
+
+// Параметры argon2id по умолчанию (рекомендации OWASP)
+const (
+	DefaultArgon2Time       = 3
+	DefaultArgon2Memory     = 64 * 1024
+	DefaultArgon2Threads    = 2
+	DefaultArgon2KeyLength  = 32
+	DefaultArgon2SaltLength = 16
+)
+
+// Хешер argon2id. Хеши хранятся в формате PHC: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+type Argon2idHasher struct {
+	// Количество проходов
+	Time uint32
+	// Объем памяти в KiB
+	Memory uint32
+	// Количество потоков
+	Threads uint8
+	// Длина хеша в байтах
+	KeyLength uint32
+	// Длина соли в байтах
+	SaltLength uint32
+}
+
+// Конструктор хешера argon2id. Нулевые параметры заменяются значениями по умолчанию
+func NewArgon2idHasher(time uint32, memory uint32, threads uint8, keyLength uint32, saltLength uint32) Hasher {
+	return &Argon2idHasher{
+		Time:       orDefault(time, DefaultArgon2Time),
+		Memory:     orDefault(memory, DefaultArgon2Memory),
+		Threads:    orDefault(threads, DefaultArgon2Threads),
+		KeyLength:  orDefault(keyLength, DefaultArgon2KeyLength),
+		SaltLength: orDefault(saltLength, DefaultArgon2SaltLength),
+	}
+}
+
+// Параметры, извлеченные из хеша argon2id
+type argon2idParams struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLength)
+
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.Memory,
+		h.Time,
+		h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Compare(hash string, password string) bool {
+	params, err := parseArgon2id(hash)
+	if err != nil || params.version != argon2.Version {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1
+}
+
+func (h *Argon2idHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.version != argon2.Version ||
+		params.memory != h.Memory ||
+		params.time != h.Time ||
+		params.threads != h.Threads ||
+		uint32(len(params.key)) != h.KeyLength ||
+		uint32(len(params.salt)) != h.SaltLength
+}
+
+// Разбирает хеш argon2id в формате PHC
+func parseArgon2id(hash string) (*argon2idParams, error) {
+	parts := strings.Split(hash, "$")
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrInvalidHash
+	}
+
+	params := argon2idParams{}
+	_, err := fmt.Sscanf(parts[2], "v=%d", &params.version)
+	if err != nil {
+		return nil, ErrInvalidHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	// нулевые параметры недопустимы, а с p=0 argon2 паникует
+	if err != nil || params.memory == 0 || params.time == 0 || params.threads == 0 {
+		return nil, ErrInvalidHash
+	}
+
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, ErrInvalidHash
+	}
+	params.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(params.key) == 0 {
+		return nil, ErrInvalidHash
+	}
+
+	return &params, nil
+}
+
+func orDefault[T uint8 | uint32](value T, def T) T {
+	if value == 0 {
+		return def
+	}
+	return value
+}
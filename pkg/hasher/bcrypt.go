@@ -0,0 +1,53 @@
+package hasher
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Хешер bcrypt. Учитывает только первые 72 байта пароля
+type BcryptHasher struct {
+	Cost int
+}
+
+// Конструктор хешера bcrypt. Нулевая стоимость заменяется bcrypt.DefaultCost.
+// Стоимость вне диапазона bcrypt.MinCost..bcrypt.MaxCost не принимается: bcrypt молча заменил бы ее
+// на bcrypt.DefaultCost, и NeedsRehash требовал бы пересчитать хеш при каждом входе
+func NewBcryptHasher(cost int) (Hasher, error) {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("%w: bcrypt cost must be between %d and %d", ErrInvalidOptions, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return &BcryptHasher{
+		Cost: cost,
+	}, nil
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Compare(hash string, password string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+func (h *BcryptHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return cost != h.Cost
+}
@@ -0,0 +1,10 @@
+package hasher
+
+import "errors"
+
+var (
+	ErrInvalidHash          = errors.New("invalid hash format")
+	ErrInvalidOptions       = errors.New("invalid hasher options")
+	ErrUnknownPepperVersion = errors.New("unknown pepper version")
+	ErrInvalidPepperFile    = errors.New("invalid pepper file format")
+)
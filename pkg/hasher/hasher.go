@@ -0,0 +1,65 @@
+package hasher
+
+// Алгоритм хеширования паролей
+type Hasher interface {
+	// Хеширует пароль, результат содержит префикс алгоритма и все параметры, необходимые для проверки
+	Hash(password string) (string, error)
+	// Сравнивает хеш и сырой пароль
+	Compare(hash string, password string) bool
+	// Возвращает true, если хеш создан этим алгоритмом (определяется по префиксу)
+	Identify(hash string) bool
+	// Возвращает true, если хеш создан этим алгоритмом, но с устаревшими параметрами
+	NeedsRehash(hash string) bool
+}
+
+// Хешер, поддерживающий несколько алгоритмов.
+// Новые хеши создаются алгоритмом Default, проверка выполняется алгоритмом, определенным по префиксу хеша
+type MultiHasher struct {
+	Default Hasher
+	Known   []Hasher
+}
+
+// Конструктор хешера с несколькими алгоритмами. defaultHasher используется для новых хешей,
+// known - алгоритмы, хеши которых еще могут встретиться в БД
+func NewMultiHasher(defaultHasher Hasher, known ...Hasher) Hasher {
+	return &MultiHasher{
+		Default: defaultHasher,
+		Known:   known,
+	}
+}
+
+func (h *MultiHasher) Hash(password string) (string, error) {
+	return h.Default.Hash(password)
+}
+
+func (h *MultiHasher) Compare(hash string, password string) bool {
+	hasher := h.identify(hash)
+	if hasher == nil {
+		return false
+	}
+	return hasher.Compare(hash, password)
+}
+
+func (h *MultiHasher) Identify(hash string) bool {
+	return h.identify(hash) != nil
+}
+
+// Хеш нужно пересоздать, если он создан не алгоритмом по умолчанию или с устаревшими параметрами
+func (h *MultiHasher) NeedsRehash(hash string) bool {
+	if !h.Default.Identify(hash) {
+		return true
+	}
+	return h.Default.NeedsRehash(hash)
+}
+
+func (h *MultiHasher) identify(hash string) Hasher {
+	if h.Default.Identify(hash) {
+		return h.Default
+	}
+	for _, hasher := range h.Known {
+		if hasher.Identify(hash) {
+			return hasher
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package hasher
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Версия перца, означающая что перец не применяется
+const NoPepper = 0
+
+type ImplPepper struct {
+	// Секреты по версиям
+	Keys map[int][]byte
+	// Версия, применяемая к новым хешам
+	Current int
+}
+
+// Перец - секрет сервера, который применяется к паролю (HMAC-SHA256) до хеширования.
+// Без него утекшая таблица пользователей бесполезна для офлайн перебора
+type Pepper interface {
+	// Применяет к паролю перец указанной версии. Для NoPepper возвращает пароль без изменений
+	Apply(version int, password string) (string, error)
+	// Возвращает версию перца, которую нужно применять к новым хешам
+	CurrentVersion() int
+}
+
+// Конструктор перца. current должен присутствовать в keys, если только это не NoPepper
+func NewPepper(keys map[int][]byte, current int) (Pepper, error) {
+	if _, ok := keys[current]; current != NoPepper && !ok {
+		return nil, ErrUnknownPepperVersion
+	}
+	return &ImplPepper{
+		Keys:    keys,
+		Current: current,
+	}, nil
+}
+
+func (p *ImplPepper) Apply(version int, password string) (string, error) {
+	if version == NoPepper {
+		return password, nil
+	}
+
+	key, ok := p.Keys[version]
+	if !ok {
+		return "", ErrUnknownPepperVersion
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	// base64 от HMAC-SHA256 занимает 44 байта и укладывается в ограничение bcrypt
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (p *ImplPepper) CurrentVersion() int {
+	return p.Current
+}
+
+// Загружает секреты перца из файла. Каждая строка имеет вид <версия>=<секрет>, пустые строки и строки с # пропускаются
+func LoadPepperFile(path string) (map[int][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := map[int][]byte{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		versionString, secret, ok := strings.Cut(line, "=")
+		if !ok || secret == "" {
+			return nil, ErrInvalidPepperFile
+		}
+		version, err := strconv.Atoi(strings.TrimSpace(versionString))
+		if err != nil || version == NoPepper {
+			return nil, ErrInvalidPepperFile
+		}
+		keys[version] = []byte(secret)
+	}
+
+	return keys, scanner.Err()
+}
+
+// Сравнивает хеш и сырой пароль, предварительно применив к паролю перец версии pepperVersion
+func CompareWithPepper(h Hasher, pepper Pepper, hash string, pepperVersion int, password string) (bool, error) {
+	peppered, err := pepper.Apply(pepperVersion, password)
+	if err != nil {
+		return false, err
+	}
+	return h.Compare(hash, peppered), nil
+}
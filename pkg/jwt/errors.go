@@ -6,4 +6,5 @@ var (
 	ErrInvalidToken      = errors.New("invalid token")
 	ErrUnknownClaimsType = errors.New("unknown claims type")
 	ErrTokensNotPaired   = errors.New("tokens is not paired")
+	ErrInvalidSigningKey = errors.New("invalid signing key")
 )
@@ -0,0 +1,153 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type ImplIDTokenIssuer struct {
+	PrivateKey *rsa.PrivateKey
+	KeyID      string
+	Issuer     string
+	IDExpires  time.Duration
+}
+
+// Выпуск ID токенов OpenID Connect.
+//
+// В отличие от access и refresh токенов ID токены подписываются асимметрично (RS256), чтобы клиенты
+// могли проверять их по публичному ключу из JWKS без общего секрета
+type IDTokenIssuer interface {
+	// Подписывает ID токен пользователя uid для клиента clientID (aud). Поля iss, iat, exp и jti заполняются автоматически
+	GenerateIDToken(uid string, clientID string, authTime time.Time, claims IDClaims) (string, error)
+	// Возвращает идентификатор издателя (iss)
+	GetIssuer() string
+	// Возвращает набор публичных ключей для проверки ID токенов
+	GetJWKS() JWKS
+}
+
+// Конструктор издателя ID токенов. Идентификатор ключа (kid) вычисляется как JWK thumbprint (RFC 7638)
+func NewIDTokenIssuer(privateKey *rsa.PrivateKey, issuer string, idExpires time.Duration) IDTokenIssuer {
+	return &ImplIDTokenIssuer{
+		PrivateKey: privateKey,
+		KeyID:      rsaThumbprint(&privateKey.PublicKey),
+		Issuer:     issuer,
+		IDExpires:  idExpires,
+	}
+}
+
+// Payload ID токена
+type IDClaims struct {
+	// Значение nonce из запроса авторизации
+	Nonce string `json:"nonce,omitempty"`
+	// Время аутентификации пользователя
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// Хеш access токена, выданного вместе с ID токеном
+	AccessTokenHash string `json:"at_hash,omitempty"`
+	// Email пользователя (scope email)
+	Email string `json:"email,omitempty"`
+	// Подтвержден ли email (scope email)
+	EmailVerified *bool `json:"email_verified,omitempty"`
+	// Время последнего изменения данных пользователя в секундах (scope profile)
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Публичный ключ в формате JWK
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// Набор публичных ключей (JWKS)
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (i *ImplIDTokenIssuer) GenerateIDToken(uid string, clientID string, authTime time.Time, claims IDClaims) (string, error) {
+	now := time.Now()
+	claims.Subject = uid
+	claims.Audience = jwt.ClaimStrings{clientID}
+	claims.AuthTime = jwt.NewNumericDate(authTime)
+	claims.Issuer = i.Issuer
+	claims.ID = uuid.NewString()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(i.IDExpires))
+
+	idJWT := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idJWT.Header["kid"] = i.KeyID
+
+	return idJWT.SignedString(i.PrivateKey)
+}
+
+func (i *ImplIDTokenIssuer) GetIssuer() string {
+	return i.Issuer
+}
+
+func (i *ImplIDTokenIssuer) GetJWKS() JWKS {
+	publicKey := &i.PrivateKey.PublicKey
+	return JWKS{
+		Keys: []JWK{{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodRS256.Alg(),
+			KeyID:     i.KeyID,
+			N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}},
+	}
+}
+
+// Вычисляет at_hash: левая половина SHA-256 от access токена в base64 (OpenID Connect Core, раздел 3.1.3.6)
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// Загружает приватный RSA ключ из PEM файла (PKCS #1 или PKCS #8)
+func LoadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidSigningKey
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidSigningKey
+	}
+	return rsaKey, nil
+}
+
+// JWK thumbprint публичного RSA ключа (RFC 7638)
+func rsaThumbprint(publicKey *rsa.PublicKey) string {
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	n := base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+	// члены JWK в лексикографическом порядке и без пробелов
+	sum := sha256.Sum256([]byte(`{"e":"` + e + `","kty":"RSA","n":"` + n + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -1,6 +1,8 @@
 package jwt
 
 import (
+	"crypto/hmac"
+	"crypto/sha512"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,6 +16,10 @@ var (
 	RefreshExpires = 7 * 24 * time.Hour
 	// Значние допустимого расхождения времени при проверке действительности токена
 	ParseLeewayWindow = 10 * time.Second
+	// Значение которое будет прибавлено к текущему времени, чтобы установить время когда токен MFA челленджа истечет
+	MFAExpires = 5 * time.Minute
+	// Значение которое будет прибавлено к текущему времени, чтобы установить время когда ID токен истечет
+	IDExpires = time.Hour
 )
 
 type ImplJWT struct {
@@ -22,6 +28,8 @@ type ImplJWT struct {
 	AccessExpires     time.Duration
 	RefreshExpires    time.Duration
 	ParseLeewayWindow time.Duration
+	MFAExpires        time.Duration
+	MFASecretKey      []byte
 }
 
 // Менеджер работы с токенами
@@ -30,8 +38,12 @@ type JWT interface {
 	//
 	// Оба токена имеют тип JWT. access (первый) - HS512, refresh (второй) - HS256
 	//
-	// uuid пользовтаеля записывается в поле Subject (sub), userIP в UserIP (user_ip)
-	GenereteTokenPair(uid string, userIP string) (string, string, error)
+	// uuid пользовтаеля записывается в поле Subject (sub), userIP в UserIP (user_ip).
+	// Для токенов, выданных OAuth клиентам, передаются опции WithClientID и WithScope
+	GenereteTokenPair(uid string, userIP string, opts ...TokenOption) (string, string, error)
+	// Создает access токен (HS512) сервисного аккаунта без пользователя и без refresh токена.
+	// client_id записывается в Subject (sub), ClientID (client_id) и Audience (aud), токен помечается полем Machine (machine)
+	GenerateMachineToken(clientID string, scope string) (string, error)
 	// Проверяет действительность access токена, в случае если токен действителен, возвращает его payload
 	ValidateAccessToken(accessToken string) (*AccessClaims, error)
 	// Парсит и выводит полезную нагрузку токена без проверки действительности
@@ -48,6 +60,13 @@ type JWT interface {
 	GetRefreshExpires() time.Duration
 	// Возвращает время в течении которого refresh токен валиден с момента создания в секундах
 	GetRefreshExpiresSec() int
+	// Создает короткоживущий токен MFA челленджа (HS512) для пользователя, прошедшего первый фактор.
+	// Такой токен нельзя использовать как access токен
+	GenerateMFAToken(uid string) (string, error)
+	// Проверяет действительность токена MFA челленджа, в случае если токен действителен, возвращает его payload
+	ValidateMFAToken(mfaToken string) (*MFAClaims, error)
+	// Возвращает время в течении которого токен MFA челленджа валиден с момента создания в секундах
+	GetMFAExpiresSec() int
 }
 
 // Конструктор менеджера токенов. Более предпочтительно чем создавать из голой структуры
@@ -57,13 +76,51 @@ func NewJWT(
 	accessExpires time.Duration,
 	refreshExpires time.Duration,
 	parseLeewayWindow time.Duration,
+	mfaExpires time.Duration,
 ) JWT {
+	// отдельный ключ не дает выдать токен MFA челленджа за access токен, даже если их payload совместимы
+	mac := hmac.New(sha512.New, accessSecretKey)
+	mac.Write([]byte("mfa"))
+
 	return &ImplJWT{
 		AccessSecretKey:   accessSecretKey,
 		RefreshSecretKey:  refreshSecretKey,
 		AccessExpires:     accessExpires,
 		RefreshExpires:    refreshExpires,
 		ParseLeewayWindow: parseLeewayWindow,
+		MFAExpires:        mfaExpires,
+		MFASecretKey:      mac.Sum(nil),
+	}
+}
+
+// Опция выпуска пары токенов
+type TokenOption func(*tokenOptions)
+
+type tokenOptions struct {
+	clientID string
+	scope    string
+	authTime time.Time
+}
+
+// Выпускает токены для OAuth клиента: client_id записывается в ClientID (client_id) и в Audience (aud)
+func WithClientID(clientID string) TokenOption {
+	return func(o *tokenOptions) {
+		o.clientID = clientID
+	}
+}
+
+// Записывает выданные OAuth клиенту scope (через пробел) в Scope (scope)
+func WithScope(scope string) TokenOption {
+	return func(o *tokenOptions) {
+		o.scope = scope
+	}
+}
+
+// Записывает время аутентификации пользователя в AuthTime (auth_time). По умолчанию - время выпуска пары,
+// при обновлении пары нужно передавать время из предыдущего токена
+func WithAuthTime(authTime time.Time) TokenOption {
+	return func(o *tokenOptions) {
+		o.authTime = authTime
 	}
 }
 
@@ -71,6 +128,14 @@ func NewJWT(
 type AccessClaims struct {
 	// IP с которого был выполнен запрос на получение токена
 	UserIP string `json:"user_ip"`
+	// client_id OAuth клиента, которому выдан токен. Пустой для токенов самого сервиса
+	ClientID string `json:"client_id,omitempty"`
+	// scope, выданные OAuth клиенту, через пробел
+	Scope string `json:"scope,omitempty"`
+	// Время аутентификации пользователя (ввода учетных данных)
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// Токен выдан сервисному аккаунту, а не пользователю. Subject такого токена - client_id, а не uuid пользователя
+	Machine bool `json:"machine,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -80,16 +145,45 @@ type RefreshClaims struct {
 	AccessID string `json:"access_id"`
 	// IP с которого был выполнен запрос на получение токена
 	UserIP string `json:"user_ip"`
+	// client_id OAuth клиента, которому выдан токен. Пустой для токенов самого сервиса
+	ClientID string `json:"client_id,omitempty"`
+	// scope, выданные OAuth клиенту, через пробел
+	Scope string `json:"scope,omitempty"`
+	// Время аутентификации пользователя (ввода учетных данных)
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func (j *ImplJWT) GenereteTokenPair(uid string, userIP string) (string, string, error) {
+// Payload токена MFA челленджа
+type MFAClaims struct {
+	jwt.RegisteredClaims
+}
+
+func (j *ImplJWT) GenereteTokenPair(uid string, userIP string, opts ...TokenOption) (string, string, error) {
+	options := tokenOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var audience jwt.ClaimStrings
+	if options.clientID != "" {
+		audience = jwt.ClaimStrings{options.clientID}
+	}
+	if options.authTime.IsZero() {
+		options.authTime = time.Now()
+	}
+	authTime := jwt.NewNumericDate(options.authTime)
+
 	tokenID := uuid.NewString()
 
 	accessJWT := jwt.NewWithClaims(jwt.SigningMethodHS512, AccessClaims{
-		UserIP: userIP,
+		UserIP:   userIP,
+		ClientID: options.clientID,
+		Scope:    options.scope,
+		AuthTime: authTime,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   uid,
+			Audience:  audience,
 			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.AccessExpires)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -98,8 +192,12 @@ func (j *ImplJWT) GenereteTokenPair(uid string, userIP string) (string, string,
 	refreshJWT := jwt.NewWithClaims(jwt.SigningMethodHS256, RefreshClaims{
 		AccessID: tokenID,
 		UserIP:   userIP,
+		ClientID: options.clientID,
+		Scope:    options.scope,
+		AuthTime: authTime,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   uid,
+			Audience:  audience,
 			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.RefreshExpires)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -119,6 +217,23 @@ func (j *ImplJWT) GenereteTokenPair(uid string, userIP string) (string, string,
 	return access, refresh, nil
 }
 
+func (j *ImplJWT) GenerateMachineToken(clientID string, scope string) (string, error) {
+	machineJWT := jwt.NewWithClaims(jwt.SigningMethodHS512, AccessClaims{
+		ClientID: clientID,
+		Scope:    scope,
+		Machine:  true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   clientID,
+			Audience:  jwt.ClaimStrings{clientID},
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.AccessExpires)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+
+	return machineJWT.SignedString(j.AccessSecretKey)
+}
+
 func (j *ImplJWT) GetAccessClaimsWithoutValidation(accessToken string) (*AccessClaims, error) {
 	token, err := jwt.ParseWithClaims(accessToken, &AccessClaims{}, func(t *jwt.Token) (interface{}, error) {
 		return j.AccessSecretKey, nil
@@ -182,7 +297,11 @@ func (j *ImplJWT) RefreshTokenPair(accessClaims *AccessClaims, refreshClaims *Re
 		return "", "", ErrTokensNotPaired
 	}
 
-	return j.GenereteTokenPair(refreshClaims.Subject, currentUserIP)
+	opts := []TokenOption{WithClientID(refreshClaims.ClientID), WithScope(refreshClaims.Scope)}
+	if refreshClaims.AuthTime != nil {
+		opts = append(opts, WithAuthTime(refreshClaims.AuthTime.Time))
+	}
+	return j.GenereteTokenPair(refreshClaims.Subject, currentUserIP, opts...)
 }
 
 func (j *ImplJWT) GetAccessExpires() time.Duration {
@@ -200,3 +319,40 @@ func (j *ImplJWT) GetRefreshExpires() time.Duration {
 func (j *ImplJWT) GetRefreshExpiresSec() int {
 	return int(j.RefreshExpires.Seconds())
 }
+
+func (j *ImplJWT) GenerateMFAToken(uid string) (string, error) {
+	mfaJWT := jwt.NewWithClaims(jwt.SigningMethodHS512, MFAClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   uid,
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.MFAExpires)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+
+	return mfaJWT.SignedString(j.MFASecretKey)
+}
+
+func (j *ImplJWT) ValidateMFAToken(mfaToken string) (*MFAClaims, error) {
+	token, err := jwt.ParseWithClaims(mfaToken, &MFAClaims{}, func(t *jwt.Token) (interface{}, error) {
+		return j.MFASecretKey, nil
+	}, jwt.WithLeeway(j.ParseLeewayWindow), jwt.WithValidMethods([]string{jwt.SigningMethodHS512.Alg()}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	if claims, ok := token.Claims.(*MFAClaims); ok {
+		return claims, nil
+	}
+
+	return nil, ErrUnknownClaimsType
+}
+
+func (j *ImplJWT) GetMFAExpiresSec() int {
+	return int(j.MFAExpires.Seconds())
+}
@@ -0,0 +1,129 @@
+package lockout
+
+import "time"
+
+const (
+	// Количество неудачных попыток входа в аккаунт, после которого включаются задержки, по умолчанию
+	DefaultDelayThreshold = 3
+	// Количество неудачных попыток входа в аккаунт, после которого аккаунт блокируется, по умолчанию
+	DefaultLockThreshold = 10
+	// Количество неудачных попыток входа с одного IP, после которого включаются задержки, по умолчанию
+	DefaultIPDelayThreshold = 10
+	// Количество неудачных попыток входа с одного IP, после которого IP блокируется, по умолчанию
+	DefaultIPLockThreshold = 50
+)
+
+var (
+	// Начальная задержка после превышения порога по умолчанию, каждая следующая попытка удваивает ее
+	DefaultBaseDelay = time.Second
+	// Максимальная задержка между попытками по умолчанию
+	DefaultMaxDelay = 5 * time.Minute
+	// Время блокировки аккаунта или IP по умолчанию
+	DefaultLockDuration = 15 * time.Minute
+	// Окно, в течении которого учитываются неудачные попытки входа с одного IP, по умолчанию
+	DefaultIPWindow = time.Hour
+)
+
+// Пороги для одного вида счетчика неудачных попыток (аккаунт или IP)
+type Thresholds struct {
+	// Количество неудачных попыток, после которого включаются экспоненциальные задержки
+	Delay int
+	// Количество неудачных попыток, после которого включается временная блокировка
+	Lock int
+}
+
+type ImplLockoutPolicy struct {
+	Account      Thresholds
+	IP           Thresholds
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockDuration time.Duration
+	IPWindow     time.Duration
+}
+
+// Политика задержек и блокировок после неудачных попыток входа
+type LockoutPolicy interface {
+	// Возвращает время, раньше которого нельзя выполнять следующую попытку входа в аккаунт.
+	// Если задержка не требуется, возвращает lastFailedAt
+	AccountNextAttemptAt(failures int, lastFailedAt time.Time) time.Time
+	// Возвращает true, если после failures неудачных попыток аккаунт нужно заблокировать
+	ShouldLockAccount(failures int) bool
+	// Возвращает время, раньше которого нельзя выполнять следующую попытку входа с IP.
+	// Учитывает как задержки, так и блокировку IP
+	IPNextAttemptAt(failures int, lastFailedAt time.Time) time.Time
+	// Возвращает время блокировки
+	GetLockDuration() time.Duration
+	// Возвращает окно, в течении которого учитываются неудачные попытки входа с одного IP
+	GetIPWindow() time.Duration
+}
+
+// Конструктор политики блокировок. Нулевые значения заменяются значениями по умолчанию
+func NewLockoutPolicy(
+	account Thresholds,
+	ip Thresholds,
+	baseDelay time.Duration,
+	maxDelay time.Duration,
+	lockDuration time.Duration,
+	ipWindow time.Duration,
+) LockoutPolicy {
+	return &ImplLockoutPolicy{
+		Account: Thresholds{
+			Delay: orDefault(account.Delay, DefaultDelayThreshold),
+			Lock:  orDefault(account.Lock, DefaultLockThreshold),
+		},
+		IP: Thresholds{
+			Delay: orDefault(ip.Delay, DefaultIPDelayThreshold),
+			Lock:  orDefault(ip.Lock, DefaultIPLockThreshold),
+		},
+		BaseDelay:    orDefault(baseDelay, DefaultBaseDelay),
+		MaxDelay:     orDefault(maxDelay, DefaultMaxDelay),
+		LockDuration: orDefault(lockDuration, DefaultLockDuration),
+		IPWindow:     orDefault(ipWindow, DefaultIPWindow),
+	}
+}
+
+func (p *ImplLockoutPolicy) AccountNextAttemptAt(failures int, lastFailedAt time.Time) time.Time {
+	return lastFailedAt.Add(p.delay(failures, p.Account.Delay))
+}
+
+func (p *ImplLockoutPolicy) ShouldLockAccount(failures int) bool {
+	return failures >= p.Account.Lock
+}
+
+func (p *ImplLockoutPolicy) IPNextAttemptAt(failures int, lastFailedAt time.Time) time.Time {
+	if failures >= p.IP.Lock {
+		return lastFailedAt.Add(p.LockDuration)
+	}
+	return lastFailedAt.Add(p.delay(failures, p.IP.Delay))
+}
+
+func (p *ImplLockoutPolicy) GetLockDuration() time.Duration {
+	return p.LockDuration
+}
+
+func (p *ImplLockoutPolicy) GetIPWindow() time.Duration {
+	return p.IPWindow
+}
+
+// Экспоненциальная задержка: BaseDelay * 2^(failures - threshold), но не больше MaxDelay
+func (p *ImplLockoutPolicy) delay(failures int, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := threshold; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
+
+func orDefault[T int | time.Duration](value T, def T) T {
+	if value <= 0 {
+		return def
+	}
+	return value
+}
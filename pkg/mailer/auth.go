@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// Механизм аутентификации LOGIN, которого нет в net/smtp. Как и smtp.PlainAuth, отправляет учетные данные
+// только по TLS соединению или на localhost
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, ErrUnencryptedAuth
+	}
+	if server.Name != a.host {
+		return "", nil, ErrWrongHost
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	// сервер запрашивает значения по очереди, net/smtp передает запросы уже декодированными из base64
+	challenge := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(challenge, "username"), strings.HasPrefix(challenge, "user name"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(challenge, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnexpectedChallenge, fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
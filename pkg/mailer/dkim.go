@@ -0,0 +1,338 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Алгоритмы подписи DKIM
+const (
+	DKIMAlgorithmRSASHA256     = "rsa-sha256"
+	DKIMAlgorithmEd25519SHA256 = "ed25519-sha256"
+)
+
+// Максимальная длина строки заголовка DKIM-Signature до переноса
+const dkimLineLength = 78
+
+var (
+	// Подписываемые заголовки по умолчанию (те, что есть в письме). Заголовки From и Subject подписываются
+	// еще раз как отсутствующие, чтобы к письму нельзя было добавить второй такой заголовок (RFC 6376, раздел 8.15)
+	DefaultDKIMHeaders = []string{
+		"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-ID",
+		"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	}
+	// Заголовки, подписываемые дополнительно как отсутствующие по умолчанию
+	DefaultDKIMOversignHeaders = []string{"From", "Subject"}
+)
+
+// Параметры подписи DKIM
+type DKIMOptions struct {
+	// Домен подписи (тег d=), должен совпадать с доменом адреса отправителя или быть его родительским доменом
+	Domain string
+	// Селектор (тег s=): публичный ключ публикуется в TXT записи <Selector>._domainkey.<Domain>
+	Selector string
+	// Закрытый ключ RSA (не короче 1024 бит, рекомендуется 2048) или Ed25519 (см. ParseDKIMPrivateKey)
+	PrivateKey crypto.Signer
+	// Подписываемые заголовки. Пустой - DefaultDKIMHeaders
+	Headers []string
+	// Заголовки, подписываемые дополнительно как отсутствующие. nil - DefaultDKIMOversignHeaders
+	OversignHeaders []string
+}
+
+// Транспорт, подписывающий сообщения DKIM (RFC 6376, RFC 8463) перед передачей следующему транспорту.
+// Используется канонизация relaxed/relaxed, подпись добавляется первым заголовком сообщения
+type DKIMTransport struct {
+	Transport Transport
+	Options   DKIMOptions
+	// Алгоритм подписи, определяется по типу ключа (см. константы DKIMAlgorithm*)
+	Algorithm string
+}
+
+// Конструктор DKIM транспорта поверх transport
+func NewDKIMTransport(transport Transport, options DKIMOptions) (Transport, error) {
+	if options.Domain == "" || options.Selector == "" {
+		return nil, fmt.Errorf("%w: domain and selector are required", ErrInvalidDKIMOptions)
+	}
+	if len(options.Headers) == 0 {
+		options.Headers = DefaultDKIMHeaders
+	}
+	if options.OversignHeaders == nil {
+		options.OversignHeaders = DefaultDKIMOversignHeaders
+	}
+
+	var algorithm string
+	switch key := options.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 1024 {
+			return nil, fmt.Errorf("%w: rsa key must be at least 1024 bits", ErrInvalidDKIMOptions)
+		}
+		algorithm = DKIMAlgorithmRSASHA256
+	case ed25519.PrivateKey:
+		algorithm = DKIMAlgorithmEd25519SHA256
+	default:
+		return nil, fmt.Errorf("%w: unsupported private key type %T", ErrInvalidDKIMOptions, options.PrivateKey)
+	}
+
+	return &DKIMTransport{
+		Transport: transport,
+		Options:   options,
+		Algorithm: algorithm,
+	}, nil
+}
+
+func (t *DKIMTransport) Send(from string, to []string, msg []byte) error {
+	signature, err := t.Sign(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return t.Transport.Send(from, to, append([]byte(signature), msg...))
+}
+
+// Возвращает заголовок DKIM-Signature (с CRLF в конце) для сообщения msg.
+// Сообщение должно содержать заголовок From с адресом в домене подписи или его поддомене: подпись чужого домена
+// не проходит проверку DMARC у получателя, поэтому такое сообщение не подписывается
+func (t *DKIMTransport) Sign(msg []byte, now time.Time) (string, error) {
+	header, body := splitMessage(msg)
+	fields := parseHeaderFields(header)
+
+	for i := len(fields) - 1; i >= 0; i-- {
+		if !strings.EqualFold(fieldName(fields[i]), "From") {
+			continue
+		}
+		_, value, _ := strings.Cut(fields[i], ":")
+		err := CheckDKIMDomain(t.Options.Domain, strings.ReplaceAll(value, "\r\n", ""))
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	bodyHash := sha256.Sum256(canonicalizeBodyRelaxed(body))
+
+	signed := &bytes.Buffer{}
+	signedNames := []string{}
+	// одинаковые заголовки подписываются снизу вверх (RFC 6376, раздел 5.4.2)
+	used := map[int]bool{}
+	for _, name := range t.Options.Headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fieldName(fields[i]), name) {
+				continue
+			}
+			used[i] = true
+			signed.WriteString(canonicalizeHeaderRelaxed(fields[i]))
+			signed.WriteString("\r\n")
+			signedNames = append(signedNames, strings.ToLower(name))
+			break
+		}
+	}
+	if !slices.Contains(signedNames, "from") {
+		return "", fmt.Errorf("%w: from header is required", ErrInvalidMessage)
+	}
+	// отсутствующий заголовок в списке h= подписывается как пустая строка
+	for _, name := range t.Options.OversignHeaders {
+		signedNames = append(signedNames, strings.ToLower(name))
+	}
+
+	tags := []string{
+		"v=1",
+		"a=" + t.Algorithm,
+		"c=relaxed/relaxed",
+		"d=" + t.Options.Domain,
+		"s=" + t.Options.Selector,
+		"t=" + strconv.FormatInt(now.Unix(), 10),
+		"h=" + strings.Join(signedNames, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+	}
+	// подпись b= вычисляется по заголовку с пустым значением b= и дописывается в его конец
+	unsigned := foldDKIMTags("DKIM-Signature: ", append(tags, "b="))
+
+	signed.WriteString(canonicalizeHeaderRelaxed(unsigned))
+	hash := sha256.Sum256(signed.Bytes())
+
+	var signature []byte
+	var err error
+	switch t.Algorithm {
+	case DKIMAlgorithmRSASHA256:
+		signature, err = t.Options.PrivateKey.Sign(rand.Reader, hash[:], crypto.SHA256)
+	default:
+		// Ed25519 подписывает sha256 хеш данных, а не сами данные (RFC 8463, раздел 3)
+		signature, err = t.Options.PrivateKey.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + foldDKIMSignature(base64.StdEncoding.EncodeToString(signature), len(lastLine(unsigned))) + "\r\n", nil
+}
+
+// Проверяет, что адрес отправителя from находится в домене подписи domain или его поддомене
+// (нестрогое выравнивание DMARC, RFC 7489, раздел 3.1.1)
+func CheckDKIMDomain(domain string, from string) error {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	fromDomain := strings.ToLower(address.Address[strings.LastIndex(address.Address, "@")+1:])
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if fromDomain != domain && !strings.HasSuffix(fromDomain, "."+domain) {
+		return fmt.Errorf("%w: %s is not in %s", ErrDKIMDomainMismatch, address.Address, domain)
+	}
+	return nil
+}
+
+// Разбирает закрытый ключ DKIM в PEM: RSA (PKCS #1 или PKCS #8) или Ed25519 (PKCS #8)
+func ParseDKIMPrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no pem block found", ErrInvalidDKIMOptions)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDKIMOptions, err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported private key type %T", ErrInvalidDKIMOptions, key)
+	}
+}
+
+// Разделяет сообщение на заголовки (с CRLF последнего заголовка) и тело. Одиночные LF приводятся к CRLF
+func splitMessage(msg []byte) (string, []byte) {
+	normalized := strings.ReplaceAll(strings.ReplaceAll(string(msg), "\r\n", "\n"), "\n", "\r\n")
+	header, body, found := strings.Cut(normalized, "\r\n\r\n")
+	if !found {
+		return normalized, nil
+	}
+	return header + "\r\n", []byte(body)
+}
+
+// Разбирает заголовки на поля вместе со строками продолжения, без завершающего CRLF
+func parseHeaderFields(header string) []string {
+	fields := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(header, "\r\n"), "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+func fieldName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimRight(name, " \t")
+}
+
+// Канонизация заголовка relaxed (RFC 6376, раздел 3.4.2), без завершающего CRLF
+func canonicalizeHeaderRelaxed(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + strings.TrimSpace(collapseWhitespace(value))
+}
+
+// Канонизация тела relaxed (RFC 6376, раздел 3.4.4)
+func canonicalizeBodyRelaxed(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(collapseWhitespace(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// Заменяет последовательности пробелов и табуляций одним пробелом
+func collapseWhitespace(value string) string {
+	builder := strings.Builder{}
+	space := false
+	for _, r := range value {
+		if r == ' ' || r == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			builder.WriteByte(' ')
+			space = false
+		}
+		builder.WriteRune(r)
+	}
+	if space {
+		builder.WriteByte(' ')
+	}
+	return builder.String()
+}
+
+// Собирает теги через "; ", перенося строку перед тегом, который не помещается в dkimLineLength
+func foldDKIMTags(prefix string, tags []string) string {
+	builder := strings.Builder{}
+	builder.WriteString(prefix)
+	lineLength := len(prefix)
+	for i, tag := range tags {
+		if i > 0 {
+			builder.WriteString(";")
+			lineLength++
+			if lineLength+len(tag)+1 > dkimLineLength {
+				builder.WriteString("\r\n\t")
+				lineLength = 1
+			} else {
+				builder.WriteString(" ")
+				lineLength++
+			}
+		}
+		builder.WriteString(tag)
+		lineLength += len(tag)
+	}
+	return builder.String()
+}
+
+// Переносит значение подписи на строки не длиннее dkimLineLength, offset - длина уже занятой части первой строки.
+// Пробелы в значении b= игнорируются при проверке
+func foldDKIMSignature(signature string, offset int) string {
+	builder := strings.Builder{}
+	available := dkimLineLength - offset
+	for len(signature) > 0 {
+		if available <= 0 {
+			builder.WriteString("\r\n\t")
+			available = dkimLineLength - 1
+		}
+		chunk := signature[:min(available, len(signature))]
+		signature = signature[len(chunk):]
+		builder.WriteString(chunk)
+		available = 0
+	}
+	return builder.String()
+}
+
+func lastLine(value string) string {
+	if i := strings.LastIndex(value, "\r\n"); i >= 0 {
+		return value[i+2:]
+	}
+	return value
+}
@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDKIMTransport(t *testing.T, domain string) *DKIMTransport {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	transport, err := NewDKIMTransport(nil, DKIMOptions{Domain: domain, Selector: "mail", PrivateKey: key})
+	if err != nil {
+		t.Fatalf("failed to build dkim transport: %v", err)
+	}
+	return transport.(*DKIMTransport)
+}
+
+func TestCheckDKIMDomain(t *testing.T) {
+	tests := map[string]struct {
+		from    string
+		aligned bool
+	}{
+		"same domain":         {"noreply@example.com", true},
+		"subdomain":           {"noreply@mail.example.com", true},
+		"display name":        {"Example <noreply@example.com>", true},
+		"case":                {"noreply@Example.COM", true},
+		"other domain":        {"noreply@example.org", false},
+		"lookalike domain":    {"noreply@notexample.com", false},
+		"parent of d= domain": {"noreply@com", false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := CheckDKIMDomain("example.com", test.from)
+			if test.aligned && err != nil {
+				t.Fatalf("expected %q to be aligned, got %v", test.from, err)
+			}
+			if !test.aligned && !errors.Is(err, ErrDKIMDomainMismatch) {
+				t.Fatalf("expected ErrDKIMDomainMismatch for %q, got %v", test.from, err)
+			}
+		})
+	}
+}
+
+func TestDKIMSignChecksFromDomain(t *testing.T) {
+	transport := newTestDKIMTransport(t, "example.com")
+	message := func(from string) []byte {
+		return []byte("From: " + from + "\r\nTo: alice@example.org\r\nSubject: Hello\r\n\r\nHello\r\n")
+	}
+
+	signature, err := transport.Sign(message("Example <noreply@mail.example.com>"), time.Now())
+	if err != nil {
+		t.Fatalf("failed to sign aligned message: %v", err)
+	}
+	if !strings.Contains(signature, "d=example.com") {
+		t.Fatalf("unexpected signature: %q", signature)
+	}
+
+	_, err = transport.Sign(message("noreply@example.org"), time.Now())
+	if !errors.Is(err, ErrDKIMDomainMismatch) {
+		t.Fatalf("expected ErrDKIMDomainMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package mailer
+
+import "errors"
+
+var (
+	ErrInvalidOptions      = errors.New("invalid smtp transport options")
+	ErrStartTLSUnsupported = errors.New("smtp server does not support starttls")
+	ErrAuthUnsupported     = errors.New("smtp server does not support authentication")
+	ErrUnencryptedAuth     = errors.New("refusing to send credentials over unencrypted connection")
+	ErrWrongHost           = errors.New("smtp server name does not match configured host")
+	ErrUnexpectedChallenge = errors.New("unexpected smtp auth challenge")
+	ErrInvalidMessage      = errors.New("invalid mail message")
+	ErrInvalidTemplates    = errors.New("invalid mail templates")
+	ErrTemplateNotFound    = errors.New("mail template not found")
+	ErrInvalidDKIMOptions  = errors.New("invalid dkim options")
+	ErrDKIMDomainMismatch  = errors.New("sender domain is not covered by dkim domain")
+)
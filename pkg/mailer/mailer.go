@@ -2,44 +2,53 @@ package mailer
 
 import (
 	"fmt"
-	"net/smtp"
+	"net/mail"
+	"time"
 )
 
 const (
+	// SMTP сервер, который использовался до появления настроек транспорта. Остается значением по умолчанию
 	GmailSMTPHost = "smtp.gmail.com"
-	GmailSMTPPort = 587 
 )
 
-type GmailSMTPMailer struct {
-	From string
-	Auth smtp.Auth
+type Mailer interface {
+	// Собирает и отправляет письмо всем его получателям (To и Cc)
+	SendMail(msg *Message) error
 }
 
-type Mailer interface {
-	// Отправляет сообщение одному получателю
-	SendMail(to string, subject string, message string) error
+// Mailer, отправляющий письма через транспорт (см. Transport)
+type TransportMailer struct {
+	// Отправитель писем, в которых From не задан
+	From      string
+	Transport Transport
 }
 
-func (m *GmailSMTPMailer) SendMail(to string, subject string, message string) error {
-	msg := fmt.Sprintf(
-		"To: %s\r\nSubject: %s\r\n\r\n%s\r\n",
-		to,
-		subject,
-		message,
-	)
-	return smtp.SendMail(
-		fmt.Sprintf("%s:%d", GmailSMTPHost, GmailSMTPPort),
-		m.Auth,
-		m.From,
-		[]string{to},
-		[]byte(msg),
-	)
+func (m *TransportMailer) SendMail(msg *Message) error {
+	message := *msg
+	if message.From == "" {
+		message.From = m.From
+	}
+
+	from, err := mail.ParseAddress(message.From)
+	if err != nil {
+		return fmt.Errorf("%w: from: %w", ErrInvalidMessage, err)
+	}
+	recipients, err := message.Recipients()
+	if err != nil {
+		return err
+	}
+	data, err := message.Bytes(time.Now())
+	if err != nil {
+		return err
+	}
+
+	return m.Transport.Send(from.Address, recipients, data)
 }
 
-// Создает экземпляет Mailer работающий через smtp.gmail.com
-func NewMailer(from string, pass string) Mailer {
-	return &GmailSMTPMailer{
-		From: from,
-		Auth: smtp.PlainAuth("", from, pass, GmailSMTPHost),
+// Создает экземпляр Mailer, отправляющий письма от адреса from через transport
+func NewMailer(from string, transport Transport) Mailer {
+	return &TransportMailer{
+		From:      from,
+		Transport: transport,
 	}
 }
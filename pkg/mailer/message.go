@@ -0,0 +1,279 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Длина строки base64 во вложениях (RFC 2045, раздел 6.8)
+const base64LineLength = 76
+
+// Письмо. Должно содержать хотя бы один из Text и HTML; если заданы оба, собирается multipart/alternative,
+// с вложениями - multipart/mixed. Адреса передаются в формате RFC 5322 ("Имя <user@example.com>" или просто адрес),
+// имена и тема кодируются по RFC 2047
+type Message struct {
+	// Отправитель. Пустой - адрес по умолчанию Mailer
+	From    string
+	To      []string
+	Cc      []string
+	ReplyTo string
+	Subject string
+	// Текстовая версия письма
+	Text string
+	// HTML версия письма
+	HTML        string
+	Attachments []Attachment
+}
+
+// Вложение письма. Пустой ContentType определяется по расширению имени файла,
+// если не удалось - application/octet-stream
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Возвращает адреса всех получателей письма (To и Cc) без имен
+func (m *Message) Recipients() ([]string, error) {
+	recipients := []string{}
+	for _, list := range [][]string{m.To, m.Cc} {
+		addresses, err := parseAddressList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range addresses {
+			recipients = append(recipients, address.Address)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("%w: no recipients", ErrInvalidMessage)
+	}
+	return recipients, nil
+}
+
+// Собирает письмо в формате RFC 5322 с заголовками Date, Message-ID и MIME-Version.
+// Текстовые части кодируются quoted-printable в UTF-8, вложения - base64
+func (m *Message) Bytes(now time.Time) ([]byte, error) {
+	if m.Text == "" && m.HTML == "" {
+		return nil, fmt.Errorf("%w: text or html body is required", ErrInvalidMessage)
+	}
+
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("%w: from: %w", ErrInvalidMessage, err)
+	}
+	messageID, err := newMessageID(from.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("From", from.String())
+	for name, list := range map[string][]string{"To": m.To, "Cc": m.Cc} {
+		if len(list) == 0 {
+			continue
+		}
+		addresses, err := parseAddressList(list)
+		if err != nil {
+			return nil, err
+		}
+		header.Set(name, formatAddressList(addresses))
+	}
+	if m.ReplyTo != "" {
+		replyTo, err := mail.ParseAddress(m.ReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("%w: reply-to: %w", ErrInvalidMessage, err)
+		}
+		header.Set("Reply-To", replyTo.String())
+	}
+	// длинная тема кодируется несколькими encoded-word, каждое переносится на свою строку заголовка
+	header.Set("Subject", strings.ReplaceAll(mime.BEncoding.Encode("utf-8", m.Subject), "?= =?", "?=\r\n =?"))
+	header.Set("Date", now.Format(time.RFC1123Z))
+	header.Set("Message-ID", messageID)
+	header.Set("MIME-Version", "1.0")
+
+	bodyHeader, body, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if len(m.Attachments) == 0 {
+		for name, values := range bodyHeader {
+			header[name] = values
+		}
+		writeHeader(buf, header)
+		buf.Write(body)
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(buf)
+	header.Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixed.Boundary()}))
+	writeHeader(buf, header)
+
+	part, err := mixed.CreatePart(bodyHeader)
+	if err != nil {
+		return nil, err
+	}
+	_, err = part.Write(body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range m.Attachments {
+		err = writeAttachment(mixed, attachment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = mixed.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Собирает тело письма без вложений: одну текстовую часть или multipart/alternative из текстовой и HTML версий.
+// Возвращает заголовки Content-Type и Content-Transfer-Encoding тела и само тело
+func (m *Message) body() (textproto.MIMEHeader, []byte, error) {
+	buf := &bytes.Buffer{}
+	if m.Text == "" || m.HTML == "" {
+		contentType, content := "text/plain", m.Text
+		if m.HTML != "" {
+			contentType, content = "text/html", m.HTML
+		}
+		err := writeQuotedPrintable(buf, content)
+		if err != nil {
+			return nil, nil, err
+		}
+		return textPartHeader(contentType), buf.Bytes(), nil
+	}
+
+	alternative := multipart.NewWriter(buf)
+	for _, body := range []struct{ contentType, content string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		part, err := alternative.CreatePart(textPartHeader(body.contentType))
+		if err != nil {
+			return nil, nil, err
+		}
+		err = writeQuotedPrintable(part, body.content)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	err := alternative.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative.Boundary()}))
+	return header, buf.Bytes(), nil
+}
+
+func textPartHeader(contentType string) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	}
+}
+
+func writeAttachment(w *multipart.Writer, attachment Attachment) error {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(attachment.Filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+	})
+	if err != nil {
+		return err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > 0 {
+		line := encoded[:min(base64LineLength, len(encoded))]
+		encoded = encoded[len(line):]
+		_, err = io.WriteString(part, line+"\r\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Записывает заголовки письма и пустую строку после них. textproto.MIMEHeader не хранит порядок,
+// поэтому заголовки записываются в фиксированном порядке
+func writeHeader(w *bytes.Buffer, header textproto.MIMEHeader) {
+	order := []string{"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"}
+	for _, name := range order {
+		for _, value := range header.Values(name) {
+			fmt.Fprintf(w, "%s: %s\r\n", name, value)
+		}
+	}
+	w.WriteString("\r\n")
+}
+
+// Кодирует текст quoted-printable. Переводы строк приводятся к CRLF
+func writeQuotedPrintable(w io.Writer, content string) error {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	qp := quotedprintable.NewWriter(w)
+	_, err := io.WriteString(qp, strings.ReplaceAll(content, "\n", "\r\n"))
+	if err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func parseAddressList(list []string) ([]*mail.Address, error) {
+	addresses := make([]*mail.Address, 0, len(list))
+	for _, value := range list {
+		address, err := mail.ParseAddress(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidMessage, value, err)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+func formatAddressList(addresses []*mail.Address) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		formatted = append(formatted, address.String())
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// Генерирует Message-ID со случайной частью и доменом адреса отправителя
+func newMessageID(fromAddress string) (string, error) {
+	random := make([]byte, 16)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+
+	domain := "localhost"
+	if at := strings.LastIndex(fromAddress, "@"); at >= 0 {
+		domain = fromAddress[at+1:]
+	}
+	return "<" + hex.EncodeToString(random) + "@" + domain + ">", nil
+}
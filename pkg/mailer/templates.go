@@ -0,0 +1,275 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+
+	"golang.org/x/text/language"
+)
+
+// Язык писем по умолчанию
+const DefaultLocale = "ru"
+
+// Общий HTML макет писем языка. Шаблоны <название>.html определяют в нем блок content
+const htmlLayoutFile = "layout.html"
+
+// Встроенные шаблоны писем: templates/<язык>/<название>.txt и необязательный templates/<язык>/<название>.html
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// Шаблоны писем на нескольких языках.
+//
+// Шаблон <язык>/<название>.txt (text/template) определяет блок subject с темой письма, остальное содержимое -
+// текстовая версия письма. Шаблон <язык>/<название>.html (html/template) необязателен и определяет блок content,
+// который выводится в общем макете <язык>/layout.html
+type Templates interface {
+	// Собирает письмо по шаблону name на языке, ближайшем к locale. Если шаблона на этом языке нет,
+	// используется язык по умолчанию. Получатели и отправитель в письме не заполняются
+	Render(name string, locale string, data any) (*Message, error)
+	// Возвращает поддерживаемый язык, ближайший к locale (тег BCP 47 или значение Accept-Language).
+	// Если подходящего нет - язык по умолчанию и false
+	MatchLocale(locale string) (string, bool)
+}
+
+type ImplTemplates struct {
+	DefaultLocale string
+	// Шаблоны по языкам
+	Locales map[string]*LocaleTemplates
+	// Поддерживаемые языки, язык по умолчанию первый (индексы совпадают с Matcher)
+	Supported []string
+	Matcher   language.Matcher
+}
+
+// Шаблоны писем одного языка по названиям
+type LocaleTemplates struct {
+	Text map[string]*texttemplate.Template
+	HTML map[string]*htmltemplate.Template
+}
+
+// Конструктор шаблонов писем. Загружает встроенные шаблоны и шаблоны из каталога overrideDir (если задан),
+// файлы каталога заменяют встроенные файлы с тем же путем и могут добавлять новые языки.
+// Пустой defaultLocale заменяется DefaultLocale. Все шаблоны разбираются сразу, ошибка в любом из них возвращается
+func NewTemplates(overrideDir string, defaultLocale string) (Templates, error) {
+	if defaultLocale == "" {
+		defaultLocale = DefaultLocale
+	}
+
+	base, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	layers := []fs.FS{base}
+	if overrideDir != "" {
+		info, err := os.Stat(overrideDir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalidTemplates, overrideDir)
+		}
+		// каталог оператора проверяется первым
+		layers = []fs.FS{os.DirFS(overrideDir), base}
+	}
+
+	locales, err := listEntries(layers, ".", true)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(locales, defaultLocale) {
+		return nil, fmt.Errorf("%w: no templates for default locale %q", ErrInvalidTemplates, defaultLocale)
+	}
+
+	templates := &ImplTemplates{
+		DefaultLocale: defaultLocale,
+		Locales:       map[string]*LocaleTemplates{},
+		Supported:     []string{defaultLocale},
+	}
+	tags := []language.Tag{}
+	for _, locale := range locales {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid locale directory %q: %w", ErrInvalidTemplates, locale, err)
+		}
+
+		localeTemplates, err := loadLocaleTemplates(layers, locale)
+		if err != nil {
+			return nil, err
+		}
+		templates.Locales[locale] = localeTemplates
+
+		if locale == defaultLocale {
+			tags = append([]language.Tag{tag}, tags...)
+		} else {
+			templates.Supported = append(templates.Supported, locale)
+			tags = append(tags, tag)
+		}
+	}
+	templates.Matcher = language.NewMatcher(tags)
+
+	return templates, nil
+}
+
+func (t *ImplTemplates) Render(name string, locale string, data any) (*Message, error) {
+	matched, _ := t.MatchLocale(locale)
+	localeTemplates := t.Locales[matched]
+	textTemplate, ok := localeTemplates.Text[name]
+	if !ok {
+		localeTemplates = t.Locales[t.DefaultLocale]
+		textTemplate, ok = localeTemplates.Text[name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	subject := &bytes.Buffer{}
+	err := textTemplate.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+	text := &bytes.Buffer{}
+	err = textTemplate.Execute(text, data)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &Message{
+		// тема должна быть одной строкой, переводы строк шаблона заменяются пробелами
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}
+
+	if htmlTemplate, ok := localeTemplates.HTML[name]; ok {
+		html := &bytes.Buffer{}
+		err = htmlTemplate.Execute(html, data)
+		if err != nil {
+			return nil, err
+		}
+		message.HTML = html.String()
+	}
+
+	return message, nil
+}
+
+func (t *ImplTemplates) MatchLocale(locale string) (string, bool) {
+	desired, _, err := language.ParseAcceptLanguage(locale)
+	if err != nil || len(desired) == 0 {
+		return t.DefaultLocale, false
+	}
+
+	_, index, confidence := t.Matcher.Match(desired...)
+	if confidence == language.No {
+		return t.DefaultLocale, false
+	}
+	return t.Supported[index], true
+}
+
+// Разбирает все шаблоны языка. Названия шаблонов определяются по файлам .txt
+func loadLocaleTemplates(layers []fs.FS, locale string) (*LocaleTemplates, error) {
+	files, err := listEntries(layers, locale, false)
+	if err != nil {
+		return nil, err
+	}
+
+	localeTemplates := &LocaleTemplates{
+		Text: map[string]*texttemplate.Template{},
+		HTML: map[string]*htmltemplate.Template{},
+	}
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file, ".txt")
+		if !ok {
+			continue
+		}
+
+		content, err := readLayered(layers, path.Join(locale, file))
+		if err != nil {
+			return nil, err
+		}
+		textTemplate, err := texttemplate.New(name).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s/%s: %w", ErrInvalidTemplates, locale, file, err)
+		}
+		if textTemplate.Lookup("subject") == nil {
+			return nil, fmt.Errorf("%w: %s/%s does not define subject", ErrInvalidTemplates, locale, file)
+		}
+		localeTemplates.Text[name] = textTemplate
+
+		if !slices.Contains(files, name+".html") {
+			continue
+		}
+		htmlTemplate, err := loadHTMLTemplate(layers, locale, name)
+		if err != nil {
+			return nil, err
+		}
+		localeTemplates.HTML[name] = htmlTemplate
+	}
+	return localeTemplates, nil
+}
+
+func loadHTMLTemplate(layers []fs.FS, locale string, name string) (*htmltemplate.Template, error) {
+	layout, err := readLayered(layers, path.Join(locale, htmlLayoutFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s/%s.html requires %s/%s", ErrInvalidTemplates, locale, name, locale, htmlLayoutFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := readLayered(layers, path.Join(locale, name+".html"))
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTemplate, err := htmltemplate.New(name).Option("missingkey=error").Parse(string(layout))
+	if err == nil {
+		htmlTemplate, err = htmlTemplate.Parse(string(content))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s/%s.html: %w", ErrInvalidTemplates, locale, name, err)
+	}
+	if htmlTemplate.Lookup("content") == nil {
+		return nil, fmt.Errorf("%w: %s/%s.html does not define content", ErrInvalidTemplates, locale, name)
+	}
+	return htmlTemplate, nil
+}
+
+// Возвращает объединенный список каталогов (dirs) или файлов каталога dir всех слоев
+func listEntries(layers []fs.FS, dir string, dirs bool) ([]string, error) {
+	names := []string{}
+	for _, layer := range layers {
+		entries, err := fs.ReadDir(layer, dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() == dirs && !slices.Contains(names, entry.Name()) {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Читает файл из первого слоя, в котором он есть
+func readLayered(layers []fs.FS, name string) ([]byte, error) {
+	for _, layer := range layers {
+		content, err := fs.ReadFile(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return content, err
+	}
+	return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+}
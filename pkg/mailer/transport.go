@@ -0,0 +1,232 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// Режимы шифрования соединения с SMTP сервером
+const (
+	// Соединение без шифрования, повышаемое командой STARTTLS (обычно порт 587)
+	SecuritySTARTTLS = "starttls"
+	// Соединение сразу по TLS (обычно порт 465)
+	SecurityTLS = "tls"
+	// Соединение без шифрования, только для локального релея
+	SecurityNone = "none"
+)
+
+// Механизмы аутентификации SMTP
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+	AuthNone    = "none"
+)
+
+const (
+	DefaultSTARTTLSPort = 587
+	DefaultTLSPort      = 465
+)
+
+var (
+	// Таймаут подключения и всего SMTP диалога по умолчанию
+	DefaultSMTPTimeout = 30 * time.Second
+)
+
+// Способ доставки готового сообщения
+type Transport interface {
+	// Отправляет сообщение msg (заголовки и тело в формате RFC 5322) от from получателям to
+	Send(from string, to []string, msg []byte) error
+}
+
+// Параметры подключения к SMTP серверу
+type SMTPOptions struct {
+	// Адрес SMTP сервера
+	Host string
+	// Порт SMTP сервера. По умолчанию DefaultTLSPort для SecurityTLS и DefaultSTARTTLSPort для остальных
+	Port int
+	// Режим шифрования (см. константы Security*). По умолчанию SecuritySTARTTLS
+	Security string
+	// Механизм аутентификации (см. константы Auth*). По умолчанию AuthPlain, если задан пароль, иначе AuthNone.
+	// PLAIN и LOGIN не отправляют пароль по незашифрованному соединению, кроме соединения с localhost
+	Auth     string
+	Username string
+	Password string
+	// PEM сертификаты доверенных CA. Пустой - системные
+	RootCAs []byte
+	// Имя, передаваемое в EHLO/HELO. Пустое - localhost
+	HeloName string
+	// Таймаут подключения и всего SMTP диалога
+	Timeout time.Duration
+}
+
+// Транспорт, отправляющий сообщения через SMTP сервер. Для каждого сообщения открывается новое соединение
+type SMTPTransport struct {
+	Options   SMTPOptions
+	TLSConfig *tls.Config
+}
+
+// Конструктор SMTP транспорта. Нулевые значения порта, режима шифрования, механизма аутентификации и таймаута
+// заменяются значениями по умолчанию
+func NewSMTPTransport(options SMTPOptions) (Transport, error) {
+	if options.Host == "" {
+		return nil, fmt.Errorf("%w: host is required", ErrInvalidOptions)
+	}
+	if options.Security == "" {
+		options.Security = SecuritySTARTTLS
+	}
+	if options.Port == 0 {
+		options.Port = DefaultSTARTTLSPort
+		if options.Security == SecurityTLS {
+			options.Port = DefaultTLSPort
+		}
+	}
+	if options.Auth == "" {
+		options.Auth = AuthNone
+		if options.Password != "" {
+			options.Auth = AuthPlain
+		}
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultSMTPTimeout
+	}
+
+	switch options.Security {
+	case SecuritySTARTTLS, SecurityTLS, SecurityNone:
+	default:
+		return nil, fmt.Errorf("%w: unknown security mode %q", ErrInvalidOptions, options.Security)
+	}
+	switch options.Auth {
+	case AuthPlain, AuthLogin, AuthCRAMMD5, AuthNone:
+	default:
+		return nil, fmt.Errorf("%w: unknown auth mechanism %q", ErrInvalidOptions, options.Auth)
+	}
+
+	tlsConfig := &tls.Config{ServerName: options.Host, MinVersion: tls.VersionTLS12}
+	if len(options.RootCAs) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(options.RootCAs) {
+			return nil, fmt.Errorf("%w: no certificates found in root CAs", ErrInvalidOptions)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &SMTPTransport{
+		Options:   options,
+		TLSConfig: tlsConfig,
+	}, nil
+}
+
+func (t *SMTPTransport) Send(from string, to []string, msg []byte) error {
+	client, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if t.Options.Security == SecuritySTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return ErrStartTLSUnsupported
+		}
+		err = client.StartTLS(t.TLSConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if auth := t.auth(); auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return ErrAuthUnsupported
+		}
+		err = client.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(from)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// Открывает соединение с сервером и выполняет EHLO. Таймаут действует на весь диалог
+func (t *SMTPTransport) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(t.Options.Host, strconv.Itoa(t.Options.Port))
+	dialer := &net.Dialer{Timeout: t.Options.Timeout}
+
+	var conn net.Conn
+	var err error
+	if t.Options.Security == SecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, t.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(t.Options.Timeout))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, t.Options.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if t.Options.HeloName != "" {
+		err = client.Hello(t.Options.HeloName)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (t *SMTPTransport) auth() smtp.Auth {
+	switch t.Options.Auth {
+	case AuthPlain:
+		return smtp.PlainAuth("", t.Options.Username, t.Options.Password, t.Options.Host)
+	case AuthLogin:
+		return &loginAuth{
+			username: t.Options.Username,
+			password: t.Options.Password,
+			host:     t.Options.Host,
+		}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(t.Options.Username, t.Options.Password)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,385 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testSMTPUsername = "mailer"
+	testSMTPPassword = "smtp password"
+)
+
+// Письмо, принятое тестовым SMTP сервером
+type testSMTPMessage struct {
+	From string
+	To   []string
+	Data string
+	// Было ли соединение зашифровано при передаче письма
+	TLS bool
+}
+
+// SMTP сервер в памяти. Поддерживает STARTTLS, неявный TLS, AUTH PLAIN, LOGIN и CRAM-MD5 и принимает
+// письма с учетными данными testSMTPUsername и testSMTPPassword
+type testSMTPServer struct {
+	listener    net.Listener
+	tlsConfig   *tls.Config
+	implicitTLS bool
+	startTLS    bool
+	rootCAs     []byte
+
+	mu       sync.Mutex
+	auths    []string
+	messages []testSMTPMessage
+}
+
+// Запускает сервер на адресе host. implicitTLS включает TLS сразу после подключения,
+// startTLS - поддержку команды STARTTLS
+func newTestSMTPServer(t *testing.T, host string, implicitTLS bool, startTLS bool) *testSMTPServer {
+	t.Helper()
+	certificate, rootCAs := newTestSMTPCertificate(t)
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Skipf("failed to listen on %s: %v", host, err)
+	}
+	server := &testSMTPServer{
+		listener:    listener,
+		tlsConfig:   &tls.Config{Certificates: []tls.Certificate{certificate}},
+		implicitTLS: implicitTLS,
+		startTLS:    startTLS,
+		rootCAs:     rootCAs,
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// Генерирует самоподписанный сертификат для loopback адресов. Возвращает его и PEM для RootCAs
+func newTestSMTPCertificate(t *testing.T) (tls.Certificate, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func (s *testSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testSMTPServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	encrypted := false
+	if s.implicitTLS {
+		conn = tls.Server(conn, s.tlsConfig)
+		encrypted = true
+	}
+	text := textproto.NewConn(conn)
+
+	var message testSMTPMessage
+	_ = text.PrintfLine("220 localhost ESMTP test")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			lines := []string{"localhost"}
+			if s.startTLS && !encrypted {
+				lines = append(lines, "STARTTLS")
+			}
+			lines = append(lines, "AUTH PLAIN LOGIN CRAM-MD5")
+			for i, reply := range lines {
+				separator := "-"
+				if i == len(lines)-1 {
+					separator = " "
+				}
+				_ = text.PrintfLine("250%s%s", separator, reply)
+			}
+		case "STARTTLS":
+			if !s.startTLS || encrypted {
+				_ = text.PrintfLine("502 starttls not available")
+				continue
+			}
+			_ = text.PrintfLine("220 ready to start tls")
+			conn = tls.Server(conn, s.tlsConfig)
+			text = textproto.NewConn(conn)
+			encrypted = true
+		case "AUTH":
+			if s.authenticate(text, argument) {
+				_ = text.PrintfLine("235 authentication successful")
+			} else {
+				_ = text.PrintfLine("535 authentication failed")
+			}
+		case "MAIL":
+			message = testSMTPMessage{From: smtpPath(argument), TLS: encrypted}
+			_ = text.PrintfLine("250 ok")
+		case "RCPT":
+			message.To = append(message.To, smtpPath(argument))
+			_ = text.PrintfLine("250 ok")
+		case "DATA":
+			_ = text.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.Data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			_ = text.PrintfLine("250 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			return
+		default:
+			_ = text.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+// Выполняет диалог AUTH и проверяет учетные данные. Механизм сохраняется для проверки в тестах
+func (s *testSMTPServer) authenticate(text *textproto.Conn, argument string) bool {
+	mechanism, initial, _ := strings.Cut(argument, " ")
+	mechanism = strings.ToUpper(mechanism)
+	s.mu.Lock()
+	s.auths = append(s.auths, mechanism)
+	s.mu.Unlock()
+
+	challenge := func(value string) (string, bool) {
+		_ = text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(value)))
+		line, err := text.ReadLine()
+		if err != nil {
+			return "", false
+		}
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		return string(decoded), err == nil
+	}
+
+	switch mechanism {
+	case "PLAIN":
+		var response string
+		if initial != "" {
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			if err != nil {
+				return false
+			}
+			response = string(decoded)
+		} else {
+			var ok bool
+			if response, ok = challenge(""); !ok {
+				return false
+			}
+		}
+		return response == "\x00"+testSMTPUsername+"\x00"+testSMTPPassword
+	case "LOGIN":
+		username, ok := challenge("Username:")
+		if !ok {
+			return false
+		}
+		password, ok := challenge("Password:")
+		return ok && username == testSMTPUsername && password == testSMTPPassword
+	case "CRAM-MD5":
+		nonce := "<" + strconv.FormatInt(time.Now().UnixNano(), 10) + "@localhost>"
+		response, ok := challenge(nonce)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(md5.New, []byte(testSMTPPassword))
+		mac.Write([]byte(nonce))
+		return response == testSMTPUsername+" "+hex.EncodeToString(mac.Sum(nil))
+	default:
+		return false
+	}
+}
+
+func (s *testSMTPServer) receivedAuths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.auths...)
+}
+
+func (s *testSMTPServer) receivedMessages() []testSMTPMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]testSMTPMessage{}, s.messages...)
+}
+
+// Извлекает адрес из аргумента MAIL FROM:<...> или RCPT TO:<...>
+func smtpPath(argument string) string {
+	_, path, _ := strings.Cut(argument, ":")
+	path, _, _ = strings.Cut(path, " ")
+	return strings.Trim(path, "<>")
+}
+
+func newTestSMTPTransport(t *testing.T, server *testSMTPServer, host string, security string, auth string, password string) Transport {
+	t.Helper()
+	transport, err := NewSMTPTransport(SMTPOptions{
+		Host:     host,
+		Port:     server.port(),
+		Security: security,
+		Auth:     auth,
+		Username: testSMTPUsername,
+		Password: password,
+		RootCAs:  server.rootCAs,
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to build transport: %v", err)
+	}
+	return transport
+}
+
+const testSMTPMessageData = "Subject: test\r\n\r\nhello\r\n"
+
+func TestSMTPTransportSend(t *testing.T) {
+	tests := []struct {
+		security string
+		auth     string
+	}{
+		{SecuritySTARTTLS, AuthPlain},
+		{SecuritySTARTTLS, AuthLogin},
+		{SecuritySTARTTLS, AuthCRAMMD5},
+		{SecurityTLS, AuthPlain},
+		{SecurityTLS, AuthLogin},
+		{SecurityTLS, AuthCRAMMD5},
+		// без шифрования PLAIN и LOGIN разрешены только для localhost
+		{SecurityNone, AuthPlain},
+		{SecurityNone, AuthLogin},
+		{SecurityNone, AuthCRAMMD5},
+		{SecurityNone, AuthNone},
+	}
+	for _, test := range tests {
+		t.Run(test.security+"/"+test.auth, func(t *testing.T) {
+			server := newTestSMTPServer(t, "127.0.0.1", test.security == SecurityTLS, test.security == SecuritySTARTTLS)
+			transport := newTestSMTPTransport(t, server, "127.0.0.1", test.security, test.auth, testSMTPPassword)
+
+			err := transport.Send("noreply@example.com", []string{"alice@example.com", "bob@example.com"}, []byte(testSMTPMessageData))
+			if err != nil {
+				t.Fatalf("failed to send: %v", err)
+			}
+
+			messages := server.receivedMessages()
+			if len(messages) != 1 {
+				t.Fatalf("expected a single message, got %d", len(messages))
+			}
+			message := messages[0]
+			if message.From != "noreply@example.com" || strings.Join(message.To, ",") != "alice@example.com,bob@example.com" {
+				t.Fatalf("unexpected envelope %+v", message)
+			}
+			if message.Data != strings.ReplaceAll(testSMTPMessageData, "\r\n", "\n") {
+				t.Fatalf("unexpected data %q", message.Data)
+			}
+			if message.TLS != (test.security != SecurityNone) {
+				t.Fatalf("expected tls %v, got %v", test.security != SecurityNone, message.TLS)
+			}
+
+			wantAuths := []string{}
+			if test.auth != AuthNone {
+				wantAuths = []string{strings.ToUpper(test.auth)}
+			}
+			if auths := server.receivedAuths(); strings.Join(auths, ",") != strings.Join(wantAuths, ",") {
+				t.Fatalf("expected auth %v, got %v", wantAuths, auths)
+			}
+		})
+	}
+}
+
+func TestSMTPTransportWrongPassword(t *testing.T) {
+	for _, auth := range []string{AuthPlain, AuthLogin, AuthCRAMMD5} {
+		t.Run(auth, func(t *testing.T) {
+			server := newTestSMTPServer(t, "127.0.0.1", false, true)
+			transport := newTestSMTPTransport(t, server, "127.0.0.1", SecuritySTARTTLS, auth, "wrong password")
+
+			err := transport.Send("noreply@example.com", []string{"alice@example.com"}, []byte(testSMTPMessageData))
+			if err == nil {
+				t.Fatalf("expected an authentication error")
+			}
+			if messages := server.receivedMessages(); len(messages) != 0 {
+				t.Fatalf("expected no messages, got %d", len(messages))
+			}
+		})
+	}
+}
+
+func TestSMTPTransportRequiresStartTLS(t *testing.T) {
+	server := newTestSMTPServer(t, "127.0.0.1", false, false)
+	transport := newTestSMTPTransport(t, server, "127.0.0.1", SecuritySTARTTLS, AuthPlain, testSMTPPassword)
+
+	err := transport.Send("noreply@example.com", []string{"alice@example.com"}, []byte(testSMTPMessageData))
+	if !errors.Is(err, ErrStartTLSUnsupported) {
+		t.Fatalf("expected ErrStartTLSUnsupported, got %v", err)
+	}
+	if auths := server.receivedAuths(); len(auths) != 0 {
+		t.Fatalf("expected no auth without tls, got %v", auths)
+	}
+}
+
+func TestSMTPTransportRefusesUnencryptedAuth(t *testing.T) {
+	// 127.0.0.2 - loopback адрес, который не считается localhost: соединение с ним равносильно соединению
+	// с удаленным сервером без шифрования
+	for _, auth := range []string{AuthPlain, AuthLogin} {
+		t.Run(auth, func(t *testing.T) {
+			server := newTestSMTPServer(t, "127.0.0.2", false, false)
+			transport := newTestSMTPTransport(t, server, "127.0.0.2", SecurityNone, auth, testSMTPPassword)
+
+			err := transport.Send("noreply@example.com", []string{"alice@example.com"}, []byte(testSMTPMessageData))
+			if err == nil {
+				t.Fatalf("expected credentials not to be sent over an unencrypted connection")
+			}
+			if auth == AuthLogin && !errors.Is(err, ErrUnencryptedAuth) {
+				t.Fatalf("expected ErrUnencryptedAuth, got %v", err)
+			}
+			if auths := server.receivedAuths(); len(auths) != 0 {
+				t.Fatalf("expected no auth command, got %v", auths)
+			}
+			if messages := server.receivedMessages(); len(messages) != 0 {
+				t.Fatalf("expected no messages, got %d", len(messages))
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Модель одноразовой ссылки и кода для входа по email без пароля.
+// Запрос привязан к браузеру, из которого он был сделан, через cookie привязки
+type EmailLogin struct {
+	// uuid записи
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// uuid пользователя, для которого запрошен вход
+	UserID uuid.UUID `gorm:"type:uuid;index;not null"`
+	// sha256 хеш токена из ссылки
+	TokenHash string `gorm:"type:varchar(64);uniqueIndex;not null"`
+	// Хешированный при помощи bcrypt код из письма (см. app.HashToken)
+	CodeHash string `gorm:"type:varchar(60);not null"`
+	// sha256 хеш значения cookie привязки к браузеру
+	BindingHash string `gorm:"type:varchar(64);index;not null"`
+	// Количество попыток ввода кода
+	Attempts int `gorm:"not null;default:0"`
+	// Время, после которого ссылку и код нельзя использовать
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
+
+// Конструктор нового обьекта модели входа по email
+func NewEmailLogin(userID uuid.UUID, tokenHash string, codeHash string, bindingHash string, expiresAt time.Time) *EmailLogin {
+	return &EmailLogin{
+		ID:          uuid.New(),
+		UserID:      userID,
+		TokenHash:   tokenHash,
+		CodeHash:    codeHash,
+		BindingHash: bindingHash,
+		ExpiresAt:   expiresAt,
+	}
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Модель внешней личности пользователя: учетная запись у провайдера OpenID Connect или SAML, связанная с models.User
+type Identity struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// uuid пользователя, с которым связана личность
+	UserID uuid.UUID `gorm:"type:uuid;index;not null"`
+	// Название провайдера в настройках
+	Provider string `gorm:"type:varchar(64);uniqueIndex:idx_identities_provider_subject;not null"`
+	// Идентификатор пользователя у провайдера (sub или NameID)
+	Subject string `gorm:"type:varchar(255);uniqueIndex:idx_identities_provider_subject;not null"`
+	// Email, полученный от провайдера при связывании
+	Email string `gorm:"type:varchar(255)"`
+	// Время связывания личности с пользователем
+	LinkedAt time.Time `gorm:"not null"`
+}
+
+// Конструктор новой внешней личности пользователя, генерирует ее uuid
+func NewIdentity(userID uuid.UUID, provider string, subject string, email string) *Identity {
+	return &Identity{
+		ID:       uuid.New(),
+		UserID:   userID,
+		Provider: provider,
+		Subject:  subject,
+		Email:    email,
+		LinkedAt: time.Now(),
+	}
+}
+
+// Модель незавершенного входа или привязки личности через внешний провайдер: параметры запроса авторизации,
+// которые проверяются при возврате пользователя от провайдера
+type FederatedLoginSession struct {
+	// sha256 хеш случайного идентификатора сессии, который хранится в cookie
+	ID string `gorm:"type:varchar(64);primaryKey"`
+	// Название провайдера в настройках
+	Provider string `gorm:"type:varchar(64);not null"`
+	// uuid пользователя, к которому привязывается личность. nil для входа
+	UserID *uuid.UUID `gorm:"type:uuid"`
+	// sha256 хеш параметра state или RelayState
+	StateHash string `gorm:"type:varchar(64);not null"`
+	// nonce, который должен вернуться в ID токене, или ID запроса AuthnRequest SAML, который должен вернуться в InResponseTo
+	Nonce string `gorm:"type:varchar(64);not null"`
+	// code_verifier PKCE. Пустой для SAML
+	CodeVerifier string `gorm:"type:varchar(128)"`
+	// Время, после которого вход нельзя завершить
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Модель счетчика неудачных попыток входа с одного IP адреса
+type LoginFailure struct {
+	// IP адрес, с которого выполнялись попытки входа
+	IP string `gorm:"type:varchar(45);primaryKey"`
+	// Количество неудачных попыток в текущем окне
+	Attempts int `gorm:"not null"`
+	// Время последней неудачной попытки
+	LastFailedAt time.Time `gorm:"not null"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Модель кода авторизации OAuth, выданного в /authorize и обмениваемого на токены в /token
+type OAuthAuthorizationCode struct {
+	// sha256 хеш кода
+	CodeHash string `gorm:"type:varchar(64);primaryKey"`
+	// client_id клиента, которому выдан код
+	ClientID string `gorm:"type:varchar(64);not null"`
+	// uuid пользователя, разрешившего доступ
+	UserID uuid.UUID `gorm:"type:uuid;not null"`
+	// redirect URI из запроса авторизации, должен совпасть с переданным в /token
+	RedirectURI string `gorm:"type:text;not null"`
+	// Выданные scope через пробел
+	Scope string `gorm:"type:text"`
+	// nonce из запроса авторизации, передается в ID токен
+	Nonce string `gorm:"type:varchar(255)"`
+	// Время аутентификации пользователя, передается в ID токен как auth_time
+	AuthTime time.Time `gorm:"not null"`
+	// code_challenge PKCE
+	CodeChallenge string `gorm:"type:varchar(128);not null"`
+	// Метод PKCE (только S256)
+	CodeChallengeMethod string `gorm:"type:varchar(10);not null"`
+	// Время, после которого код нельзя обменять на токены
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Модель зарегистрированного OAuth клиента (приложения, которое получает доступ от имени пользователя)
+type OAuthClient struct {
+	// Идентификатор клиента (client_id)
+	ClientID string `gorm:"type:varchar(64);primaryKey"`
+	// Хешированный при помощи bcrypt секрет клиента (см. app.HashToken). Пустой для публичных клиентов
+	SecretHash string `gorm:"type:varchar(60)"`
+	// Название приложения
+	Name string `gorm:"type:varchar(255);not null"`
+	// Разрешенные redirect URI, сравниваются с запрошенным посимвольно
+	RedirectURIs []string `gorm:"type:text;serializer:json;not null"`
+	// Scope, которые клиент может запросить
+	Scopes []string `gorm:"type:text;serializer:json;not null"`
+	// Публичный клиент (SPA, мобильное приложение) не может хранить секрет и аутентифицируется только через PKCE
+	Public    bool `gorm:"not null;default:false"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Возвращает true, если redirectURI в точности совпадает с одним из зарегистрированных
+func (c *OAuthClient) HasRedirectURI(redirectURI string) bool {
+	return slices.Contains(c.RedirectURIs, redirectURI)
+}
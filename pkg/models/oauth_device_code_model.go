@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Состояния запроса авторизации устройства
+const (
+	DeviceCodeStatusPending  = "pending"
+	DeviceCodeStatusApproved = "approved"
+	DeviceCodeStatusDenied   = "denied"
+)
+
+// Модель запроса авторизации устройства (RFC 8628), выданного в /device/code.
+// Клиент опрашивает /token по device_code, пока пользователь не подтвердит или не отклонит user_code
+type OAuthDeviceCode struct {
+	// sha256 хеш device_code
+	DeviceCodeHash string `gorm:"type:varchar(64);primaryKey"`
+	// Код, который пользователь вводит на странице подтверждения, без разделителей
+	UserCode string `gorm:"type:varchar(16);uniqueIndex;not null"`
+	// client_id клиента, запросившего авторизацию
+	ClientID string `gorm:"type:varchar(64);not null"`
+	// Запрошенные scope через пробел
+	Scope string `gorm:"type:text"`
+	// Состояние запроса (см. константы DeviceCodeStatus*)
+	Status string `gorm:"type:varchar(16);not null;default:pending"`
+	// uuid пользователя, подтвердившего или отклонившего запрос
+	UserID *uuid.UUID `gorm:"type:uuid"`
+	// Время аутентификации пользователя, подтвердившего запрос
+	AuthTime *time.Time
+	// Минимальный интервал опроса /token в секундах, увеличивается при slow_down
+	Interval int `gorm:"not null"`
+	// Время последнего опроса /token
+	LastPolledAt *time.Time
+	// Время, после которого запрос недействителен
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Модель refresh токена, выданного OAuth клиенту. Запись удаляется при использовании токена (ротация)
+type OAuthRefreshToken struct {
+	// ID (jti) refresh токена
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// client_id клиента, которому выдан токен
+	ClientID string `gorm:"type:varchar(64);index;not null"`
+	// uuid пользователя
+	UserID uuid.UUID `gorm:"type:uuid;index;not null"`
+	// sha256 хеш токена
+	TokenHash string `gorm:"type:varchar(64);not null"`
+	// Выданные scope через пробел
+	Scope string `gorm:"type:text"`
+	// Время истечения токена
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Статусы письма в очереди отправки
+const (
+	// Письмо ожидает отправки или повторной попытки
+	OutboxStatusPending = "pending"
+	// Письмо отправлено
+	OutboxStatusSent = "sent"
+	// Попытки отправки исчерпаны, письмо отправится только после повтора администратором
+	OutboxStatusDead = "dead"
+)
+
+// Модель письма в очереди отправки (outbox). Письмо сохраняется в той же транзакции, что и изменение, о котором
+// оно сообщает, и отправляется фоновым обработчиком (см. outbox.Worker)
+type OutboxMessage struct {
+	// uuid записи
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// Название шаблона письма (см. mailer.Templates)
+	Template string `gorm:"type:varchar(64);not null"`
+	// Адрес получателя
+	Recipient string `gorm:"type:varchar(255);index;not null"`
+	Subject   string `gorm:"type:text;not null"`
+	// Зашифрованное сериализованное в JSON письмо (mailer.Message, см. outbox.NewMessage).
+	// Очищается после отправки, т.к. может содержать ссылки и коды входа
+	Payload string `gorm:"type:text;not null"`
+	// Статус письма (см. константы OutboxStatus*)
+	Status string `gorm:"type:varchar(16);not null;default:pending;index:idx_outbox_messages_status_next_attempt,priority:1"`
+	// Количество неудачных попыток отправки
+	Attempts int `gorm:"not null;default:0"`
+	// Ошибка последней неудачной попытки
+	LastError string `gorm:"type:text"`
+	// Время, не раньше которого будет выполнена следующая попытка отправки
+	NextAttemptAt time.Time `gorm:"not null;index:idx_outbox_messages_status_next_attempt,priority:2"`
+	// Время отправки письма
+	SentAt *time.Time `gorm:"index"`
+	// Время, после которого ссылки и коды в письме недействительны. Устаревшее письмо не отправляется
+	// и не может быть повторено администратором. nil - письмо не устаревает
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Конструктор нового обьекта модели письма в очереди. Письмо готово к отправке сразу
+func NewOutboxMessage(template string, recipient string, subject string, payload string) *OutboxMessage {
+	return &OutboxMessage{
+		ID:            uuid.New(),
+		Template:      template,
+		Recipient:     recipient,
+		Subject:       subject,
+		Payload:       payload,
+		Status:        OutboxStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Модель token bucket для ограничения частоты запросов (см. ratelimit.PostgresStore)
+type RateLimitBucket struct {
+	// Ключ bucket (маршрут, вид лимита и идентификатор клиента)
+	Key string `gorm:"type:varchar(255);primaryKey"`
+	// Текущее количество токенов на момент LastRefillAt
+	Tokens float64 `gorm:"not null"`
+	// Время последнего пополнения
+	LastRefillAt time.Time `gorm:"not null"`
+	// Скорость пополнения и емкость bucket при последнем обращении, нужны для удаления пополнившихся bucket'ов
+	Rate  float64 `gorm:"not null;default:0"`
+	Burst int     `gorm:"not null;default:0"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Модель одноразового кода восстановления доступа для аккаунтов с двухфакторной аутентификацией
+type RecoveryCode struct {
+	// uuid записи кода
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// uuid пользователя, которому принадлежит код
+	UserID uuid.UUID `gorm:"type:uuid;index;not null"`
+	// Хешированный при помощи bcrypt код (см. app.HashToken)
+	CodeHash string `gorm:"type:varchar(60);not null"`
+	// Время использования кода. nil, если код еще не использован
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Конструктор нового обьекта модели кода восстановления
+func NewRecoveryCode(userID uuid.UUID, codeHash string) *RecoveryCode {
+	return &RecoveryCode{
+		ID:       uuid.New(),
+		UserID:   userID,
+		CodeHash: codeHash,
+	}
+}
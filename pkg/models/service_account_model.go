@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// Модель сервисного аккаунта - OAuth клиента, который получает токены от своего имени (grant_type=client_credentials)
+// без участия пользователя
+type ServiceAccount struct {
+	// Идентификатор клиента (client_id)
+	ClientID string `gorm:"type:varchar(64);primaryKey"`
+	// Хешированный при помощи bcrypt секрет (см. app.HashToken)
+	SecretHash string `gorm:"type:varchar(60);not null"`
+	// Название сервиса или задачи
+	Name string `gorm:"type:varchar(255);not null"`
+	// Scope, которые аккаунт может запросить
+	Scopes    []string `gorm:"type:text;serializer:json;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -1,32 +1,103 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// Роли пользователей
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Источник учетных данных пользователя, созданного регистрацией. Пользователи,
+// созданные при первом входе через внешний бэкенд, хранят в AuthSource его название
+const AuthSourceLocal = "local"
+
+// Источник учетных данных пользователя, созданного при первом входе через внешний провайдер личности.
+// Пароля у такого пользователя нет, он входит только через связанные личности (см. Identity)
+const AuthSourceFederated = "federated"
+
 // Модель сущности пользователя
 type User struct {
 	// GUID (uuid) записи пользователя. Генерируется при создании через NewUser
-	UserID       uuid.UUID `gorm:"type:uuid;primaryKey"`
-	// Email пользователя
-	Email        string    `gorm:"type:varchar(50);uniqueIndex;not null"`
-	// Хешированный при помощи bcrypt пароль
-	Password     string    `gorm:"type:varchar(60);not null"`
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// Email пользователя в нижнем регистре (см. NormalizeEmail). Уникальность без учета регистра гарантирует
+	// индекс по LOWER(email), т.к. записи, созданные до приведения к нижнему регистру, могут его содержать
+	Email string `gorm:"type:varchar(50);uniqueIndex;uniqueIndex:idx_users_email_lower,expression:LOWER(email);not null"`
+	// Подтвержден ли email: пользователь перешел по ссылке из письма на этот адрес или адрес получен из доверенного бэкенда
+	EmailVerified bool `gorm:"not null;default:false"`
+	// Хешированный пароль, алгоритм определяется по префиксу хеша (bcrypt или argon2id)
+	Password string `gorm:"type:varchar(255);not null"`
+	// Версия перца, примененного к паролю перед хешированием (0 - без перца)
+	PepperVersion int `gorm:"not null;default:0"`
 	// Хешированный при помощи bcrupt refresh токен
-	RefreshToken string    `gorm:"type:varchar(60)"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	DeletedAt    gorm.DeletedAt `gorm:"index"`
+	RefreshToken string `gorm:"type:varchar(60)"`
+	// Новый email в нижнем регистре, ожидающий подтверждения. Пустой, если смена email не запрошена
+	PendingEmail string `gorm:"type:varchar(50)"`
+	// sha256 хеш токена подтверждения смены email (отправляется на новый адрес)
+	EmailConfirmToken string `gorm:"type:varchar(64);index"`
+	// sha256 хеш токена отмены смены email (отправляется на старый адрес)
+	EmailCancelToken string `gorm:"type:varchar(64);index"`
+	// Время, после которого запрос на смену email недействителен
+	EmailChangeExpiresAt *time.Time
+	// Бэкенд, проверяющий пароль пользователя (см. AuthSourceLocal и authenticator.Authenticator)
+	AuthSource string `gorm:"type:varchar(64);not null;default:local"`
+	// Активен ли пользователь. Неактивный пользователь не может войти, его сеансы отозваны (см. SCIM)
+	Active bool `gorm:"not null;default:true"`
+	// Идентификатор пользователя в системе, создавшей его через SCIM (например, табельный номер в HR системе)
+	ExternalID string `gorm:"type:varchar(255);index"`
+	// Язык писем пользователя (тег BCP 47, см. mailer.Templates). Пустой - язык по умолчанию
+	Locale string `gorm:"type:varchar(35)"`
+	// Роль пользователя (см. константы Role*)
+	Role string `gorm:"type:varchar(20);not null;default:user"`
+	// Количество неудачных попыток входа подряд
+	FailedLoginAttempts int `gorm:"not null;default:0"`
+	// Время последней неудачной попытки входа
+	LastFailedLoginAt *time.Time
+	// Время, до которого аккаунт заблокирован после превышения количества неудачных попыток входа
+	LockedUntil *time.Time
+	// sha256 хеш токена самостоятельной разблокировки аккаунта (отправляется в письме о блокировке)
+	UnlockToken string `gorm:"type:varchar(64);index"`
+	// Время, после которого токен разблокировки недействителен. Совпадает с окончанием блокировки:
+	// после него ссылка из письма уже не нужна
+	UnlockTokenExpiresAt *time.Time
+	// Зашифрованный TOTP секрет (см. encryption.Encryptor), привязан к UserID. Заполняется при начале подключения TOTP
+	TOTPSecret string `gorm:"type:varchar(255)"`
+	// Подключен ли TOTP (подтвержден кодом)
+	TOTPEnabled bool `gorm:"not null;default:false"`
+	// Номер последнего использованного временного шага TOTP, не дает повторно использовать код
+	TOTPLastUsedStep int64 `gorm:"not null;default:0"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	DeletedAt        gorm.DeletedAt `gorm:"index"`
 }
 
-// Конструктор нового обьекта модели пользователя. Наиболее предпочтителен, так как генерирует еще и его GUID
+// Конструктор нового обьекта модели пользователя. Наиболее предпочтителен, так как генерирует еще и его GUID.
+// Email приводится к виду, в котором он хранится (см. NormalizeEmail)
 func NewUser(email string, hashedPassword string) *User {
 	return &User{
-		UserID:   uuid.New(),
-		Email:    email,
-		Password: hashedPassword,
+		UserID:     uuid.New(),
+		Email:      NormalizeEmail(email),
+		Password:   hashedPassword,
+		Role:       RoleUser,
+		AuthSource: AuthSourceLocal,
+		Active:     true,
 	}
 }
+
+// Приводит email к виду, в котором он хранится и ищется: без пробелов по краям и в нижнем регистре.
+// Локальная часть адреса по RFC 5321 может быть чувствительна к регистру, но почтовые серверы так не делают,
+// а разный регистр одного адреса не должен давать два аккаунта
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Возвращает true, если аккаунт заблокирован в момент now
+func (u *User) IsLocked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Модель WebAuthn учетных данных (passkey или аппаратного ключа) пользователя
+type WebAuthnCredential struct {
+	// uuid записи
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// uuid пользователя, которому принадлежат учетные данные
+	UserID uuid.UUID `gorm:"type:uuid;index;not null"`
+	// Идентификатор учетных данных, выданный аутентификатором
+	CredentialID []byte `gorm:"type:bytea;uniqueIndex;not null"`
+	// Публичный ключ в формате COSE
+	PublicKey []byte `gorm:"type:bytea;not null"`
+	// Формат аттестации, использованный при регистрации
+	AttestationType string `gorm:"type:varchar(32)"`
+	// AAGUID модели аутентификатора
+	AAGUID []byte `gorm:"type:bytea"`
+	// Счетчик подписей, используется для обнаружения клонированных аутентификаторов
+	SignCount uint32 `gorm:"not null;default:0"`
+	// Поддерживаемые аутентификатором транспорты через запятую (usb, nfc, ble, internal, hybrid)
+	Transports string `gorm:"type:varchar(255)"`
+	// Флаги аутентификатора (UP, UV, BE, BS) в сыром виде
+	Flags uint8 `gorm:"not null;default:0"`
+	// Способ подключения аутентификатора (platform, cross-platform)
+	Attachment string `gorm:"type:varchar(32)"`
+	// Время последнего использования
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// Модель данных незавершенной WebAuthn церемонии (регистрации или входа)
+type WebAuthnSession struct {
+	// sha256 хеш случайного идентификатора сессии, который хранится в cookie
+	ID string `gorm:"type:varchar(64);primaryKey"`
+	// Назначение церемонии (см. константы WebAuthnSessionPurpose*)
+	Purpose string `gorm:"type:varchar(20);not null"`
+	// uuid пользователя. nil для входа по passkey, когда пользователь еще неизвестен
+	UserID *uuid.UUID `gorm:"type:uuid"`
+	// Данные сессии webauthn.SessionData в JSON
+	Data []byte `gorm:"type:jsonb;not null"`
+	// Время, после которого церемонию нельзя завершить
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
+
+// Назначения WebAuthn церемоний
+const (
+	WebAuthnSessionPurposeRegistration = "registration"
+	WebAuthnSessionPurposeLogin        = "login"
+	WebAuthnSessionPurposeMFA          = "mfa"
+)
@@ -0,0 +1,7 @@
+package oauth
+
+import "errors"
+
+var (
+	ErrInvalidRedirectURI = errors.New("invalid redirect uri")
+)
@@ -0,0 +1,142 @@
+package oauth
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	ResponseTypeCode = "code"
+
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+	// Авторизация устройства (RFC 8628)
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// Единственный поддерживаемый метод PKCE, plain не принимается
+	CodeChallengeMethodS256 = "S256"
+
+	TokenTypeBearer = "Bearer"
+
+	// Значения параметра prompt запроса авторизации OpenID Connect
+	PromptNone  = "none"
+	PromptLogin = "login"
+)
+
+// Стандартные scope OpenID Connect
+const (
+	ScopeOpenID  = "openid"
+	ScopeEmail   = "email"
+	ScopeProfile = "profile"
+)
+
+// Scope сервисного аккаунта для доступа к SCIM API (/scim/v2)
+const ScopeSCIM = "scim"
+
+// Scope, разрешенные клиенту, если при регистрации они не указаны
+var DefaultScopes = []string{ScopeOpenID, ScopeEmail, ScopeProfile}
+
+// Коды ошибок OAuth 2.0 (RFC 6749, раздел 4.1.2.1 и 5.2) и OpenID Connect
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+	ErrorServerError             = "server_error"
+	ErrorLoginRequired           = "login_required"
+	ErrorInvalidToken            = "invalid_token"
+	ErrorInsufficientScope       = "insufficient_scope"
+	// Ошибки опроса /token при авторизации устройства (RFC 8628, раздел 3.5).
+	// Ожидание решения пользователя обозначается authorization_pending, как требует RFC 8628, а не
+	// authorization_code_pending: стандартные клиенты device flow распознают только код из RFC
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorExpiredToken         = "expired_token"
+)
+
+// Ошибка протокола OAuth. Отправляется клиенту в виде полей error и error_description
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func NewError(code string, description string) *Error {
+	return &Error{
+		Code:        code,
+		Description: description,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// Разбирает строку scope (значения через пробел), повторы отбрасываются
+func ParseScope(scope string) []string {
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// Собирает строку scope из значений
+func JoinScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// Возвращает true, если все запрошенные scope входят в разрешенные
+func ScopeAllowed(requested []string, allowed []string) bool {
+	for _, s := range requested {
+		if !slices.Contains(allowed, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Проверяет redirect URI при регистрации клиента: абсолютный URI без фрагмента.
+// Для http допускаются только loopback адреса, другие схемы (https и схемы нативных приложений) разрешены
+func ValidateRedirectURI(rawURI string) error {
+	u, err := url.Parse(rawURI)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || strings.Contains(rawURI, "#") {
+		return ErrInvalidRedirectURI
+	}
+	if u.Scheme == "http" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" && u.Hostname() != "::1" {
+		return ErrInvalidRedirectURI
+	}
+	if (u.Scheme == "http" || u.Scheme == "https") && u.Host == "" {
+		return ErrInvalidRedirectURI
+	}
+	return nil
+}
+
+// Добавляет параметры к redirect URI, сохраняя его собственные параметры запроса
+func BuildRedirectURI(redirectURI string, params url.Values) (string, error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
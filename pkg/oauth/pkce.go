@@ -0,0 +1,26 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// code_verifier и code_challenge: 43-128 символов из [A-Z] [a-z] [0-9] - . _ ~ (RFC 7636, раздел 4.1)
+var pkceValuePattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// Проверяет формат code_challenge из запроса авторизации
+func ValidCodeChallenge(challenge string) bool {
+	return pkceValuePattern.MatchString(challenge)
+}
+
+// Проверяет, что code_verifier соответствует code_challenge, сохраненному при выдаче кода авторизации
+func VerifyPKCE(challenge string, method string, verifier string) bool {
+	if method != CodeChallengeMethodS256 || !pkceValuePattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,275 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/encryption"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/mailer"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/repositories"
+)
+
+const (
+	// Количество писем, забираемых из очереди за один раз, по умолчанию
+	DefaultBatchSize = 20
+	// Количество попыток отправки, после которого письмо переводится в models.OutboxStatusDead, по умолчанию
+	DefaultMaxAttempts = 8
+)
+
+var (
+	// Интервал проверки очереди по умолчанию
+	DefaultInterval = 2 * time.Second
+	// Задержка перед второй попыткой отправки по умолчанию, каждая следующая неудачная попытка удваивает ее
+	DefaultBaseDelay = 30 * time.Second
+	// Максимальная задержка между попытками по умолчанию
+	DefaultMaxDelay = time.Hour
+	// Время, на которое забранное письмо скрывается от других обработчиков, по умолчанию.
+	// Должно быть больше времени отправки всех писем пачки (BatchSize * mailer.DefaultSMTPTimeout)
+	DefaultLease = 15 * time.Minute
+	// Время хранения отправленных писем по умолчанию
+	DefaultRetention = 7 * 24 * time.Hour
+	// Время хранения писем, исчерпавших попытки, по умолчанию
+	DefaultDeadRetention = 30 * 24 * time.Hour
+)
+
+// Интервал между удалениями старых отправленных и исчерпавших попытки писем
+const cleanupInterval = time.Hour
+
+// Параметры обработчика очереди. Нулевые значения заменяются значениями по умолчанию
+type Options struct {
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Lease       time.Duration
+	Retention   time.Duration
+	// Время хранения писем в статусе models.OutboxStatusDead с момента последнего изменения
+	DeadRetention time.Duration
+}
+
+// Счетчики обработчика с момента запуска сервиса
+type Metrics struct {
+	// Отправлено писем
+	Sent int64
+	// Неудачных попыток отправки
+	Failed int64
+	// Писем переведено в models.OutboxStatusDead
+	DeadLettered int64
+	// Время последней проверки очереди, нулевое если проверок еще не было
+	LastRunAt time.Time
+}
+
+// Фоновый обработчик очереди писем. Письма отправляются минимум один раз: если сервис упадет между отправкой и
+// отметкой результата, письмо будет отправлено повторно
+type Worker interface {
+	// Обрабатывает очередь каждые Interval, пока не будет отменен ctx
+	Run(ctx context.Context)
+	// Отправляет письма, время попытки которых наступило, пока они не закончатся.
+	// Возвращает количество обработанных писем
+	ProcessPending(ctx context.Context) (int, error)
+	// Возвращает счетчики обработчика
+	Metrics() Metrics
+}
+
+type ImplWorker struct {
+	Repo      repositories.OutboxRepo
+	Mailer    mailer.Mailer
+	Encryptor encryption.Encryptor
+	Options   Options
+
+	sent         atomic.Int64
+	failed       atomic.Int64
+	deadLettered atomic.Int64
+	lastRunAt    atomic.Int64
+}
+
+// Конструктор обработчика очереди
+func NewWorker(repo repositories.OutboxRepo, m mailer.Mailer, encryptor encryption.Encryptor, options Options) Worker {
+	return &ImplWorker{
+		Repo:      repo,
+		Mailer:    m,
+		Encryptor: encryptor,
+		Options: Options{
+			Interval:      orDefault(options.Interval, DefaultInterval),
+			BatchSize:     orDefault(options.BatchSize, DefaultBatchSize),
+			MaxAttempts:   orDefault(options.MaxAttempts, DefaultMaxAttempts),
+			BaseDelay:     orDefault(options.BaseDelay, DefaultBaseDelay),
+			MaxDelay:      orDefault(options.MaxDelay, DefaultMaxDelay),
+			Lease:         orDefault(options.Lease, DefaultLease),
+			Retention:     orDefault(options.Retention, DefaultRetention),
+			DeadRetention: orDefault(options.DeadRetention, DefaultDeadRetention),
+		},
+	}
+}
+
+// Собирает запись очереди из письма, созданного по шаблону template. Содержимое письма шифруется encryptor
+// с uuid записи в качестве связанных данных, чтобы его нельзя было прочитать из БД или подставить в другую запись.
+// Если письмо содержит ссылки или коды, expiresAt - время, после которого они недействительны, иначе nil
+func NewMessage(template string, message *mailer.Message, expiresAt *time.Time, encryptor encryption.Encryptor) (*models.OutboxMessage, error) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.NewOutboxMessage(template, strings.Join(message.To, ", "), message.Subject, "")
+	record.ExpiresAt = expiresAt
+	record.Payload, err = encryptor.Encrypt(payload, record.ID[:])
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (w *ImplWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Options.Interval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+	for {
+		_, err := w.ProcessPending(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("Failed to process mail outbox", "error", err.Error())
+		}
+
+		if time.Since(lastCleanup) >= cleanupInterval {
+			w.cleanup(ctx)
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *ImplWorker) ProcessPending(ctx context.Context) (int, error) {
+	w.lastRunAt.Store(time.Now().UnixNano())
+
+	processed := 0
+	for {
+		messages, err := w.Repo.Claim(ctx, time.Now(), w.Options.BatchSize, w.Options.Lease)
+		if err != nil {
+			return processed, err
+		}
+
+		for _, message := range messages {
+			err = w.deliver(ctx, &message)
+			if err != nil {
+				return processed, err
+			}
+			processed++
+		}
+
+		if len(messages) < w.Options.BatchSize {
+			return processed, nil
+		}
+	}
+}
+
+func (w *ImplWorker) Metrics() Metrics {
+	metrics := Metrics{
+		Sent:         w.sent.Load(),
+		Failed:       w.failed.Load(),
+		DeadLettered: w.deadLettered.Load(),
+	}
+	if lastRunAt := w.lastRunAt.Load(); lastRunAt != 0 {
+		metrics.LastRunAt = time.Unix(0, lastRunAt)
+	}
+	return metrics
+}
+
+// Удаляет отправленные письма старше Retention и исчерпавшие попытки старше DeadRetention
+func (w *ImplWorker) cleanup(ctx context.Context) {
+	deleted, err := w.Repo.DeleteSentBefore(ctx, time.Now().Add(-w.Options.Retention))
+	if err != nil && ctx.Err() == nil {
+		slog.Warn("Failed to delete sent mail from outbox", "error", err.Error())
+	} else if deleted > 0 {
+		slog.Info("Deleted sent mail from outbox", "count", deleted)
+	}
+
+	deleted, err = w.Repo.DeleteDeadBefore(ctx, time.Now().Add(-w.Options.DeadRetention))
+	if err != nil && ctx.Err() == nil {
+		slog.Warn("Failed to delete dead mail from outbox", "error", err.Error())
+	} else if deleted > 0 {
+		slog.Info("Deleted dead mail from outbox", "count", deleted)
+	}
+}
+
+// Отправляет письмо и записывает результат. Возвращает только ошибки записи результата
+func (w *ImplWorker) deliver(ctx context.Context, message *models.OutboxMessage) error {
+	// ссылки и коды устаревшего письма уже недействительны, отправлять его бессмысленно
+	if message.ExpiresAt != nil && !time.Now().Before(*message.ExpiresAt) {
+		w.deadLettered.Add(1)
+		slog.Warn("Mail in outbox expired before delivery", "id", message.ID.String())
+		return w.Repo.MarkDead(ctx, message.ID, "expired before delivery")
+	}
+
+	payload, err := w.decodePayload(message)
+	if err != nil {
+		// поврежденное письмо не отправится и при повторе
+		w.failed.Add(1)
+		w.deadLettered.Add(1)
+		slog.Warn("Invalid mail in outbox", "id", message.ID.String(), "error", err.Error())
+		return w.Repo.MarkDead(ctx, message.ID, fmt.Sprintf("invalid payload: %s", err.Error()))
+	}
+
+	err = w.Mailer.SendMail(payload)
+	if err == nil {
+		w.sent.Add(1)
+		return w.Repo.MarkSent(ctx, message.ID, time.Now())
+	}
+
+	w.failed.Add(1)
+	attempts := message.Attempts + 1
+	if attempts >= w.Options.MaxAttempts {
+		w.deadLettered.Add(1)
+		slog.Warn("Failed to send mail, giving up", "id", message.ID.String(), "attempts", attempts, "error", err.Error())
+		return w.Repo.MarkDead(ctx, message.ID, err.Error())
+	}
+
+	slog.Warn("Failed to send mail, will retry", "id", message.ID.String(), "attempts", attempts, "error", err.Error())
+	return w.Repo.MarkFailed(ctx, message.ID, err.Error(), time.Now().Add(w.backoff(attempts)))
+}
+
+// Расшифровывает и разбирает содержимое письма (см. NewMessage)
+func (w *ImplWorker) decodePayload(message *models.OutboxMessage) (*mailer.Message, error) {
+	data, err := w.Encryptor.Decrypt(message.Payload, message.ID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var payload mailer.Message
+	err = json.Unmarshal(data, &payload)
+	if err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// Экспоненциальная задержка после attempts неудачных попыток: BaseDelay * 2^(attempts - 1), но не больше MaxDelay
+func (w *ImplWorker) backoff(attempts int) time.Duration {
+	delay := w.Options.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= w.Options.MaxDelay {
+			return w.Options.MaxDelay
+		}
+	}
+	return min(delay, w.Options.MaxDelay)
+}
+
+func orDefault[T int | time.Duration](value T, def T) T {
+	if value <= 0 {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,159 @@
+package outbox
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/encryption"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/mailer"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/repositories"
+	"github.com/google/uuid"
+)
+
+// Очередь в памяти. Встроенный интерфейс оставлен nil: неожиданный вызов приводит к панике
+type fakeOutboxRepo struct {
+	repositories.OutboxRepo
+
+	mu       sync.Mutex
+	messages []models.OutboxMessage
+}
+
+func (r *fakeOutboxRepo) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var claimed []models.OutboxMessage
+	for i := range r.messages {
+		message := &r.messages[i]
+		if len(claimed) < limit && message.Status == models.OutboxStatusPending && !message.NextAttemptAt.After(now) {
+			message.NextAttemptAt = now.Add(lease)
+			claimed = append(claimed, *message)
+		}
+	}
+	return claimed, nil
+}
+
+func (r *fakeOutboxRepo) MarkSent(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.update(id, func(message *models.OutboxMessage) {
+		message.Status = models.OutboxStatusSent
+		message.SentAt = &at
+		message.Payload = ""
+	})
+}
+
+func (r *fakeOutboxRepo) MarkDead(ctx context.Context, id uuid.UUID, lastError string) error {
+	return r.update(id, func(message *models.OutboxMessage) {
+		message.Status = models.OutboxStatusDead
+		message.Attempts++
+		message.LastError = lastError
+	})
+}
+
+func (r *fakeOutboxRepo) update(id uuid.UUID, fn func(message *models.OutboxMessage)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.messages {
+		if r.messages[i].ID == id {
+			fn(&r.messages[i])
+		}
+	}
+	return nil
+}
+
+type fakeMailer struct {
+	sent []*mailer.Message
+}
+
+func (m *fakeMailer) SendMail(msg *mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func newTestEncryptor(t *testing.T) encryption.Encryptor {
+	t.Helper()
+	encryptor, err := encryption.NewAESGCMEncryptor([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("failed to build encryptor: %v", err)
+	}
+	return encryptor
+}
+
+func newTestMessage(t *testing.T, encryptor encryption.Encryptor, expiresAt *time.Time) *models.OutboxMessage {
+	t.Helper()
+	record, err := NewMessage("email_login", &mailer.Message{
+		To:      []string{"alice@example.com"},
+		Subject: "Sign in",
+		Text:    "http://localhost/login/email/consume?token=secret-token",
+	}, expiresAt, encryptor)
+	if err != nil {
+		t.Fatalf("failed to build message: %v", err)
+	}
+	return record
+}
+
+func TestNewMessageEncryptsPayload(t *testing.T) {
+	record := newTestMessage(t, newTestEncryptor(t), nil)
+	if strings.Contains(record.Payload, "secret-token") || strings.Contains(record.Payload, "alice@example.com") {
+		t.Fatalf("payload is stored in plaintext: %q", record.Payload)
+	}
+}
+
+func TestWorkerDeliversEncryptedMessage(t *testing.T) {
+	encryptor := newTestEncryptor(t)
+	expiresAt := time.Now().Add(time.Hour)
+	repo := &fakeOutboxRepo{messages: []models.OutboxMessage{*newTestMessage(t, encryptor, &expiresAt)}}
+	m := &fakeMailer{}
+
+	_, err := NewWorker(repo, m, encryptor, Options{}).ProcessPending(context.Background())
+	if err != nil {
+		t.Fatalf("failed to process outbox: %v", err)
+	}
+	if len(m.sent) != 1 || !strings.Contains(m.sent[0].Text, "secret-token") {
+		t.Fatalf("expected the decrypted message to be sent, got %+v", m.sent)
+	}
+	if repo.messages[0].Status != models.OutboxStatusSent {
+		t.Fatalf("expected status %q, got %q", models.OutboxStatusSent, repo.messages[0].Status)
+	}
+}
+
+func TestWorkerRejectsUndeliverableMessages(t *testing.T) {
+	tests := map[string]func(t *testing.T, encryptor encryption.Encryptor) models.OutboxMessage{
+		"expired": func(t *testing.T, encryptor encryption.Encryptor) models.OutboxMessage {
+			expiresAt := time.Now().Add(-time.Minute)
+			return *newTestMessage(t, encryptor, &expiresAt)
+		},
+		// содержимое, перенесенное из другой записи, не расшифровывается с чужим uuid
+		"moved payload": func(t *testing.T, encryptor encryption.Encryptor) models.OutboxMessage {
+			record := *newTestMessage(t, encryptor, nil)
+			record.ID = uuid.New()
+			return record
+		},
+		// письмо, записанное в таблицу в открытом виде в обход NewMessage
+		"plaintext payload": func(t *testing.T, encryptor encryption.Encryptor) models.OutboxMessage {
+			record := *newTestMessage(t, encryptor, nil)
+			record.Payload = `{"To":["alice@example.com"],"Subject":"Sign in","Text":"http://attacker.example.com"}`
+			return record
+		},
+	}
+	for name, message := range tests {
+		t.Run(name, func(t *testing.T) {
+			encryptor := newTestEncryptor(t)
+			repo := &fakeOutboxRepo{messages: []models.OutboxMessage{message(t, encryptor)}}
+			m := &fakeMailer{}
+
+			_, err := NewWorker(repo, m, encryptor, Options{}).ProcessPending(context.Background())
+			if err != nil {
+				t.Fatalf("failed to process outbox: %v", err)
+			}
+			if len(m.sent) != 0 {
+				t.Fatalf("expected nothing to be sent, got %+v", m.sent)
+			}
+			if repo.messages[0].Status != models.OutboxStatusDead {
+				t.Fatalf("expected status %q, got %q", models.OutboxStatusDead, repo.messages[0].Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Длина префикса sha1 хеша, по которому разбит корпус утекших паролей (как в range API haveibeenpwned)
+const BreachedPrefixLength = 5
+
+// Корпус утекших паролей
+type BreachedCorpus interface {
+	// Проверяет, встречается ли пароль в корпусе
+	Contains(password string) (bool, error)
+}
+
+// Корпус утекших паролей, хранящийся локально в виде каталога range файлов.
+//
+// Каждый файл назван по первым пяти hex символам sha1 хеша (например 5BAA6.txt) и содержит строки вида SUFFIX:COUNT,
+// где SUFFIX - оставшиеся 35 символов хеша. Такой формат выдает haveibeenpwned-downloader.
+// Файлы читаются по необходимости, поэтому весь корпус в память не загружается
+type DirBreachedCorpus struct {
+	Dir string
+}
+
+// Конструктор корпуса утекших паролей. Проверяет существование каталога
+func NewDirBreachedCorpus(dir string) (BreachedCorpus, error) {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return nil, ErrBreachedDirNotFound
+	}
+	return &DirBreachedCorpus{
+		Dir: dir,
+	}, nil
+}
+
+func (c *DirBreachedCorpus) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:BreachedPrefixLength], hash[BreachedPrefixLength:]
+
+	file, err := c.openRangeFile(prefix)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineSuffix, _, _ := strings.Cut(line, ":")
+		if strings.EqualFold(lineSuffix, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// Открывает range файл для префикса, допускается как вариант с расширением .txt, так и без него
+func (c *DirBreachedCorpus) openRangeFile(prefix string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(c.Dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return os.Open(filepath.Join(c.Dir, prefix))
+	}
+	return file, err
+}
@@ -0,0 +1,40 @@
+package policy
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrBreachedDirNotFound = errors.New("breached passwords directory not found")
+)
+
+// Коды нарушений парольной политики
+const (
+	ViolationTooShort      = "too_short"
+	ViolationTooLong       = "too_long"
+	ViolationContainsEmail = "contains_email"
+	ViolationTooWeak       = "too_weak"
+	ViolationBreached      = "breached"
+)
+
+// Нарушение одного из правил парольной политики
+type Violation struct {
+	// Машиночитаемый код нарушения (см. константы Violation*)
+	Code string `json:"code"`
+	// Человекочитаемое описание нарушения
+	Message string `json:"message"`
+}
+
+// Ошибка проверки пароля, содержит все найденные нарушения политики
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return "password policy violated: " + strings.Join(messages, "; ")
+}
@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Минимальная длина пароля по умолчанию (в символах)
+	DefaultMinLength = 8
+	// Максимальная длина пароля по умолчанию (в байтах). bcrypt молча отбрасывает все что длиннее 72 байт
+	DefaultMaxLength = 72
+)
+
+type ImplPasswordPolicy struct {
+	// Минимальная длина пароля в символах
+	MinLength int
+	// Максимальная длина пароля в байтах
+	MaxLength int
+	// Минимальная оценка стойкости (0-4, см. EstimateStrength). 0 отключает проверку
+	MinStrength int
+	// Корпус утекших паролей. nil отключает проверку
+	Breached BreachedCorpus
+}
+
+// Парольная политика
+type PasswordPolicy interface {
+	// Проверяет пароль пользователя с указанным email.
+	//
+	// При нарушении правил возвращает *ValidationError со всеми нарушениями, остальные ошибки - ошибки проверки корпуса
+	Validate(password string, email string) error
+}
+
+// Конструктор парольной политики. Нулевые minLength и maxLength заменяются значениями по умолчанию
+func NewPasswordPolicy(minLength int, maxLength int, minStrength int, breached BreachedCorpus) PasswordPolicy {
+	if minLength <= 0 {
+		minLength = DefaultMinLength
+	}
+	if maxLength <= 0 {
+		maxLength = DefaultMaxLength
+	}
+	return &ImplPasswordPolicy{
+		MinLength:   minLength,
+		MaxLength:   maxLength,
+		MinStrength: minStrength,
+		Breached:    breached,
+	}
+}
+
+func (p *ImplPasswordPolicy) Validate(password string, email string) error {
+	violations := []Violation{}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	if len(password) > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooLong,
+			Message: fmt.Sprintf("password must be at most %d bytes long", p.MaxLength),
+		})
+	}
+
+	if containsEmail(password, email) {
+		violations = append(violations, Violation{
+			Code:    ViolationContainsEmail,
+			Message: "password must not contain email",
+		})
+	}
+
+	if p.MinStrength > 0 && EstimateStrength(password) < p.MinStrength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooWeak,
+			Message: "password is too weak",
+		})
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Code:    ViolationBreached,
+				Message: "password has appeared in a data breach",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// Проверяет, содержит ли пароль email целиком или его локальную часть (без учета регистра)
+func containsEmail(password string, email string) bool {
+	if email == "" {
+		return false
+	}
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	// слишком короткая локальная часть дает ложные срабатывания
+	return len(local) >= 3 && strings.Contains(password, local)
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"math"
+	"unicode"
+)
+
+// Оценивает стойкость пароля по шкале от 0 (очень слабый) до 4 (очень стойкий).
+//
+// Оценка строится на энтропии: размер алфавита определяется по используемым классам символов,
+// а повторяющиеся символы и последовательности (aaa, abc, 321) не увеличивают эффективную длину
+func EstimateStrength(password string) int {
+	bits := estimateEntropy(password)
+	switch {
+	case bits < 28:
+		return 0
+	case bits < 36:
+		return 1
+	case bits < 60:
+		return 2
+	case bits < 80:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Оценивает энтропию пароля в битах
+func estimateEntropy(password string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsLower(r):
+			lower = true
+		case r < unicode.MaxASCII && unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	alphabet := 0
+	if lower {
+		alphabet += 26
+	}
+	if upper {
+		alphabet += 26
+	}
+	if digit {
+		alphabet += 10
+	}
+	if symbol {
+		alphabet += 33
+	}
+	if other {
+		alphabet += 100
+	}
+
+	// первый символ всегда учитывается, последующие - только если они не продолжают повтор или последовательность
+	effective := 1.0
+	for i := 1; i < len(runes); i++ {
+		delta := runes[i] - runes[i-1]
+		if delta >= -1 && delta <= 1 {
+			continue
+		}
+		effective++
+	}
+
+	return effective * math.Log2(float64(alphabet))
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Количество вызовов Take, после которого из памяти удаляются полностью пополненные bucket'ы
+const memoryCleanupEvery = 1024
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// Хранилище bucket'ов в памяти процесса. Подходит только для одного экземпляра приложения
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+// Конструктор хранилища bucket'ов в памяти
+func NewMemoryStore() Store {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls%memoryCleanupEvery == 0 {
+		s.cleanup(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+
+	tokens, result := take(b.tokens, b.last, now, limit)
+	b.tokens = tokens
+	b.last = now
+	b.limit = limit
+
+	return result, nil
+}
+
+func (s *MemoryStore) Cleanup(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cleanup(now), nil
+}
+
+// Удаляет bucket'ы, которые уже полностью пополнились и ничем не отличаются от новых
+func (s *MemoryStore) cleanup(now time.Time) int64 {
+	var deleted int64
+	for key, b := range s.buckets {
+		refilled := b.tokens + now.Sub(b.last).Seconds()*b.limit.Rate
+		if refilled >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+			deleted++
+		}
+	}
+	return deleted
+}
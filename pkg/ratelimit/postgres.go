@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Хранилище bucket'ов в Postgres. Лимиты общие для всех экземпляров приложения, использующих одну БД
+type PostgresStore struct {
+	DB *gorm.DB
+}
+
+// Конструктор хранилища bucket'ов в Postgres. Таблица создается миграцией models.RateLimitBucket
+func NewPostgresStore(db *gorm.DB) Store {
+	return &PostgresStore{
+		DB: db,
+	}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	var result Result
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// новый bucket создается полным, существующий не трогается
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RateLimitBucket{
+			Key:          key,
+			Tokens:       float64(limit.Burst),
+			LastRefillAt: now,
+			Rate:         limit.Rate,
+			Burst:        limit.Burst,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		var b models.RateLimitBucket
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&b, "key = ?", key).Error
+		if err != nil {
+			return err
+		}
+
+		b.Tokens, result = take(b.Tokens, b.LastRefillAt, now, limit)
+		b.LastRefillAt = now
+		b.Rate = limit.Rate
+		b.Burst = limit.Burst
+
+		return tx.Save(&b).Error
+	})
+
+	return result, err
+}
+
+func (s *PostgresStore) Cleanup(ctx context.Context, now time.Time) (int64, error) {
+	// bucket'ы, которые сейчас заблокированы Take, пропускаются: они используются и удалятся при следующей очистке
+	result := s.DB.WithContext(ctx).Exec(
+		`DELETE FROM rate_limit_buckets WHERE key IN (
+			SELECT key FROM rate_limit_buckets
+			WHERE tokens + EXTRACT(EPOCH FROM (CAST(? AS timestamptz) - last_refill_at)) * rate >= burst
+			FOR UPDATE SKIP LOCKED
+		)`,
+		now,
+	)
+	return result.RowsAffected, result.Error
+}
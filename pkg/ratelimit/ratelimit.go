@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// Параметры token bucket
+type Limit struct {
+	// Скорость пополнения (токенов в секунду)
+	Rate float64
+	// Емкость (максимальное количество запросов подряд)
+	Burst int
+}
+
+// Возвращает true, если лимит задан. Нулевой лимит означает отсутствие ограничения
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Лимиты одного маршрута
+type RouteLimits struct {
+	// Лимит на один IP адрес
+	IP Limit
+	// Лимит на один аккаунт
+	Account Limit
+	// Общий лимит на маршрут
+	Route Limit
+}
+
+// Результат попытки взять токен из bucket
+type Result struct {
+	// Разрешен ли запрос
+	Allowed bool
+	// Емкость bucket
+	Limit int
+	// Оставшееся количество токенов
+	Remaining int
+	// Время до полного пополнения bucket
+	ResetAfter time.Duration
+	// Время, через которое появится следующий токен (только если запрос не разрешен)
+	RetryAfter time.Duration
+}
+
+// Максимальная длина ключа bucket (см. models.RateLimitBucket)
+const MaxKeyLength = 255
+
+var (
+	// Интервал удаления пополнившихся bucket'ов по умолчанию
+	DefaultCleanupInterval = 10 * time.Minute
+)
+
+// Хранилище token bucket'ов
+type Store interface {
+	// Пытается взять один токен из bucket с ключом key. Ключ не длиннее MaxKeyLength
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// Удаляет bucket'ы, которые к моменту now полностью пополнились и ничем не отличаются от новых.
+	// Возвращает количество удаленных
+	Cleanup(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Удаляет пополнившиеся bucket'ы из store каждые interval, пока не будет отменен ctx
+func RunCleanup(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := store.Cleanup(ctx, now)
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to clean up rate limit buckets", "error", err.Error())
+			} else if deleted > 0 {
+				slog.Debug("Deleted refilled rate limit buckets", "count", deleted)
+			}
+		}
+	}
+}
+
+// Пополняет bucket за время, прошедшее с last, и пытается взять из него токен.
+// Возвращает новое количество токенов и результат
+func take(tokens float64, last time.Time, now time.Time, limit Limit) (float64, Result) {
+	elapsed := max(now.Sub(last).Seconds(), 0)
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+
+	result := Result{Limit: limit.Burst}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.ResetAfter = secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate)
+
+	return tokens, result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
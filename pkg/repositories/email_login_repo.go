@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GormEmailLoginRepo struct {
+	DB *gorm.DB
+}
+
+// Репозиторий одноразовых ссылок и кодов входа по email
+type EmailLoginRepo interface {
+	// Сохраняет новый запрос входа. Предыдущие запросы пользователя остаются действительными,
+	// заодно удаляются все просроченные запросы
+	Create(ctx context.Context, login *models.EmailLogin) error
+	// Атомарно находит и удаляет запрос по хешам токена из ссылки и cookie привязки.
+	// Просроченные запросы не возвращаются
+	TakeByToken(ctx context.Context, tokenHash string, bindingHash string) (*models.EmailLogin, error)
+	// Находит последний действующий запрос по хешу cookie привязки
+	FindByBinding(ctx context.Context, bindingHash string) (*models.EmailLogin, error)
+	// Увеличивает счетчик попыток ввода кода, если он меньше maxAttempts. Возвращает false, если попытки исчерпаны
+	IncrementAttempts(ctx context.Context, id uuid.UUID, maxAttempts int) (bool, error)
+	// Удаляет запрос. Возвращает false, если запрос уже был удален (использован)
+	Delete(ctx context.Context, id uuid.UUID) (bool, error)
+	// Удаляет все запросы пользователя
+	DeleteByUserID(ctx context.Context, userID uuid.UUID) error
+}
+
+// Конструктор для создания экземпляра репозитория. Более предпочтительно, чем создание из голой структуры
+func NewEmailLoginRepo(db *gorm.DB) EmailLoginRepo {
+	return &GormEmailLoginRepo{
+		DB: db,
+	}
+}
+
+func (r *GormEmailLoginRepo) Create(ctx context.Context, login *models.EmailLogin) error {
+	return conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Delete(&models.EmailLogin{}, "expires_at <= ?", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(login).Error
+	})
+}
+
+func (r *GormEmailLoginRepo) TakeByToken(ctx context.Context, tokenHash string, bindingHash string) (*models.EmailLogin, error) {
+	var logins []models.EmailLogin
+	err := conn(ctx, r.DB).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND binding_hash = ? AND expires_at > ?", tokenHash, bindingHash, time.Now()).
+		Delete(&logins).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(logins) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &logins[0], nil
+}
+
+func (r *GormEmailLoginRepo) FindByBinding(ctx context.Context, bindingHash string) (*models.EmailLogin, error) {
+	var login models.EmailLogin
+	err := conn(ctx, r.DB).
+		Where("binding_hash = ? AND expires_at > ?", bindingHash, time.Now()).
+		Order("created_at DESC").
+		First(&login).Error
+	if err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+func (r *GormEmailLoginRepo) IncrementAttempts(ctx context.Context, id uuid.UUID, maxAttempts int) (bool, error) {
+	result := conn(ctx, r.DB).
+		Model(&models.EmailLogin{}).
+		Where("id = ? AND attempts < ?", id, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormEmailLoginRepo) Delete(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := conn(ctx, r.DB).Delete(&models.EmailLogin{}, "id = ?", id)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormEmailLoginRepo) DeleteByUserID(ctx context.Context, userID uuid.UUID) error {
+	return conn(ctx, r.DB).Delete(&models.EmailLogin{}, "user_id = ?", userID).Error
+}
@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GormIdentityRepo struct {
+	DB *gorm.DB
+}
+
+// Репозиторий внешних личностей пользователей и незавершенных входов через внешние провайдеры
+type IdentityRepo interface {
+	// Сохраняет личность. Если личность провайдера уже связана с пользователем, возвращает gorm.ErrDuplicatedKey
+	Create(ctx context.Context, identity *models.Identity) error
+	// Атомарно создает пользователя вместе с его первой личностью
+	CreateWithUser(ctx context.Context, user *models.User, identity *models.Identity) error
+	// Находит личность по провайдеру и идентификатору пользователя у провайдера
+	FindByProviderSubject(ctx context.Context, provider string, subject string) (*models.Identity, error)
+	// Находит все личности пользователя
+	FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.Identity, error)
+	// Удаляет личность пользователя по uuid записи. Возвращает false, если такой личности нет
+	Delete(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error)
+	// Сохраняет незавершенный вход. Просроченные входы удаляются
+	CreateLoginSession(ctx context.Context, session *models.FederatedLoginSession) error
+	// Атомарно находит и удаляет незавершенный вход, чтобы его нельзя было завершить повторно.
+	// Просроченные входы не возвращаются
+	TakeLoginSession(ctx context.Context, id string, provider string) (*models.FederatedLoginSession, error)
+}
+
+// Конструктор для создания экземпляра репозитория. Более предпочтительно, чем создание из голой структуры
+func NewIdentityRepo(db *gorm.DB) IdentityRepo {
+	return &GormIdentityRepo{
+		DB: db,
+	}
+}
+
+func (r *GormIdentityRepo) Create(ctx context.Context, identity *models.Identity) error {
+	return conn(ctx, r.DB).Create(identity).Error
+}
+
+func (r *GormIdentityRepo) CreateWithUser(ctx context.Context, user *models.User, identity *models.Identity) error {
+	return conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(user).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(identity).Error
+	})
+}
+
+func (r *GormIdentityRepo) FindByProviderSubject(ctx context.Context, provider string, subject string) (*models.Identity, error) {
+	var identity models.Identity
+	err := conn(ctx, r.DB).First(&identity, "provider = ? AND subject = ?", provider, subject).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *GormIdentityRepo) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.Identity, error) {
+	var identities []models.Identity
+	err := conn(ctx, r.DB).Order("linked_at").Find(&identities, "user_id = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *GormIdentityRepo) Delete(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error) {
+	result := conn(ctx, r.DB).Delete(&models.Identity{}, "id = ? AND user_id = ?", id, userID)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormIdentityRepo) CreateLoginSession(ctx context.Context, session *models.FederatedLoginSession) error {
+	return conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Delete(&models.FederatedLoginSession{}, "expires_at <= ?", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(session).Error
+	})
+}
+
+func (r *GormIdentityRepo) TakeLoginSession(ctx context.Context, id string, provider string) (*models.FederatedLoginSession, error) {
+	var sessions []models.FederatedLoginSession
+	err := conn(ctx, r.DB).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND provider = ? AND expires_at > ?", id, provider, time.Now()).
+		Delete(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &sessions[0], nil
+}
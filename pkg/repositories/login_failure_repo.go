@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GormLoginFailureRepo struct {
+	DB *gorm.DB
+}
+
+// Репозиторий счетчиков неудачных попыток входа по IP адресам
+type LoginFailureRepo interface {
+	// Находит счетчик для IP адреса
+	FindByIP(ctx context.Context, ip string) (*models.LoginFailure, error)
+	// Атомарно увеличивает счетчик для IP адреса и возвращает его новое значение.
+	// Попытки, выполненные раньше windowStart, не учитываются (счетчик начинается заново)
+	RegisterFailure(ctx context.Context, ip string, at time.Time, windowStart time.Time) (*models.LoginFailure, error)
+}
+
+// Конструктор для создания экземпляра репозитория. Более предпочтительно, чем создание из голой структуры
+func NewLoginFailureRepo(db *gorm.DB) LoginFailureRepo {
+	return &GormLoginFailureRepo{
+		DB: db,
+	}
+}
+
+func (r *GormLoginFailureRepo) FindByIP(ctx context.Context, ip string) (*models.LoginFailure, error) {
+	var failure models.LoginFailure
+	err := conn(ctx, r.DB).First(&failure, "ip = ?", ip).Error
+	if err != nil {
+		return nil, err
+	}
+	return &failure, nil
+}
+
+func (r *GormLoginFailureRepo) RegisterFailure(ctx context.Context, ip string, at time.Time, windowStart time.Time) (*models.LoginFailure, error) {
+	failure := models.LoginFailure{
+		IP:           ip,
+		Attempts:     1,
+		LastFailedAt: at,
+	}
+	err := conn(ctx, r.DB).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "ip"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"attempts": gorm.Expr(
+				"CASE WHEN login_failures.last_failed_at < ? THEN 1 ELSE login_failures.attempts + 1 END",
+				windowStart,
+			),
+			"last_failed_at": at,
+		}),
+	}).Create(&failure).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return r.FindByIP(ctx, ip)
+}
@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"gorm.io/gorm"
+)
+
+type GormOAuthClientRepo struct {
+	DB *gorm.DB
+}
+
+// Репозиторий зарегистрированных OAuth клиентов
+type OAuthClientRepo interface {
+	Create(ctx context.Context, client *models.OAuthClient) error
+	FindByID(ctx context.Context, clientID string) (*models.OAuthClient, error)
+	FindAll(ctx context.Context) ([]models.OAuthClient, error)
+	// Удаляет клиента. Возвращает false, если такого клиента нет
+	Delete(ctx context.Context, clientID string) (bool, error)
+}
+
+// Конструктор для создания экземпляра репозитория. Более предпочтительно, чем создание из голой структуры
+func NewOAuthClientRepo(db *gorm.DB) OAuthClientRepo {
+	return &GormOAuthClientRepo{
+		DB: db,
+	}
+}
+
+func (r *GormOAuthClientRepo) Create(ctx context.Context, client *models.OAuthClient) error {
+	return conn(ctx, r.DB).Create(client).Error
+}
+
+func (r *GormOAuthClientRepo) FindByID(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	err := conn(ctx, r.DB).First(&client, "client_id = ?", clientID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (r *GormOAuthClientRepo) FindAll(ctx context.Context) ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	err := conn(ctx, r.DB).Order("created_at").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (r *GormOAuthClientRepo) Delete(ctx context.Context, clientID string) (bool, error) {
+	result := conn(ctx, r.DB).Delete(&models.OAuthClient{}, "client_id = ?", clientID)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
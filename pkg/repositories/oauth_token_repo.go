@@ -0,0 +1,192 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GormOAuthTokenRepo struct {
+	DB *gorm.DB
+}
+
+// Репозиторий кодов авторизации, запросов авторизации устройств и refresh токенов, выданных OAuth клиентам
+type OAuthTokenRepo interface {
+	// Сохраняет код авторизации. Просроченные коды удаляются
+	CreateAuthorizationCode(ctx context.Context, code *models.OAuthAuthorizationCode) error
+	// Атомарно находит и удаляет код авторизации, чтобы его нельзя было обменять повторно.
+	// Просроченные коды не возвращаются
+	TakeAuthorizationCode(ctx context.Context, codeHash string) (*models.OAuthAuthorizationCode, error)
+	// Сохраняет refresh токен. Просроченные токены удаляются
+	CreateRefreshToken(ctx context.Context, token *models.OAuthRefreshToken) error
+	// Атомарно находит и удаляет refresh токен по jti. Просроченные токены не возвращаются
+	TakeRefreshToken(ctx context.Context, id uuid.UUID) (*models.OAuthRefreshToken, error)
+	// Удаляет все refresh токены пользователя, выданные OAuth клиентам
+	DeleteRefreshTokensByUserID(ctx context.Context, userID uuid.UUID) error
+	// Сохраняет запрос авторизации устройства. Просроченные запросы удаляются.
+	// Если user_code уже занят, возвращает gorm.ErrDuplicatedKey
+	CreateDeviceCode(ctx context.Context, code *models.OAuthDeviceCode) error
+	// Находит ожидающий подтверждения и не просроченный запрос авторизации устройства по user_code
+	FindPendingDeviceCode(ctx context.Context, userCode string) (*models.OAuthDeviceCode, error)
+	// Записывает решение пользователя по ожидающему запросу авторизации устройства.
+	// Возвращает false, если запроса нет, он просрочен или решение уже принято
+	DecideDeviceCode(ctx context.Context, userCode string, userID uuid.UUID, authTime time.Time, approved bool) (bool, error)
+	// Обрабатывает опрос /token клиентом clientID по device_code. Просроченный запрос возвращается без изменений.
+	// Запрос с принятым решением удаляется, чтобы токены по нему выдавались один раз.
+	// Если с прошлого опроса прошло меньше Interval, интервал увеличивается на slowDownIncrement и возвращается true
+	PollDeviceCode(ctx context.Context, deviceCodeHash string, clientID string, slowDownIncrement time.Duration) (*models.OAuthDeviceCode, bool, error)
+}
+
+// Конструктор для создания экземпляра репозитория. Более предпочтительно, чем создание из голой структуры
+func NewOAuthTokenRepo(db *gorm.DB) OAuthTokenRepo {
+	return &GormOAuthTokenRepo{
+		DB: db,
+	}
+}
+
+func (r *GormOAuthTokenRepo) CreateAuthorizationCode(ctx context.Context, code *models.OAuthAuthorizationCode) error {
+	return conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Delete(&models.OAuthAuthorizationCode{}, "expires_at <= ?", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(code).Error
+	})
+}
+
+func (r *GormOAuthTokenRepo) TakeAuthorizationCode(ctx context.Context, codeHash string) (*models.OAuthAuthorizationCode, error) {
+	var codes []models.OAuthAuthorizationCode
+	err := conn(ctx, r.DB).
+		Clauses(clause.Returning{}).
+		Where("code_hash = ? AND expires_at > ?", codeHash, time.Now()).
+		Delete(&codes).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &codes[0], nil
+}
+
+func (r *GormOAuthTokenRepo) CreateRefreshToken(ctx context.Context, token *models.OAuthRefreshToken) error {
+	return conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Delete(&models.OAuthRefreshToken{}, "expires_at <= ?", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *GormOAuthTokenRepo) TakeRefreshToken(ctx context.Context, id uuid.UUID) (*models.OAuthRefreshToken, error) {
+	var tokens []models.OAuthRefreshToken
+	err := conn(ctx, r.DB).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND expires_at > ?", id, time.Now()).
+		Delete(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &tokens[0], nil
+}
+
+func (r *GormOAuthTokenRepo) DeleteRefreshTokensByUserID(ctx context.Context, userID uuid.UUID) error {
+	return conn(ctx, r.DB).Delete(&models.OAuthRefreshToken{}, "user_id = ?", userID).Error
+}
+
+func (r *GormOAuthTokenRepo) CreateDeviceCode(ctx context.Context, code *models.OAuthDeviceCode) error {
+	return conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Delete(&models.OAuthDeviceCode{}, "expires_at <= ?", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(code).Error
+	})
+}
+
+func (r *GormOAuthTokenRepo) FindPendingDeviceCode(ctx context.Context, userCode string) (*models.OAuthDeviceCode, error) {
+	var code models.OAuthDeviceCode
+	err := conn(ctx, r.DB).
+		Where("user_code = ? AND status = ? AND expires_at > ?", userCode, models.DeviceCodeStatusPending, time.Now()).
+		First(&code).Error
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (r *GormOAuthTokenRepo) DecideDeviceCode(
+	ctx context.Context,
+	userCode string,
+	userID uuid.UUID,
+	authTime time.Time,
+	approved bool,
+) (bool, error) {
+	status := models.DeviceCodeStatusDenied
+	if approved {
+		status = models.DeviceCodeStatusApproved
+	}
+
+	result := conn(ctx, r.DB).
+		Model(&models.OAuthDeviceCode{}).
+		Where("user_code = ? AND status = ? AND expires_at > ?", userCode, models.DeviceCodeStatusPending, time.Now()).
+		Updates(map[string]any{
+			"status":    status,
+			"user_id":   userID,
+			"auth_time": authTime,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormOAuthTokenRepo) PollDeviceCode(
+	ctx context.Context,
+	deviceCodeHash string,
+	clientID string,
+	slowDownIncrement time.Duration,
+) (*models.OAuthDeviceCode, bool, error) {
+	var code models.OAuthDeviceCode
+	slowDown := false
+
+	err := conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("device_code_hash = ? AND client_id = ?", deviceCodeHash, clientID).
+			First(&code).Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if !now.Before(code.ExpiresAt) {
+			return nil
+		}
+
+		if code.Status != models.DeviceCodeStatusPending {
+			return tx.Delete(&code).Error
+		}
+
+		if code.LastPolledAt != nil && now.Sub(*code.LastPolledAt) < time.Duration(code.Interval)*time.Second {
+			code.Interval += int(slowDownIncrement / time.Second)
+			slowDown = true
+		}
+		code.LastPolledAt = &now
+		return tx.Model(&code).Updates(map[string]any{
+			"interval":       code.Interval,
+			"last_polled_at": code.LastPolledAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &code, slowDown, nil
+}
@@ -0,0 +1,226 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Состояние очереди писем
+type OutboxStats struct {
+	// Количество писем по статусам (см. константы models.OutboxStatus*)
+	Counts map[string]int64
+	// Время создания самого старого ожидающего отправки письма, nil если таких нет
+	OldestPendingAt *time.Time
+}
+
+type GormOutboxRepo struct {
+	DB *gorm.DB
+}
+
+// Репозиторий очереди отправки писем (outbox)
+type OutboxRepo interface {
+	// Ставит письмо в очередь. Для атомарности с изменением, о котором сообщает письмо,
+	// вызывается внутри Transactor.WithinTransaction
+	Create(ctx context.Context, message *models.OutboxMessage) error
+	// Забирает до limit ожидающих писем, время попытки которых наступило, и откладывает их следующую попытку на lease,
+	// чтобы их не забрал другой обработчик. Если обработчик упадет до отметки результата, письма вернутся в очередь
+	// по истечении lease. Письма, заблокированные другой транзакцией, пропускаются
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	// Отмечает письмо отправленным и очищает его содержимое
+	MarkSent(ctx context.Context, id uuid.UUID, at time.Time) error
+	// Учитывает неудачную попытку и назначает следующую на nextAttemptAt
+	MarkFailed(ctx context.Context, id uuid.UUID, lastError string, nextAttemptAt time.Time) error
+	// Учитывает неудачную попытку и переводит письмо в статус models.OutboxStatusDead
+	MarkDead(ctx context.Context, id uuid.UUID, lastError string) error
+	// Находит письмо по uuid
+	FindByID(ctx context.Context, id uuid.UUID) (*models.OutboxMessage, error)
+	// Находит письма со статусом status (пустой - с любым) от новых к старым, без содержимого (Payload).
+	// Возвращает страницу из не более чем limit писем, начиная с offset, и общее количество найденных
+	Find(ctx context.Context, status string, offset int, limit int) ([]models.OutboxMessage, int64, error)
+	// Возвращает письмо со статусом models.OutboxStatusDead в очередь со сброшенным счетчиком попыток.
+	// Возвращает false, если письма нет, оно не в этом статусе или устарело к now
+	Retry(ctx context.Context, id uuid.UUID, now time.Time) (bool, error)
+	// Возвращает количество писем по статусам и время самого старого ожидающего письма
+	Stats(ctx context.Context) (*OutboxStats, error)
+	// Удаляет отправленные до before письма. Возвращает количество удаленных
+	DeleteSentBefore(ctx context.Context, before time.Time) (int64, error)
+	// Удаляет письма со статусом models.OutboxStatusDead, последний раз изменявшиеся до before.
+	// Возвращает количество удаленных
+	DeleteDeadBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Конструктор для создания экземпляра репозитория. Более предпочтительно, чем создание из голой структуры
+func NewOutboxRepo(db *gorm.DB) OutboxRepo {
+	return &GormOutboxRepo{
+		DB: db,
+	}
+}
+
+func (r *GormOutboxRepo) Create(ctx context.Context, message *models.OutboxMessage) error {
+	return conn(ctx, r.DB).Create(message).Error
+}
+
+func (r *GormOutboxRepo) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	var messages []models.OutboxMessage
+	err := conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.OutboxStatusPending, now).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&messages).Error
+		if err != nil || len(messages) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(messages))
+		for _, message := range messages {
+			ids = append(ids, message.ID)
+		}
+		return tx.Model(&models.OutboxMessage{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(lease)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *GormOutboxRepo) MarkSent(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return conn(ctx, r.DB).
+		Model(&models.OutboxMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":     models.OutboxStatusSent,
+			"sent_at":    at,
+			"payload":    "",
+			"last_error": "",
+		}).Error
+}
+
+func (r *GormOutboxRepo) MarkFailed(ctx context.Context, id uuid.UUID, lastError string, nextAttemptAt time.Time) error {
+	return conn(ctx, r.DB).
+		Model(&models.OutboxMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"last_error":      lastError,
+			"next_attempt_at": nextAttemptAt,
+		}).Error
+}
+
+func (r *GormOutboxRepo) MarkDead(ctx context.Context, id uuid.UUID, lastError string) error {
+	return conn(ctx, r.DB).
+		Model(&models.OutboxMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":     models.OutboxStatusDead,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"last_error": lastError,
+		}).Error
+}
+
+func (r *GormOutboxRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.OutboxMessage, error) {
+	var message models.OutboxMessage
+	err := conn(ctx, r.DB).First(&message, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (r *GormOutboxRepo) Find(ctx context.Context, status string, offset int, limit int) ([]models.OutboxMessage, int64, error) {
+	query := conn(ctx, r.DB).Model(&models.OutboxMessage{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	// сессия позволяет выполнить на одном запросе и подсчет, и выборку страницы
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var messages []models.OutboxMessage
+	// содержимое писем не нужно для просмотра очереди и может содержать ссылки и коды входа
+	err = query.Omit("payload").Order("created_at DESC").Offset(offset).Limit(limit).Find(&messages).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return messages, total, nil
+}
+
+func (r *GormOutboxRepo) Retry(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	result := conn(ctx, r.DB).
+		Model(&models.OutboxMessage{}).
+		Where("id = ? AND status = ?", id, models.OutboxStatusDead).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Updates(map[string]any{
+			"status":          models.OutboxStatusPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormOutboxRepo) Stats(ctx context.Context) (*OutboxStats, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := conn(ctx, r.DB).
+		Model(&models.OutboxMessage{}).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &OutboxStats{
+		Counts: map[string]int64{
+			models.OutboxStatusPending: 0,
+			models.OutboxStatusSent:    0,
+			models.OutboxStatusDead:    0,
+		},
+	}
+	for _, row := range rows {
+		stats.Counts[row.Status] = row.Count
+	}
+
+	var oldest []models.OutboxMessage
+	err = conn(ctx, r.DB).
+		Select("created_at").
+		Where("status = ?", models.OutboxStatusPending).
+		Order("created_at").
+		Limit(1).
+		Find(&oldest).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(oldest) > 0 {
+		stats.OldestPendingAt = &oldest[0].CreatedAt
+	}
+	return stats, nil
+}
+
+func (r *GormOutboxRepo) DeleteSentBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := conn(ctx, r.DB).Delete(&models.OutboxMessage{}, "status = ? AND sent_at < ?", models.OutboxStatusSent, before)
+	return result.RowsAffected, result.Error
+}
+
+func (r *GormOutboxRepo) DeleteDeadBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := conn(ctx, r.DB).Delete(&models.OutboxMessage{}, "status = ? AND updated_at < ?", models.OutboxStatusDead, before)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type GormRecoveryCodeRepo struct {
+	DB *gorm.DB
+}
+
+// Репозиторий кодов восстановления доступа
+type RecoveryCodeRepo interface {
+	// Заменяет все коды пользователя новым набором (старые коды удаляются в той же транзакции)
+	ReplaceForUser(ctx context.Context, userID uuid.UUID, codes []*models.RecoveryCode) error
+	// Находит все неиспользованные коды пользователя
+	FindUnusedByUserID(ctx context.Context, userID uuid.UUID) ([]models.RecoveryCode, error)
+	// Атомарно помечает код использованным. Возвращает false, если код уже был использован
+	MarkUsed(ctx context.Context, id uuid.UUID, at time.Time) (bool, error)
+	// Удаляет все коды пользователя
+	DeleteByUserID(ctx context.Context, userID uuid.UUID) error
+}
+
+// Конструктор для создания экземпляра репозитория. Более предпочтительно, чем создание из голой структуры
+func NewRecoveryCodeRepo(db *gorm.DB) RecoveryCodeRepo {
+	return &GormRecoveryCodeRepo{
+		DB: db,
+	}
+}
+
+func (r *GormRecoveryCodeRepo) ReplaceForUser(ctx context.Context, userID uuid.UUID, codes []*models.RecoveryCode) error {
+	return conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Delete(&models.RecoveryCode{}, "user_id = ?", userID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(codes).Error
+	})
+}
+
+func (r *GormRecoveryCodeRepo) FindUnusedByUserID(ctx context.Context, userID uuid.UUID) ([]models.RecoveryCode, error) {
+	var codes []models.RecoveryCode
+	err := conn(ctx, r.DB).Find(&codes, "user_id = ? AND used_at IS NULL", userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (r *GormRecoveryCodeRepo) MarkUsed(ctx context.Context, id uuid.UUID, at time.Time) (bool, error) {
+	result := conn(ctx, r.DB).
+		Model(&models.RecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormRecoveryCodeRepo) DeleteByUserID(ctx context.Context, userID uuid.UUID) error {
+	return conn(ctx, r.DB).Delete(&models.RecoveryCode{}, "user_id = ?", userID).Error
+}
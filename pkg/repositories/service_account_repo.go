@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"gorm.io/gorm"
+)
+
+type GormServiceAccountRepo struct {
+	DB *gorm.DB
+}
+
+// Репозиторий сервисных аккаунтов
+type ServiceAccountRepo interface {
+	Create(ctx context.Context, account *models.ServiceAccount) error
+	FindByID(ctx context.Context, clientID string) (*models.ServiceAccount, error)
+	FindAll(ctx context.Context) ([]models.ServiceAccount, error)
+	// Удаляет сервисный аккаунт. Возвращает false, если такого аккаунта нет
+	Delete(ctx context.Context, clientID string) (bool, error)
+}
+
+// Конструктор для создания экземпляра репозитория. Более предпочтительно, чем создание из голой структуры
+func NewServiceAccountRepo(db *gorm.DB) ServiceAccountRepo {
+	return &GormServiceAccountRepo{
+		DB: db,
+	}
+}
+
+func (r *GormServiceAccountRepo) Create(ctx context.Context, account *models.ServiceAccount) error {
+	return conn(ctx, r.DB).Create(account).Error
+}
+
+func (r *GormServiceAccountRepo) FindByID(ctx context.Context, clientID string) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	err := conn(ctx, r.DB).First(&account, "client_id = ?", clientID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (r *GormServiceAccountRepo) FindAll(ctx context.Context) ([]models.ServiceAccount, error) {
+	var accounts []models.ServiceAccount
+	err := conn(ctx, r.DB).Order("created_at").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (r *GormServiceAccountRepo) Delete(ctx context.Context, clientID string) (bool, error) {
+	result := conn(ctx, r.DB).Delete(&models.ServiceAccount{}, "client_id = ?", clientID)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
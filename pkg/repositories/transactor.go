@@ -0,0 +1,42 @@
+package repositories
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type GormTransactor struct {
+	DB *gorm.DB
+}
+
+// Выполнение операций нескольких репозиториев в одной транзакции
+type Transactor interface {
+	// Выполняет fn в транзакции. Репозитории, вызванные с контекстом, переданным в fn, работают в этой транзакции.
+	// Ошибка fn откатывает транзакцию. Вложенный вызов создает точку сохранения во внешней транзакции
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Ключ транзакции в контексте
+type txContextKey struct{}
+
+// Конструктор для создания экземпляра. Более предпочтительно, чем создание из голой структуры
+func NewTransactor(db *gorm.DB) Transactor {
+	return &GormTransactor{
+		DB: db,
+	}
+}
+
+func (t *GormTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return conn(ctx, t.DB).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txContextKey{}, tx))
+	})
+}
+
+// Возвращает транзакцию, открытую WithinTransaction, или db, если контекст не содержит транзакции
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txContextKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
@@ -2,12 +2,41 @@ package repositories
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Поля пользователя, по которым возможен поиск (см. UserCondition)
+const (
+	UserFieldID         = "user_id"
+	UserFieldEmail      = "email"
+	UserFieldExternalID = "external_id"
+	UserFieldActive     = "active"
+)
+
+// Операторы условий поиска пользователей. Сравнение email не чувствительно к регистру
+const (
+	UserOpEqual      = "eq"
+	UserOpNotEqual   = "ne"
+	UserOpContains   = "co"
+	UserOpStartsWith = "sw"
+	UserOpEndsWith   = "ew"
+)
+
+// Условие поиска пользователей. Field - одна из констант UserField*, Operator - одна из констант UserOp*.
+// Для UserFieldID и UserFieldActive поддерживаются только UserOpEqual и UserOpNotEqual
+type UserCondition struct {
+	Field    string
+	Operator string
+	Value    string
+}
+
 type GormUserRepo struct {
 	DB *gorm.DB
 }
@@ -18,11 +47,31 @@ type UserRepo interface {
 	Create(ctx context.Context, user *models.User) error
 	// Находит запись пользователя по его uuid
 	FindByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	// Находит запись пользователя по его uuid и блокирует ее (SELECT ... FOR UPDATE) до конца транзакции.
+	// Используется для сериализации изменений, проверяющих состояние пользователя, должен вызываться в транзакции
+	FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.User, error)
 	// Обертка вокруг FindByID, но не требует предварительного парсинга uuid
 	FindByIDString(ctx context.Context, idString string) (*models.User, error)
+	// Находит запись пользователя по его email без учета регистра
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	// Находит запись пользователя по sha256 хешу токена подтверждения смены email
+	FindByEmailConfirmToken(ctx context.Context, tokenHash string) (*models.User, error)
+	// Находит запись пользователя по sha256 хешу токена отмены смены email
+	FindByEmailCancelToken(ctx context.Context, tokenHash string) (*models.User, error)
+	// Находит запись пользователя по sha256 хешу токена разблокировки аккаунта
+	FindByUnlockToken(ctx context.Context, tokenHash string) (*models.User, error)
+	// Находит пользователей, удовлетворяющих всем условиям, в порядке создания. Возвращает страницу из не более чем
+	// limit записей, начиная с offset, и общее количество подходящих записей
+	Search(ctx context.Context, conditions []UserCondition, offset int, limit int) ([]models.User, int64, error)
+	// Атомарно увеличивает счетчик неудачных попыток входа и возвращает его новое значение
+	IncrementFailedLogins(ctx context.Context, id uuid.UUID, at time.Time) (int, error)
+	// Атомарно блокирует аккаунт до lockedUntil и сбрасывает счетчик неудачных попыток. Остальные поля записи
+	// не меняются, поэтому параллельные изменения пользователя не перезаписываются
+	Lock(ctx context.Context, id uuid.UUID, lockedUntil time.Time, unlockTokenHash string) error
 	// Обновляет запись пользователя исходя из его uuid переданного в обьекте (обновляет все поля)
 	Update(ctx context.Context, user *models.User) error
-	// Удаляет пользователя по его uuid
+	// Удаляет пользователя по его uuid вместе со всеми его записями: внешними личностями, passkey, кодами
+	// восстановления, запросами входа по email, незавершенными входами и OAuth кодами и токенами
 	DeleteByID(ctx context.Context, id uuid.UUID) error
 }
 
@@ -34,12 +83,21 @@ func NewUserRepo(db *gorm.DB) UserRepo {
 }
 
 func (r *GormUserRepo) Create(ctx context.Context, user *models.User) error {
-	return r.DB.WithContext(ctx).Create(user).Error
+	return conn(ctx, r.DB).Create(user).Error
 }
 
 func (r *GormUserRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	var user models.User
-	err := r.DB.WithContext(ctx).First(&user, "user_id = ?", id).Error
+	err := conn(ctx, r.DB).First(&user, "user_id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepo) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	var user models.User
+	err := conn(ctx, r.DB).Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "user_id = ?", id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -60,10 +118,175 @@ func (r *GormUserRepo) FindByIDString(ctx context.Context, idString string) (*mo
 	return user, nil
 }
 
+func (r *GormUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	err := conn(ctx, r.DB).First(&user, "LOWER(email) = ?", models.NormalizeEmail(email)).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepo) FindByEmailConfirmToken(ctx context.Context, tokenHash string) (*models.User, error) {
+	var user models.User
+	err := conn(ctx, r.DB).First(&user, "email_confirm_token = ?", tokenHash).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepo) FindByEmailCancelToken(ctx context.Context, tokenHash string) (*models.User, error) {
+	var user models.User
+	err := conn(ctx, r.DB).First(&user, "email_cancel_token = ?", tokenHash).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepo) FindByUnlockToken(ctx context.Context, tokenHash string) (*models.User, error) {
+	var user models.User
+	err := conn(ctx, r.DB).First(&user, "unlock_token = ?", tokenHash).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepo) IncrementFailedLogins(ctx context.Context, id uuid.UUID, at time.Time) (int, error) {
+	var user models.User
+	err := conn(ctx, r.DB).
+		Model(&user).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_login_attempts"}}}).
+		Where("user_id = ?", id).
+		Updates(map[string]interface{}{
+			"failed_login_attempts": gorm.Expr("failed_login_attempts + 1"),
+			"last_failed_login_at":  at,
+		}).Error
+	if err != nil {
+		return 0, err
+	}
+	return user.FailedLoginAttempts, nil
+}
+
+func (r *GormUserRepo) Lock(ctx context.Context, id uuid.UUID, lockedUntil time.Time, unlockTokenHash string) error {
+	return conn(ctx, r.DB).
+		Model(&models.User{}).
+		Where("user_id = ?", id).
+		Updates(map[string]interface{}{
+			"failed_login_attempts":   0,
+			"last_failed_login_at":    nil,
+			"locked_until":            lockedUntil,
+			"unlock_token":            unlockTokenHash,
+			"unlock_token_expires_at": lockedUntil,
+		}).Error
+}
+
 func (r *GormUserRepo) Update(ctx context.Context, user *models.User) error {
-	return r.DB.WithContext(ctx).Save(user).Error
+	return conn(ctx, r.DB).Save(user).Error
 }
 
 func (r *GormUserRepo) DeleteByID(ctx context.Context, id uuid.UUID) error {
-	return r.DB.WithContext(ctx).Delete(&models.User{}, "user_id = ?", id).Error
+	return conn(ctx, r.DB).Transaction(func(tx *gorm.DB) error {
+		// записи, ссылающиеся на пользователя, удаляются раньше него: иначе они пережили бы пользователя,
+		// а незавершенные входы и коды OAuth можно было бы завершить от его имени
+		dependents := []any{
+			&models.Identity{},
+			&models.FederatedLoginSession{},
+			&models.WebAuthnCredential{},
+			&models.WebAuthnSession{},
+			&models.RecoveryCode{},
+			&models.EmailLogin{},
+			&models.OAuthAuthorizationCode{},
+			&models.OAuthDeviceCode{},
+			&models.OAuthRefreshToken{},
+		}
+		for _, dependent := range dependents {
+			err := tx.Delete(dependent, "user_id = ?", id).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&models.User{}, "user_id = ?", id).Error
+	})
+}
+
+func (r *GormUserRepo) Search(ctx context.Context, conditions []UserCondition, offset int, limit int) ([]models.User, int64, error) {
+	query := conn(ctx, r.DB).Model(&models.User{})
+	for _, condition := range conditions {
+		var err error
+		query, err = applyUserCondition(query, condition)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// сессия позволяет выполнить на одном запросе и подсчет, и выборку страницы
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := []models.User{}
+	if limit <= 0 || int64(offset) >= total {
+		return users, total, nil
+	}
+	err = query.Order("created_at, user_id").Offset(offset).Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func applyUserCondition(query *gorm.DB, condition UserCondition) (*gorm.DB, error) {
+	switch condition.Field {
+	case UserFieldID:
+		// uuid, который не разбирается, не совпадает ни с одним пользователем
+		id, err := uuid.Parse(condition.Value)
+		if err != nil {
+			if condition.Operator == UserOpNotEqual {
+				return query, nil
+			}
+			return query.Where("1 = 0"), nil
+		}
+		return compareUserField(query, "user_id", condition.Operator, id)
+	case UserFieldActive:
+		return compareUserField(query, "active", condition.Operator, condition.Value == "true")
+	case UserFieldEmail:
+		return matchUserField(query, "LOWER(email)", condition.Operator, strings.ToLower(condition.Value))
+	case UserFieldExternalID:
+		return matchUserField(query, "external_id", condition.Operator, condition.Value)
+	default:
+		return nil, fmt.Errorf("unsupported user search field %q", condition.Field)
+	}
+}
+
+func compareUserField(query *gorm.DB, column string, operator string, value any) (*gorm.DB, error) {
+	switch operator {
+	case UserOpEqual:
+		return query.Where(column+" = ?", value), nil
+	case UserOpNotEqual:
+		return query.Where(column+" <> ?", value), nil
+	default:
+		return nil, fmt.Errorf("unsupported operator %q for %s", operator, column)
+	}
+}
+
+func matchUserField(query *gorm.DB, column string, operator string, value string) (*gorm.DB, error) {
+	// спецсимволы LIKE в значении экранируются, чтобы они искались как обычные символы
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+	switch operator {
+	case UserOpContains:
+		return query.Where(column+" LIKE ?", "%"+pattern+"%"), nil
+	case UserOpStartsWith:
+		return query.Where(column+" LIKE ?", pattern+"%"), nil
+	case UserOpEndsWith:
+		return query.Where(column+" LIKE ?", "%"+pattern), nil
+	default:
+		return compareUserField(query, column, operator, value)
+	}
 }
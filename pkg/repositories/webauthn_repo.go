@@ -0,0 +1,89 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/AlexandrShapkin/auth-go-test-task/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GormWebAuthnRepo struct {
+	DB *gorm.DB
+}
+
+// Репозиторий WebAuthn учетных данных и незавершенных церемоний
+type WebAuthnRepo interface {
+	// Сохраняет новые учетные данные
+	CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error
+	// Находит все учетные данные пользователя
+	FindCredentialsByUserID(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error)
+	// Обновляет учетные данные (счетчик подписей, флаги, время использования)
+	UpdateCredential(ctx context.Context, credential *models.WebAuthnCredential) error
+	// Удаляет учетные данные пользователя по uuid записи. Возвращает false, если таких учетных данных нет
+	DeleteCredential(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error)
+	// Сохраняет данные незавершенной церемонии
+	CreateSession(ctx context.Context, session *models.WebAuthnSession) error
+	// Атомарно находит и удаляет данные церемонии, чтобы их нельзя было использовать повторно.
+	// Просроченные церемонии не возвращаются
+	TakeSession(ctx context.Context, id string, purpose string) (*models.WebAuthnSession, error)
+}
+
+// Конструктор для создания экземпляра репозитория. Более предпочтительно, чем создание из голой структуры
+func NewWebAuthnRepo(db *gorm.DB) WebAuthnRepo {
+	return &GormWebAuthnRepo{
+		DB: db,
+	}
+}
+
+func (r *GormWebAuthnRepo) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	return conn(ctx, r.DB).Create(credential).Error
+}
+
+func (r *GormWebAuthnRepo) FindCredentialsByUserID(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	err := conn(ctx, r.DB).Order("created_at").Find(&credentials, "user_id = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (r *GormWebAuthnRepo) UpdateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	return conn(ctx, r.DB).Save(credential).Error
+}
+
+func (r *GormWebAuthnRepo) DeleteCredential(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error) {
+	result := conn(ctx, r.DB).Delete(&models.WebAuthnCredential{}, "user_id = ? AND id = ?", userID, id)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormWebAuthnRepo) CreateSession(ctx context.Context, session *models.WebAuthnSession) error {
+	db := conn(ctx, r.DB)
+	// заодно чистятся брошенные церемонии
+	err := db.Delete(&models.WebAuthnSession{}, "expires_at < ?", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return db.Create(session).Error
+}
+
+func (r *GormWebAuthnRepo) TakeSession(ctx context.Context, id string, purpose string) (*models.WebAuthnSession, error) {
+	var sessions []models.WebAuthnSession
+	err := conn(ctx, r.DB).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND purpose = ? AND expires_at > ?", id, purpose, time.Now()).
+		Delete(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &sessions[0], nil
+}
@@ -0,0 +1,11 @@
+package scim
+
+import "errors"
+
+var (
+	ErrInvalidFilter         = errors.New("invalid or unsupported filter")
+	ErrInvalidPath           = errors.New("invalid or unsupported patch path")
+	ErrInvalidValue          = errors.New("invalid attribute value")
+	ErrUnsupportedPatchOp    = errors.New("unsupported patch operation")
+	ErrAttributeNotRemovable = errors.New("attribute can not be removed")
+)
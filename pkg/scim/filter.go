@@ -0,0 +1,143 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Атрибуты пользователя, по которым поддерживается фильтрация
+const (
+	AttributeID          = "id"
+	AttributeExternalID  = "externalId"
+	AttributeUserName    = "userName"
+	AttributeEmailsValue = "emails.value"
+	AttributeActive      = "active"
+)
+
+// Операторы сравнения фильтра (RFC 7644, раздел 3.4.2.2)
+const (
+	OperatorEqual      = "eq"
+	OperatorNotEqual   = "ne"
+	OperatorContains   = "co"
+	OperatorStartsWith = "sw"
+	OperatorEndsWith   = "ew"
+)
+
+// Условие фильтра вида <атрибут> <оператор> <значение>. Attribute - одна из констант Attribute*,
+// Operator - одна из констант Operator*, Value - строковое значение (true/false для active)
+type Condition struct {
+	Attribute string
+	Operator  string
+	Value     string
+}
+
+var filterAttributes = map[string]string{
+	"id":           AttributeID,
+	"externalid":   AttributeExternalID,
+	"username":     AttributeUserName,
+	"emails":       AttributeEmailsValue,
+	"emails.value": AttributeEmailsValue,
+	"active":       AttributeActive,
+}
+
+// Разбирает фильтр из параметра filter. Поддерживаются условия, объединенные через and,
+// без скобок, or и not. Имена атрибутов и операторов не чувствительны к регистру.
+// Пустой фильтр возвращает пустой список условий
+func ParseFilter(filter string) ([]Condition, error) {
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []Condition{}
+	for len(tokens) > 0 {
+		if len(conditions) > 0 {
+			if !strings.EqualFold(tokens[0], "and") {
+				return nil, fmt.Errorf("%w: expected and, got %q", ErrInvalidFilter, tokens[0])
+			}
+			tokens = tokens[1:]
+		}
+		if len(tokens) < 3 {
+			return nil, fmt.Errorf("%w: incomplete expression", ErrInvalidFilter)
+		}
+
+		condition, err := parseCondition(tokens[0], tokens[1], tokens[2])
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+		tokens = tokens[3:]
+	}
+	return conditions, nil
+}
+
+func parseCondition(attribute string, operator string, value string) (Condition, error) {
+	canonical, ok := filterAttributes[strings.ToLower(attribute)]
+	if !ok {
+		return Condition{}, fmt.Errorf("%w: unsupported attribute %q", ErrInvalidFilter, attribute)
+	}
+
+	operator = strings.ToLower(operator)
+	switch operator {
+	case OperatorEqual, OperatorNotEqual:
+	case OperatorContains, OperatorStartsWith, OperatorEndsWith:
+		if canonical == AttributeActive || canonical == AttributeID {
+			return Condition{}, fmt.Errorf("%w: operator %s is not supported for %s", ErrInvalidFilter, operator, canonical)
+		}
+	default:
+		return Condition{}, fmt.Errorf("%w: unsupported operator %q", ErrInvalidFilter, operator)
+	}
+
+	if canonical == AttributeActive {
+		value = strings.ToLower(value)
+		if value != "true" && value != "false" {
+			return Condition{}, fmt.Errorf("%w: active must be compared with true or false", ErrInvalidFilter)
+		}
+		return Condition{Attribute: canonical, Operator: operator, Value: value}, nil
+	}
+
+	if !strings.HasPrefix(value, `"`) {
+		return Condition{}, fmt.Errorf("%w: %s must be compared with a string", ErrInvalidFilter, canonical)
+	}
+	var unquoted string
+	err := json.Unmarshal([]byte(value), &unquoted)
+	if err != nil {
+		return Condition{}, fmt.Errorf("%w: invalid string %s", ErrInvalidFilter, value)
+	}
+	return Condition{Attribute: canonical, Operator: operator, Value: unquoted}, nil
+}
+
+// Разбивает фильтр на слова, строки в кавычках остаются одним словом вместе с кавычками
+func tokenizeFilter(filter string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(filter); {
+		switch {
+		case filter[i] == ' ' || filter[i] == '\t':
+			i++
+		case filter[i] == '(' || filter[i] == ')' || filter[i] == '[' || filter[i] == ']':
+			return nil, fmt.Errorf("%w: grouping is not supported", ErrInvalidFilter)
+		case filter[i] == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+			tokens = append(tokens, filter[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && filter[end] != ' ' && filter[end] != '\t' && filter[end] != '"' {
+				end++
+			}
+			tokens = append(tokens, filter[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
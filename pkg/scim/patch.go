@@ -0,0 +1,169 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Операции PATCH (RFC 7644, раздел 3.5.2)
+const (
+	PatchOpAdd     = "add"
+	PatchOpReplace = "replace"
+	PatchOpRemove  = "remove"
+)
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Применяет операции PATCH к ресурсу пользователя. Поддерживаются пути active, userName, externalId, password,
+// emails, emails.value и emails[<фильтр>].value (у пользователя один адрес, поэтому фильтр не разбирается),
+// а также add и replace без пути со значением-объектом. Имена операций и атрибутов не чувствительны к регистру,
+// атрибуты могут быть указаны с префиксом схемы пользователя.
+//
+// При ошибке ресурс может быть изменен частично, поэтому его нужно отбросить
+func ApplyPatch(user *User, operations []PatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		switch op {
+		case PatchOpAdd, PatchOpReplace:
+			err := applyPatchValue(user, operation.Path, operation.Value)
+			if err != nil {
+				return err
+			}
+		case PatchOpRemove:
+			err := removeAttribute(user, operation.Path)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: %q", ErrUnsupportedPatchOp, operation.Op)
+		}
+	}
+	return nil
+}
+
+func applyPatchValue(user *User, path string, value json.RawMessage) error {
+	if len(value) == 0 {
+		return fmt.Errorf("%w: value is required", ErrInvalidValue)
+	}
+	if path != "" {
+		return setAttribute(user, path, value)
+	}
+
+	attributes := map[string]json.RawMessage{}
+	err := json.Unmarshal(value, &attributes)
+	if err != nil {
+		return fmt.Errorf("%w: value without path must be an object", ErrInvalidValue)
+	}
+	for name, attributeValue := range attributes {
+		err := setAttribute(user, name, attributeValue)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setAttribute(user *User, path string, value json.RawMessage) error {
+	switch normalizePath(path) {
+	case "active":
+		active, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		user.Active = &active
+	case "username":
+		var userName string
+		err := json.Unmarshal(value, &userName)
+		if err != nil || userName == "" {
+			return fmt.Errorf("%w: userName must be a non-empty string", ErrInvalidValue)
+		}
+		user.UserName = userName
+	case "externalid":
+		err := json.Unmarshal(value, &user.ExternalID)
+		if err != nil {
+			return fmt.Errorf("%w: externalId must be a string", ErrInvalidValue)
+		}
+	case "password":
+		err := json.Unmarshal(value, &user.Password)
+		if err != nil {
+			return fmt.Errorf("%w: password must be a string", ErrInvalidValue)
+		}
+	case "emails":
+		var emails []Email
+		err := json.Unmarshal(value, &emails)
+		if err != nil {
+			return fmt.Errorf("%w: emails must be an array", ErrInvalidValue)
+		}
+		user.Emails = emails
+	case "emails.value":
+		var email string
+		err := json.Unmarshal(value, &email)
+		if err != nil {
+			return fmt.Errorf("%w: email must be a string", ErrInvalidValue)
+		}
+		user.Emails = []Email{{Value: email, Primary: true}}
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	return nil
+}
+
+func removeAttribute(user *User, path string) error {
+	switch normalizePath(path) {
+	case "externalid":
+		user.ExternalID = ""
+	case "emails", "emails.value":
+		// адрес пользователя берется из userName, без emails он останется прежним
+		user.Emails = nil
+	case "":
+		return fmt.Errorf("%w: path is required for remove", ErrInvalidPath)
+	case "active", "username", "password":
+		return fmt.Errorf("%w: %s", ErrAttributeNotRemovable, path)
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	return nil
+}
+
+// Приводит путь к нижнему регистру без префикса схемы. Фильтр значений emails[...] отбрасывается
+func normalizePath(path string) string {
+	path = strings.ToLower(path)
+	path = strings.TrimPrefix(path, strings.ToLower(SchemaUser)+":")
+	if strings.HasPrefix(path, "emails[") {
+		end := strings.Index(path, "]")
+		if end < 0 {
+			return path
+		}
+		path = "emails" + path[end+1:]
+	}
+	return path
+}
+
+// Разбирает булево значение. Некоторые клиенты отправляют его строкой ("True", "false")
+func parseBool(value json.RawMessage) (bool, error) {
+	var result bool
+	if json.Unmarshal(value, &result) == nil {
+		return result, nil
+	}
+
+	var text string
+	if json.Unmarshal(value, &text) == nil {
+		switch strings.ToLower(text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("%w: expected boolean", ErrInvalidValue)
+}
@@ -0,0 +1,98 @@
+package scim
+
+// Максимальное количество ресурсов в ответе на запрос списка
+const MaxResults = 200
+
+// Описание возможностей сервиса (RFC 7643, раздел 5). baseURL - адрес /scim/v2 сервиса
+func ServiceProviderConfig(baseURL string) map[string]any {
+	return map[string]any{
+		"schemas":          []string{SchemaServiceProviderConfig},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            map[string]any{"supported": true},
+		"bulk":             map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]any{"supported": true, "maxResults": MaxResults},
+		"changePassword":   map[string]any{"supported": true},
+		"sort":             map[string]any{"supported": false},
+		"etag":             map[string]any{"supported": false},
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Access token of a service account with scope scim, issued by the client_credentials grant",
+			"specUri":     "https://datatracker.ietf.org/doc/html/rfc6750",
+			"primary":     true,
+		}},
+		"meta": map[string]any{
+			"resourceType": "ServiceProviderConfig",
+			"location":     baseURL + "/ServiceProviderConfig",
+		},
+	}
+}
+
+// Описания поддерживаемых типов ресурсов
+func ResourceTypes(baseURL string) []map[string]any {
+	return []map[string]any{{
+		"schemas":     []string{SchemaResourceType},
+		"id":          ResourceTypeUser,
+		"name":        ResourceTypeUser,
+		"endpoint":    "/Users",
+		"description": "User Account",
+		"schema":      SchemaUser,
+		"meta": map[string]any{
+			"resourceType": "ResourceType",
+			"location":     baseURL + "/ResourceTypes/" + ResourceTypeUser,
+		},
+	}}
+}
+
+// Описания поддерживаемых схем. Схема пользователя содержит только атрибуты, которые хранит сервис
+func Schemas(baseURL string) []map[string]any {
+	return []map[string]any{{
+		"schemas":     []string{SchemaSchema},
+		"id":          SchemaUser,
+		"name":        ResourceTypeUser,
+		"description": "User Account",
+		"attributes": []map[string]any{
+			schemaAttribute("userName", "string", "Email of the user, used as the login identifier", true, "readWrite", "server"),
+			schemaAttribute("externalId", "string", "Identifier of the user in the provisioning client", false, "readWrite", "none"),
+			schemaAttribute("active", "boolean", "Whether the user can log in. Deactivation revokes all sessions", false, "readWrite", "none"),
+			schemaAttribute("password", "string", "Password of the user, checked by the password policy", false, "writeOnly", "none"),
+			{
+				"name":        "emails",
+				"type":        "complex",
+				"multiValued": true,
+				"description": "Email of the user, always equal to userName",
+				"required":    false,
+				"mutability":  "readWrite",
+				"returned":    "default",
+				"uniqueness":  "none",
+				"subAttributes": []map[string]any{
+					schemaAttribute("value", "string", "Email address", false, "readWrite", "server"),
+					schemaAttribute("type", "string", "Label of the address", false, "readWrite", "none"),
+					schemaAttribute("primary", "boolean", "Whether the address is primary", false, "readWrite", "none"),
+				},
+			},
+		},
+		"meta": map[string]any{
+			"resourceType": "Schema",
+			"location":     baseURL + "/Schemas/" + SchemaUser,
+		},
+	}}
+}
+
+func schemaAttribute(name string, attributeType string, description string, required bool, mutability string, uniqueness string) map[string]any {
+	returned := "default"
+	if mutability == "writeOnly" {
+		returned = "never"
+	}
+	return map[string]any{
+		"name":        name,
+		"type":        attributeType,
+		"multiValued": false,
+		"description": description,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    returned,
+		"uniqueness":  uniqueness,
+	}
+}